@@ -0,0 +1,523 @@
+package flow
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/manager"
+	"github.com/zone-7/chatflow_engine/engine/meta"
+	"github.com/zone-7/chatflow_engine/engine/provider"
+	"github.com/zone-7/chatflow_engine/engine/utils"
+)
+
+const (
+	AGENT_TOOL_WIDGET    = "widget"           //工具流程
+	AGENT_TOOL_KNOWLEDGE = "knowledge_search" //知识库检索
+	AGENT_TOOL_DB_SQL    = "db_sql"           //数据库查询
+	AGENT_TOOL_NET       = "net_request"      //网络请求
+)
+
+func init() {
+	andflow.RegistActionRunner("agent", &AgentRunner{})
+}
+
+// 智能体可以使用的工具
+type AgentTool struct {
+	Name        string `json:"name"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Type        string `json:"type"` //widget、knowledge_search、db_sql、net_request
+
+	//工具流程
+	FlowCode       string `json:"flow_code"`
+	ResponseParams string `json:"response_params"`
+
+	//知识库检索
+	KnowledgeId string  `json:"knowledge_id"`
+	Limit       int     `json:"limit"`
+	Score       float64 `json:"score"`
+
+	//数据库查询，SQL中使用?作为参数，工具输入为参数数组
+	Drivername string `json:"drivername"`
+	Datasource string `json:"datasource"` //可以使用 {{secret "name"}} 和 {{env.NAME}}
+	Sql        string `json:"sql"`
+
+	//网络请求，地址、请求头和请求体中可以使用 {{input}} 及输入JSON中的字段
+	Url          string            `json:"url"`
+	Method       string            `json:"method"`
+	Headers      map[string]string `json:"headers"`
+	BodyTemplate string            `json:"body_template"`
+}
+
+// 模型输出的一步
+type agentStep struct {
+	Thought     string
+	Action      string
+	ActionInput string
+	FinalAnswer string
+	IsFinal     bool
+}
+
+type AgentRunner struct {
+	BaseRunner
+}
+
+func (r *AgentRunner) Properties() []andflow.Prop {
+	return []andflow.Prop{}
+}
+func (r *AgentRunner) Execute(s *andflow.Session, param *andflow.ActionParam, state *andflow.ActionStateModel) (andflow.Result, error) {
+	action := s.GetFlow().GetAction(param.ActionId)
+	chatSession := r.getChatSession(s)
+
+	log.Printf("agent begin: %v", time.Now())
+	defer log.Printf("agent end: %v", time.Now())
+
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
+	if err != nil {
+		return andflow.RESULT_FAILURE, err
+	}
+
+	content_source := prop["content_source"] //信息来自参数还是输入
+	content_temp := prop["content_temp"]     //信息来自哪个参数
+
+	res_chat := prop["res_chat"]   //输出到对话
+	param_key := prop["param_key"] //最终回答
+	req_cos := prop["req_cosplay"] //角色设定
+
+	max_steps := prop["max_steps"]                 //最大步数
+	max_tokens_budget := prop["max_tokens_budget"] //最大token数
+	max_observation := prop["max_observation"]     //工具返回内容的最大长度
+
+	//工具定义中的模版在调用工具时替换
+	tools_json := r.getActionParam(s, action, "tools", nil)
+
+	chatting, params, err := r.getChatting(s, prop, "")
+	if err != nil {
+		return andflow.RESULT_FAILURE, err
+	}
+
+	tools := make([]*AgentTool, 0)
+	if len(tools_json) > 0 {
+		err = json.Unmarshal([]byte(tools_json), &tools)
+		if err != nil {
+			return andflow.RESULT_FAILURE, errors.New("工具定义格式错误")
+		}
+	}
+	for _, t := range tools {
+		if len(t.Name) == 0 {
+			return andflow.RESULT_FAILURE, errors.New("工具名称不能为空")
+		}
+	}
+
+	steps := 6
+	if len(max_steps) > 0 {
+		steps, _ = utils.StringToInt(max_steps)
+	}
+	if steps <= 0 {
+		steps = 6
+	}
+
+	budget := 8000
+	if len(max_tokens_budget) > 0 {
+		budget, _ = utils.StringToInt(max_tokens_budget)
+	}
+	if budget <= 0 {
+		budget = 8000
+	}
+
+	observation_size := 2000
+	if len(max_observation) > 0 {
+		observation_size, _ = utils.StringToInt(max_observation)
+	}
+	if observation_size <= 0 {
+		observation_size = 2000
+	}
+
+	question := ""
+	if content_source == "temp" && len(content_temp) > 0 {
+		question = content_temp
+	} else {
+		question = chatSession.GetCurrentRequestMessagesContent(1)
+		if len(question) == 0 {
+			return andflow.RESULT_REJECT, nil
+		}
+	}
+
+	//草稿，记录每一步的思考、动作和观察结果
+	scratchpad_key := "agent_scratchpad_" + action.Id
+	scratchpad := ""
+	s.SetParam(scratchpad_key, scratchpad)
+
+	system_prompt := r.getSystemPrompt(req_cos, tools)
+
+	used_tokens := 0
+	answer := ""
+	finished := false
+
+	for i := 0; i <= steps; i++ {
+		if s.Operation.GetCmd() == andflow.CMD_STOP {
+			return andflow.RESULT_FAILURE, errors.New("用户停止")
+		}
+
+		//步数用完，要求直接给出最终回答
+		last := i == steps
+
+		user_prompt := "问题: " + question + "\n"
+		if len(scratchpad) > 0 {
+			user_prompt += "\n已经完成的步骤:\n" + scratchpad + "\n"
+		}
+		if last {
+			user_prompt += "\n已经没有可用的步骤，请根据以上信息直接给出 Final Answer。"
+		} else {
+			user_prompt += "\n请给出下一步。"
+		}
+
+		messages := []provider.ChatMessage{
+			{Role: provider.MESSAGE_ROLE_SYSTEM, Content: system_prompt},
+			{Role: provider.MESSAGE_ROLE_USER, Content: user_prompt},
+		}
+
+		prompt_tokens := estimateTokens(system_prompt) + estimateTokens(user_prompt)
+		if used_tokens+prompt_tokens > budget {
+			return andflow.RESULT_FAILURE, fmt.Errorf("超过token预算: %d", budget)
+		}
+
+		output, err := r.chatText(s, chatting, params, messages)
+		if err != nil {
+			log.Printf("agent执行异常:%v", err)
+			return andflow.RESULT_FAILURE, err
+		}
+		used_tokens += prompt_tokens + estimateTokens(output)
+
+		step := parseAgentStep(output)
+
+		if len(step.Thought) > 0 {
+			chatSession.ResponseWaitting("思考: " + step.Thought)
+		}
+
+		if step.IsFinal || last || len(step.Action) == 0 {
+			answer = step.FinalAnswer
+			if !step.IsFinal {
+				answer = strings.Trim(output, " \r\n")
+			}
+			finished = true
+			break
+		}
+
+		chatSession.ResponseWaitting("调用工具: " + step.Action)
+
+		observation, err := r.callTool(s, chatSession, tools, step.Action, step.ActionInput)
+		if err != nil {
+			observation = "工具调用失败: " + err.Error()
+		}
+		observation = truncateText(observation, observation_size)
+
+		scratchpad += "Thought: " + step.Thought + "\n"
+		scratchpad += "Action: " + step.Action + "\n"
+		scratchpad += "Action Input: " + step.ActionInput + "\n"
+		scratchpad += "Observation: " + observation + "\n"
+		s.SetParam(scratchpad_key, scratchpad)
+	}
+
+	if !finished {
+		return andflow.RESULT_FAILURE, errors.New("智能体没有给出最终回答")
+	}
+
+	//输出到对话
+	if (res_chat == "true" || res_chat == "1") && len(answer) > 0 {
+		uid, _ := uuid.NewV4()
+		mid := strings.ReplaceAll(uid.String(), "-", "")
+		chatSession.Response(meta.ChatFlowMessage{MessageId: mid, MessageType: meta.CHAT_MESSAGE_TYPE_MESSAGE, Format: meta.CHAT_MESSAGE_FORMAT_TEXT, Role: meta.CHAT_MESSAGE_ROLE_ASSISTANT, Content: answer, Finish: "yes"}, true)
+	}
+
+	if len(param_key) > 0 {
+		s.SetParam(param_key, answer)
+	}
+
+	return andflow.RESULT_SUCCESS, nil
+}
+
+// 系统提示词，说明工具和回答格式
+func (r *AgentRunner) getSystemPrompt(cosplay string, tools []*AgentTool) string {
+	prompt := ""
+	if len(cosplay) > 0 {
+		prompt += cosplay + "\n\n"
+	}
+
+	prompt += "你需要一步一步地解决用户的问题，每一步只能调用一个工具。\n"
+	if len(tools) > 0 {
+		prompt += "可以使用以下工具:\n"
+		for _, t := range tools {
+			prompt += "- " + t.Name + ": " + t.Description + "\n"
+		}
+	} else {
+		prompt += "当前没有可以使用的工具。\n"
+	}
+
+	prompt += "\n请严格按照以下格式回答，不要编造 Observation:\n"
+	prompt += "Thought: 你的思考\n"
+	prompt += "Action: 工具名称\n"
+	prompt += "Action Input: 工具输入，可以是文本或JSON\n"
+	prompt += "\n如果已经可以回答问题，使用以下格式:\n"
+	prompt += "Thought: 你的思考\n"
+	prompt += "Final Answer: 最终回答\n"
+
+	return prompt
+}
+
+// 解析模型输出
+func parseAgentStep(output string) agentStep {
+	step := agentStep{}
+
+	//模型自己编造的观察结果不要
+	if idx := strings.Index(output, "Observation:"); idx >= 0 {
+		output = output[:idx]
+	}
+
+	if idx := strings.Index(output, "Final Answer:"); idx >= 0 {
+		step.IsFinal = true
+		step.FinalAnswer = strings.Trim(output[idx+len("Final Answer:"):], " \r\n")
+		output = output[:idx]
+	}
+
+	actionIdx := strings.Index(output, "Action:")
+	inputIdx := strings.Index(output, "Action Input:")
+
+	thought := output
+	if actionIdx >= 0 {
+		thought = output[:actionIdx]
+	}
+	thought = strings.Replace(thought, "Thought:", "", 1)
+	step.Thought = strings.Trim(thought, " \r\n")
+
+	if step.IsFinal || actionIdx < 0 {
+		return step
+	}
+
+	if inputIdx > actionIdx {
+		step.Action = output[actionIdx+len("Action:") : inputIdx]
+		step.ActionInput = strings.Trim(output[inputIdx+len("Action Input:"):], " \r\n")
+	} else {
+		step.Action = output[actionIdx+len("Action:"):]
+	}
+	step.Action = strings.Trim(strings.Split(strings.Trim(step.Action, " \r\n"), "\n")[0], " \r`")
+
+	return step
+}
+
+// 截断过长的文本
+func truncateText(text string, size int) string {
+	runes := []rune(text)
+	if len(runes) <= size {
+		return text
+	}
+	return string(runes[:size]) + "..."
+}
+
+// 执行工具
+func (r *AgentRunner) callTool(s *andflow.Session, chatSession *ChatSession, tools []*AgentTool, name string, input string) (string, error) {
+	var tool *AgentTool
+	for _, t := range tools {
+		if t.Name == name {
+			tool = t
+			break
+		}
+	}
+	if tool == nil {
+		return "", errors.New("工具不存在: " + name)
+	}
+
+	//工具输入为JSON对象时，字段可以作为参数使用
+	args := make(map[string]interface{})
+	json.Unmarshal([]byte(input), &args)
+
+	switch tool.Type {
+	case AGENT_TOOL_WIDGET, "":
+		return r.callWidget(s, chatSession, tool, input, args)
+	case AGENT_TOOL_KNOWLEDGE:
+		return r.callKnowledge(chatSession, tool, input)
+	case AGENT_TOOL_DB_SQL:
+		return r.callDbSql(s, tool, input)
+	case AGENT_TOOL_NET:
+		return r.callNetRequest(s, tool, input, args)
+	}
+
+	return "", errors.New("工具类型不支持: " + tool.Type)
+}
+
+func (r *AgentRunner) callWidget(s *andflow.Session, chatSession *ChatSession, tool *AgentTool, input string, args map[string]interface{}) (string, error) {
+	flow_params := map[string]string{"input": input}
+	for k, v := range args {
+		if str, ok := v.(string); ok {
+			flow_params[k] = str
+		} else {
+			data, _ := json.Marshal(v)
+			flow_params[k] = string(data)
+		}
+	}
+
+	output, subflowParams, err := invokeWidgetFlow(chatSession, s.Operation.GetRequestId(), tool.FlowCode, input, flow_params)
+	if err != nil {
+		return "", err
+	}
+
+	keys := getWords(tool.ResponseParams)
+	if len(keys) > 0 {
+		res := make(map[string]interface{})
+		for _, k := range keys {
+			if v, ok := subflowParams[k]; ok {
+				res[k] = v
+			}
+		}
+		data, err := json.Marshal(res)
+		return string(data), err
+	}
+	return output, nil
+}
+
+func (r *AgentRunner) callKnowledge(chatSession *ChatSession, tool *AgentTool, input string) (string, error) {
+	if len(tool.KnowledgeId) == 0 {
+		return "", errors.New("知识库不能为空")
+	}
+	limit := tool.Limit
+	if limit <= 0 {
+		limit = 3
+	}
+
+	kno := manager.KnowledgeManager{Opt: chatSession.Opt}
+	results, err := kno.SearchKnowledge(tool.KnowledgeId, input, tool.Score, limit)
+	if err != nil {
+		return "", err
+	}
+
+	textArr := make([]string, 0)
+	for _, result := range results {
+		if payload, ok := result.Payload.(map[string]interface{}); ok {
+			textArr = append(textArr, fmt.Sprintf("%v", payload["text"]))
+		}
+	}
+	if len(textArr) == 0 {
+		return "没有找到相关内容", nil
+	}
+	return strings.Join(textArr, "\n"), nil
+}
+
+// 只允许查询，工具输入作为SQL参数
+func (r *AgentRunner) callDbSql(s *andflow.Session, tool *AgentTool, input string) (string, error) {
+	sql := strings.TrimRight(strings.Trim(tool.Sql, " \t\r\n"), "; \t\r\n")
+	if len(tool.Datasource) == 0 || len(sql) == 0 {
+		return "", errors.New("数据库地址和SQL不能为空")
+	}
+	lower := strings.ToLower(sql)
+	if !strings.HasPrefix(lower, "select") && !strings.HasPrefix(lower, "with") {
+		return "", errors.New("只允许查询SQL")
+	}
+	//不允许多条语句，查询在只读事务中执行
+	if strings.Contains(sql, ";") {
+		return "", errors.New("只允许一条查询SQL")
+	}
+
+	args := make([]interface{}, 0)
+	if strings.Count(sql, "?") > 0 {
+		if err := json.Unmarshal([]byte(input), &args); err != nil {
+			args = []interface{}{input}
+		}
+	}
+
+	//数据库地址中可以引用密钥和环境变量，不使用对话参数
+	datasource, err := r.renderTemplate(s, tool.Datasource, "agent_datasource_"+tool.Name, map[string]interface{}{})
+	if err != nil {
+		return "", err
+	}
+
+	//连接按数据库地址区分，不同流程的同名工具不能共用连接
+	hash := md5.Sum([]byte(tool.Drivername + "\n" + datasource))
+	alias := "agent_" + tool.Drivername + "_" + hex.EncodeToString(hash[:])
+	data, err := dbQueryReadOnly(tool.Drivername, alias, datasource, sql, args...)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := json.Marshal(data)
+	return string(res), err
+}
+
+func (r *AgentRunner) callNetRequest(s *andflow.Session, tool *AgentTool, input string, args map[string]interface{}) (string, error) {
+	if len(tool.Url) == 0 {
+		return "", errors.New("地址不能为空")
+	}
+
+	ps := s.GetParamMap()
+	url_ps := make(map[string]interface{})
+	for k, v := range ps {
+		url_ps[k] = v
+	}
+	for k, v := range args {
+		ps[k] = v
+		url_ps[k] = url.QueryEscape(fmt.Sprintf("%v", v))
+	}
+	ps["input"] = input
+	url_ps["input"] = url.QueryEscape(input)
+
+	dist_url, err := r.renderTemplate(s, tool.Url, "agent_url_"+tool.Name, url_ps)
+	if err != nil {
+		return "", err
+	}
+	body, err := r.renderTemplate(s, tool.BodyTemplate, "agent_body_"+tool.Name, ps)
+	if err != nil {
+		return "", err
+	}
+
+	method := strings.ToUpper(tool.Method)
+	if len(method) == 0 {
+		method = http.MethodGet
+	}
+
+	req, err := http.NewRequest(method, dist_url, strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	for k, v := range tool.Headers {
+		vv, err := r.renderTemplate(s, v, "agent_header_"+tool.Name, ps)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set(k, vv)
+	}
+
+	//按照工作空间的请求限制访问
+	policy := r.getChatSession(s).Opt.HttpPolicy
+	err = checkHttpPolicy(policy, req.URL)
+	if err != nil {
+		return "", err
+	}
+	client, err := newPolicyHttpClient(policy, 0, "")
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("请求失败 %d: %s", resp.StatusCode, string(data))
+	}
+	return string(data), nil
+}
@@ -1,9 +1,15 @@
 package flow
 
 import (
+	"errors"
+	"html/template"
+	"path/filepath"
 	"strings"
 
 	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/manager"
+	"github.com/zone-7/chatflow_engine/engine/meta"
+	"github.com/zone-7/chatflow_engine/engine/utils"
 )
 
 type BaseRunner struct {
@@ -17,11 +23,110 @@ func (r *BaseRunner) getChatSession(s *andflow.Session) *ChatSession {
 	return session
 }
 
-func (r *BaseRunner) getActionParam(action *andflow.ActionModel, key string, ps map[string]interface{}) string {
-	value := action.Params[key]
-	if len(value) > 0 && ps != nil && len(ps) > 0 {
+// 工作空间输出目录
+func (r *BaseRunner) getOutputDir(s *andflow.Session) string {
+	chatSession := r.getChatSession(s)
+	if chatSession == nil {
+		return ""
+	}
+	return manager.GetOutputPath(chatSession.Opt)
+}
+
+// 流程写入的文件路径，相对路径放在工作空间输出目录中
+func (r *BaseRunner) getOutputFile(s *andflow.Session, file string) string {
+	dir := r.getOutputDir(s)
+	if filepath.IsAbs(file) || len(dir) == 0 {
+		return file
+	}
+	return filepath.Join(dir, file)
+}
+
+// 模版中可以使用的函数，密钥只在执行时解析，不写入参数
+// 流程只能使用在流程定义中声明的密钥
+func (r *BaseRunner) getTemplateFuncs(s *andflow.Session) template.FuncMap {
+	chatSession := r.getChatSession(s)
+	if chatSession == nil {
+		return nil
+	}
+	opt := chatSession.Opt
+
+	allowed := make([]string, 0)
+	if chatSession.Chatflow != nil {
+		allowed = chatSession.Chatflow.Secrets
+	}
 
-		vv, err := replaceTemplate(value, "temp_"+action.Id, ps)
+	return template.FuncMap{
+		"secret": func(name string) (string, error) {
+			if utils.StringsIndex(allowed, name) < 0 {
+				return "", errors.New("流程没有声明使用密钥: " + name)
+			}
+			secretManager := manager.NewSecretManager(opt)
+			return secretManager.GetSecret(name)
+		},
+	}
+}
+
+// 环境变量由管理员按流程空间配置，变量值中可以引用任意密钥
+func getEnvTemplateFuncs(opt meta.Option) template.FuncMap {
+	return template.FuncMap{
+		"secret": func(name string) (string, error) {
+			secretManager := manager.NewSecretManager(opt)
+			return secretManager.GetSecret(name)
+		},
+	}
+}
+
+// 当前流程空间的环境变量，变量值中可以引用密钥
+func (r *BaseRunner) getEnvParams(s *andflow.Session) map[string]string {
+	env := make(map[string]string)
+
+	chatSession := r.getChatSession(s)
+	if chatSession == nil || chatSession.Info == nil {
+		return env
+	}
+	flow_space := chatSession.Info.FlowSpace
+	if len(flow_space) == 0 {
+		flow_space = meta.FLOW_SPACE_PRODUCT
+	}
+
+	envManager := manager.NewEnvManager(chatSession.Opt)
+	envs, err := envManager.GetEnvMap(flow_space)
+	if err != nil {
+		return env
+	}
+	funcs := getEnvTemplateFuncs(chatSession.Opt)
+	for k, v := range envs {
+		vv, err := replaceTemplateFuncs(v, "env_"+k, nil, funcs)
+		if err == nil {
+			v = vv
+		}
+		env[k] = v
+	}
+	return env
+}
+
+// 模版参数中加入环境变量 env，不修改原参数
+func (r *BaseRunner) withEnvParams(s *andflow.Session, ps map[string]interface{}, funcs template.FuncMap) map[string]interface{} {
+	res := make(map[string]interface{})
+	for k, v := range ps {
+		res[k] = v
+	}
+	res["env"] = r.getEnvParams(s)
+	return res
+}
+
+// 模版替换
+func (r *BaseRunner) renderTemplate(s *andflow.Session, temp string, name string, ps map[string]interface{}) (string, error) {
+	funcs := r.getTemplateFuncs(s)
+	return replaceTemplateFuncs(temp, name, r.withEnvParams(s, ps, funcs), funcs)
+}
+
+func (r *BaseRunner) getActionParam(s *andflow.Session, action *andflow.ActionModel, key string, ps map[string]interface{}) string {
+	value := action.Params[key]
+	//参数为nil时返回原始值，不做模版替换
+	if len(value) > 0 && ps != nil {
+		funcs := r.getTemplateFuncs(s)
+		vv, err := replaceTemplateFuncs(value, "temp_"+action.Id, r.withEnvParams(s, ps, funcs), funcs)
 		if err != nil {
 			return value
 		}
@@ -31,16 +136,25 @@ func (r *BaseRunner) getActionParam(action *andflow.ActionModel, key string, ps
 	return value
 }
 
-func (r *BaseRunner) getActionParams(action *andflow.ActionModel, ps map[string]interface{}) (map[string]string, error) {
+func (r *BaseRunner) getActionParams(s *andflow.Session, action *andflow.ActionModel, ps map[string]interface{}) (map[string]string, error) {
 
 	params := make(map[string]string)
+	if ps == nil {
+		for k, v := range action.Params {
+			params[k] = v
+		}
+		return params, nil
+	}
+
+	funcs := r.getTemplateFuncs(s)
+	ps = r.withEnvParams(s, ps, funcs)
 
 	for k, v := range action.Params {
 		var value string
 		value = v
-		if len(v) > 0 && ps != nil && len(ps) > 0 {
+		if len(v) > 0 {
 
-			vv, err := replaceTemplate(v, "temp_"+action.Id, ps)
+			vv, err := replaceTemplateFuncs(v, "temp_"+action.Id, ps, funcs)
 			if err != nil {
 				return nil, err
 			}
@@ -50,6 +164,11 @@ func (r *BaseRunner) getActionParams(action *andflow.ActionModel, ps map[string]
 
 		params[k] = value
 	}
+
+	err := r.resolvePromptRefs(s, params, ps, funcs)
+	if err != nil {
+		return nil, err
+	}
 	return params, nil
 }
 
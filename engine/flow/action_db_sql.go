@@ -25,7 +25,7 @@ func (r *Db_sql_Runner) Execute(s *andflow.Session, param *andflow.ActionParam,
 
 	action := s.GetFlow().GetAction(param.ActionId)
 
-	prop, err := r.getActionParams(action, s.GetParamMap())
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
 	if err != nil {
 		return andflow.RESULT_FAILURE, err
 	}
@@ -0,0 +1,227 @@
+package flow
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/utils"
+)
+
+// 邮件格式
+const (
+	EMAIL_FORMAT_TEXT = "text"
+	EMAIL_FORMAT_HTML = "html"
+)
+
+// 附件路径分隔符
+var email_attachment_split = regexp.MustCompile(`[\n,;]`)
+
+func init() {
+	andflow.RegistActionRunner("email_send", &EmailSendRunner{})
+}
+
+// 通过 SMTP 发送邮件，主题和内容使用参数模版，附件可以使用 excel_write、file_write 生成的文件
+// 演练模式下不连接服务器，生成的邮件头和大小保存在结果参数中，用于测试流程
+type EmailSendRunner struct {
+	BaseRunner
+}
+
+func (r *EmailSendRunner) Properties() []andflow.Prop {
+	return []andflow.Prop{}
+}
+
+func (r *EmailSendRunner) Execute(s *andflow.Session, param *andflow.ActionParam, state *andflow.ActionStateModel) (andflow.Result, error) {
+	action := s.GetFlow().GetAction(param.ActionId)
+
+	log.Printf("email_send begin: %v", time.Now())
+	defer log.Printf("email_send end: %v", time.Now())
+
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
+	if err != nil {
+		return andflow.RESULT_FAILURE, err
+	}
+
+	host := prop["host"]               //邮件服务器
+	port := prop["port"]               //端口，为空时按加密方式使用默认端口
+	security := prop["security"]       //加密方式 starttls（默认）、tls、none
+	username := prop["username"]       //认证用户
+	password := prop["password"]       //认证密码，使用 {{secret "name"}} 引用密钥
+	from := prop["from"]               //发件人
+	to := prop["to"]                   //收件人，逗号或者分号分隔
+	cc := prop["cc"]                   //抄送
+	bcc := prop["bcc"]                 //密送
+	reply_to := prop["reply_to"]       //回复地址
+	subject := prop["subject"]         //主题
+	body := prop["body"]               //内容
+	format := prop["format"]           //内容格式 text、html
+	attachments := prop["attachments"] //附件文件路径，每行一个或者逗号分隔，只能是输出目录中的文件
+	timeout := prop["timeout"]         //超时时间，秒
+	dry_run := prop["dry_run"]         //演练模式，不发送
+	param_key := prop["param_key"]     //发送结果
+
+	if len(format) == 0 {
+		format = EMAIL_FORMAT_TEXT
+	}
+	//HTML内容中的参数值需要转义，不能原样写入
+	if format == EMAIL_FORMAT_HTML {
+		funcs := r.getTemplateFuncs(s)
+		body, err = replaceTemplateEscaped(r.getActionParam(s, action, "body", nil), "email_body_"+action.Id, r.withEnvParams(s, s.GetParamMap(), funcs), funcs)
+		if err != nil {
+			return andflow.RESULT_FAILURE, err
+		}
+	}
+	if len(param_key) == 0 {
+		param_key = action.Id
+	}
+	is_dry_run := dry_run == "true" || dry_run == "1" || dry_run == "是"
+
+	if format != EMAIL_FORMAT_TEXT && format != EMAIL_FORMAT_HTML {
+		return andflow.RESULT_FAILURE, errors.New("不支持的邮件格式: " + format)
+	}
+
+	msg, err := r.getMessage(from, to, cc, bcc, reply_to)
+	if err != nil {
+		return andflow.RESULT_FAILURE, err
+	}
+	msg.Subject = subject
+	msg.Body = body
+	msg.Html = format == EMAIL_FORMAT_HTML
+	msg.Attachments = r.getAttachments(attachments)
+	msg.AttachmentDir = r.getOutputDir(s)
+
+	result := map[string]interface{}{
+		"success":     false,
+		"dry_run":     is_dry_run,
+		"from":        msg.From.Address,
+		"to":          r.getAddresses(msg.To),
+		"cc":          r.getAddresses(msg.Cc),
+		"bcc":         r.getAddresses(msg.Bcc),
+		"subject":     subject,
+		"attachments": msg.Attachments,
+		"message_id":  "",
+		"error":       "",
+		"time":        time.Now().UnixNano() / 1e6,
+	}
+
+	raw, err := utils.BuildEmail(msg)
+	if err != nil {
+		result["error"] = err.Error()
+		s.SetParam(param_key, result)
+		return andflow.RESULT_FAILURE, err
+	}
+	result["message_id"] = msg.MessageId
+
+	if is_dry_run {
+		//只保存邮件头和大小，不保存内容和附件
+		result["success"] = true
+		result["headers"] = r.getHeaders(raw)
+		result["size"] = len(raw)
+		s.SetParam(param_key, result)
+		s.AddLog_action_info(action.Name, action.Title, "演练模式，邮件没有发送: "+strings.Join(msg.Recipients(), ","))
+		return andflow.RESULT_SUCCESS, nil
+	}
+
+	server := utils.EmailServer{Host: host, Security: security, Username: username, Password: password}
+	if len(port) > 0 {
+		server.Port, err = utils.StringToInt(port)
+		if err != nil {
+			return andflow.RESULT_FAILURE, errors.New("端口格式错误: " + port)
+		}
+	}
+	if len(timeout) > 0 {
+		seconds, err := utils.StringToInt(timeout)
+		if err != nil {
+			return andflow.RESULT_FAILURE, errors.New("超时时间格式错误: " + timeout)
+		}
+		server.Timeout = time.Duration(seconds) * time.Second
+	}
+
+	err = utils.SendEmail(server, msg, raw)
+	if err != nil {
+		result["error"] = err.Error()
+		s.SetParam(param_key, result)
+		return andflow.RESULT_FAILURE, errors.New("邮件发送失败: " + err.Error())
+	}
+
+	result["success"] = true
+	s.SetParam(param_key, result)
+	s.AddLog_action_info(action.Name, action.Title, "邮件已发送: "+strings.Join(msg.Recipients(), ","))
+
+	return andflow.RESULT_SUCCESS, nil
+}
+
+// 解析发件人和收件人
+func (r *EmailSendRunner) getMessage(from, to, cc, bcc, reply_to string) (*utils.EmailMessage, error) {
+	msg := &utils.EmailMessage{}
+
+	froms, err := utils.ParseEmailAddresses(from)
+	if err != nil {
+		return nil, err
+	}
+	if len(froms) != 1 {
+		return nil, errors.New("发件人只能有一个: " + from)
+	}
+	msg.From = froms[0]
+
+	msg.To, err = utils.ParseEmailAddresses(to)
+	if err != nil {
+		return nil, err
+	}
+	msg.Cc, err = utils.ParseEmailAddresses(cc)
+	if err != nil {
+		return nil, err
+	}
+	msg.Bcc, err = utils.ParseEmailAddresses(bcc)
+	if err != nil {
+		return nil, err
+	}
+	msg.ReplyTo, err = utils.ParseEmailAddresses(reply_to)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(msg.Recipients()) == 0 {
+		return nil, errors.New("收件人不能为空")
+	}
+	return msg, nil
+}
+
+// 附件路径，每行一个或者逗号、分号分隔
+func (r *EmailSendRunner) getAttachments(text string) []string {
+	files := make([]string, 0)
+	for _, f := range email_attachment_split.Split(text, -1) {
+		f = strings.Trim(f, " \r\t")
+		if len(f) == 0 || utils.StringsIndex(files, f) >= 0 {
+			continue
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+// 邮件原文中的邮件头
+func (r *EmailSendRunner) getHeaders(raw []byte) map[string]string {
+	headers := make(map[string]string)
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return headers
+	}
+	for k, v := range m.Header {
+		headers[k] = strings.Join(v, ", ")
+	}
+	return headers
+}
+
+func (r *EmailSendRunner) getAddresses(list []*mail.Address) []string {
+	res := make([]string, 0)
+	for _, a := range list {
+		res = append(res, a.Address)
+	}
+	return res
+}
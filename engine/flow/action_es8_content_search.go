@@ -25,7 +25,7 @@ func (r *Es8_content_search_Runner) Execute(s *andflow.Session, param *andflow.A
 	var err error
 	action := s.GetFlow().GetAction(param.ActionId)
 
-	prop, err := r.getActionParams(action, s.GetParamMap())
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
 	if err != nil {
 		return andflow.RESULT_FAILURE, err
 	}
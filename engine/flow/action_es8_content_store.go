@@ -26,7 +26,7 @@ func (r *Es8_content_store_Runner) Execute(s *andflow.Session, param *andflow.Ac
 	var err error
 	action := s.GetFlow().GetAction(param.ActionId)
 
-	prop, err := r.getActionParams(action, s.GetParamMap())
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
 	if err != nil {
 		return andflow.RESULT_FAILURE, err
 	}
@@ -26,7 +26,7 @@ func (r *Excel_readRunner) Execute(s *andflow.Session, param *andflow.ActionPara
 	actionId := param.ActionId
 	action := s.GetFlow().GetAction(param.ActionId)
 
-	prop, err := r.getActionParams(action, s.GetParamMap())
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
 	if err != nil {
 		return andflow.RESULT_FAILURE, err
 	}
@@ -3,6 +3,9 @@ package flow
 import (
 	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"strconv"
 
 	"github.com/zone-7/andflow_go/andflow"
@@ -27,7 +30,7 @@ func (r *Excel_writeRunner) Execute(s *andflow.Session, param *andflow.ActionPar
 
 	action := s.GetFlow().GetAction(param.ActionId)
 
-	prop, err := r.getActionParams(action, s.GetParamMap())
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
 	if err != nil {
 		return andflow.RESULT_FAILURE, err
 	}
@@ -124,6 +127,14 @@ func (r *Excel_writeRunner) Execute(s *andflow.Session, param *andflow.ActionPar
 		return andflow.RESULT_SUCCESS, nil
 	}
 
+	file = r.getOutputFile(s, file)
+	if dir := filepath.Dir(file); !isFileExist(dir) {
+		err = os.MkdirAll(dir, fs.ModePerm)
+		if err != nil {
+			return andflow.RESULT_FAILURE, err
+		}
+	}
+
 	fr, err = utils.ExcelExport(file, datas, fr, fc)
 	if err != nil {
 		return andflow.RESULT_FAILURE, err
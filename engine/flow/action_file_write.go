@@ -26,7 +26,7 @@ func (r *File_writeRunner) Execute(s *andflow.Session, param *andflow.ActionPara
 
 	action := s.GetFlow().GetAction(param.ActionId)
 
-	prop, err := r.getActionParams(action, s.GetParamMap())
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
 	if err != nil {
 		return andflow.RESULT_FAILURE, err
 	}
@@ -43,6 +43,7 @@ func (r *File_writeRunner) Execute(s *andflow.Session, param *andflow.ActionPara
 	if err != nil {
 		return andflow.RESULT_FAILURE, err
 	}
+	filepath = r.getOutputFile(s, filepath)
 
 	//分隔字符类型
 	split := action.GetParam("split")
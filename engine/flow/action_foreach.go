@@ -0,0 +1,421 @@
+package flow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/utils"
+)
+
+// 循环体连线名称
+const FOREACH_LINK_EACH = "each"
+
+// 循环结束连线名称
+const FOREACH_LINK_DONE = "done"
+
+// 结束条件的执行时间限制，毫秒
+const foreach_break_timeout = 1000
+
+func init() {
+	andflow.RegistActionRunner("foreach", &ForeachRunner{})
+}
+
+// 遍历数组参数，每个元素执行一次循环体或者工具流程
+// 循环体：名称为 each 的连线进入循环体，循环体最后连回本节点，结束后走名称为 done 的连线
+// 工具流程：配置 flow_code 时每个元素调用一次工具流程，可以并发执行
+type ForeachRunner struct {
+	BaseRunner
+}
+
+func (r *ForeachRunner) Properties() []andflow.Prop {
+	return []andflow.Prop{}
+}
+
+// 参数值转换为数组，支持各种切片和JSON数组字符串
+func toItems(value interface{}) ([]interface{}, error) {
+	if value == nil {
+		return []interface{}{}, nil
+	}
+	if text, ok := value.(string); ok {
+		items := make([]interface{}, 0)
+		if len(strings.Trim(text, " \n")) == 0 {
+			return items, nil
+		}
+		err := json.Unmarshal([]byte(text), &items)
+		if err != nil {
+			return nil, errors.New("参数不是数组")
+		}
+		return items, nil
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, errors.New("参数不是数组")
+	}
+	items := make([]interface{}, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		items = append(items, v.Index(i).Interface())
+	}
+	return items, nil
+}
+
+// 判断是否满足结束条件，条件为JavaScript表达式，可以使用 item、index、output、results、params
+func (r *ForeachRunner) checkBreak(s *andflow.Session, action *andflow.ActionModel, condition string, item interface{}, index int, output interface{}, results []interface{}) (bool, error) {
+	if len(strings.Trim(condition, " \n")) == 0 {
+		return false, nil
+	}
+
+	chatSession := r.getChatSession(s)
+	key := fmt.Sprintf("%s/%s/break", chatSession.Chatflow.Code, action.Id)
+	program, err := getScriptProgram(key, chatSession.Chatflow.Edition, "return ("+condition+");")
+	if err != nil {
+		return false, errors.New("结束条件格式错误: " + err.Error())
+	}
+
+	vm := goja.New()
+	vm.Set("item", item)
+	vm.Set("index", index)
+	vm.Set("output", output)
+	vm.Set("results", results)
+	vm.Set("params", s.GetParamMap())
+
+	timer := time.AfterFunc(foreach_break_timeout*time.Millisecond, func() {
+		vm.Interrupt("结束条件执行超时")
+	})
+	defer timer.Stop()
+
+	val, err := vm.RunProgram(program)
+	if err != nil {
+		return false, err
+	}
+	return val.ToBoolean(), nil
+}
+
+func (r *ForeachRunner) Execute(s *andflow.Session, param *andflow.ActionParam, state *andflow.ActionStateModel) (andflow.Result, error) {
+	action := s.GetFlow().GetAction(param.ActionId)
+
+	log.Printf("foreach begin: %v", time.Now())
+	defer log.Printf("foreach end: %v", time.Now())
+
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
+	if err != nil {
+		return andflow.RESULT_FAILURE, err
+	}
+
+	flow_code := prop["flow_code"]
+
+	if len(flow_code) > 0 {
+		return r.executeSubflow(s, action, prop)
+	}
+	return r.executeGraph(s, action, param, state, prop)
+}
+
+// 循环参数
+type foreachOption struct {
+	ItemKey     string
+	IndexKey    string
+	CollectKey  string
+	ParamKey    string
+	Condition   string
+	MaxItems    int
+	Concurrency int
+}
+
+func (r *ForeachRunner) getOption(action *andflow.ActionModel, prop map[string]string) foreachOption {
+	opt := foreachOption{
+		ItemKey:     prop["item_key"],        //当前元素参数名，默认 item
+		IndexKey:    prop["index_key"],       //当前序号参数名，默认 index
+		CollectKey:  prop["collect_key"],     //每次执行后收集的参数
+		ParamKey:    prop["param_key"],       //收集结果数组
+		Condition:   prop["break_condition"], //结束条件
+		MaxItems:    0,                       //最多执行次数，0不限制
+		Concurrency: 1,                       //工具流程并发数
+	}
+	if len(opt.ItemKey) == 0 {
+		opt.ItemKey = "item"
+	}
+	if len(opt.IndexKey) == 0 {
+		opt.IndexKey = "index"
+	}
+	if len(opt.ParamKey) == 0 {
+		opt.ParamKey = action.Id
+	}
+	if len(prop["max_items"]) > 0 {
+		opt.MaxItems, _ = utils.StringToInt(prop["max_items"])
+	}
+	if len(prop["concurrency"]) > 0 {
+		opt.Concurrency, _ = utils.StringToInt(prop["concurrency"])
+	}
+	if opt.Concurrency <= 0 {
+		opt.Concurrency = 1
+	}
+	return opt
+}
+
+// 上一个节点是否在循环体中，从 each 连线出发不经过本节点能够到达
+func (r *ForeachRunner) isLoopBack(s *andflow.Session, action *andflow.ActionModel, pre_id string) bool {
+	if len(pre_id) == 0 {
+		return false
+	}
+	flow := s.GetFlow()
+
+	visited := make(map[string]bool)
+	queue := make([]string, 0)
+	for _, link := range flow.GetLinkBySourceId(action.Id) {
+		if strings.ToLower(strings.Trim(link.Name, " ")) == FOREACH_LINK_EACH {
+			queue = append(queue, link.TargetId)
+		}
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if id == action.Id || visited[id] {
+			continue
+		}
+		if id == pre_id {
+			return true
+		}
+		visited[id] = true
+		for _, link := range flow.GetLinkBySourceId(id) {
+			queue = append(queue, link.TargetId)
+		}
+	}
+	return false
+}
+
+// 按连线名称选择后续节点，done 没有配置时使用除 each 以外的连线
+func (r *ForeachRunner) route(s *andflow.Session, action *andflow.ActionModel, state *andflow.ActionStateModel, each bool) {
+	ids := make([]string, 0)
+	others := make([]string, 0)
+	for _, link := range s.GetFlow().GetLinkBySourceId(action.Id) {
+		name := strings.ToLower(strings.Trim(link.Name, " "))
+		if each && name == FOREACH_LINK_EACH {
+			ids = append(ids, link.TargetId)
+		}
+		if !each && name == FOREACH_LINK_DONE {
+			ids = append(ids, link.TargetId)
+		}
+		if name != FOREACH_LINK_EACH {
+			others = append(others, link.TargetId)
+		}
+	}
+	if len(ids) == 0 && !each {
+		ids = others
+	}
+	if len(ids) == 0 {
+		//指定一个不存在的节点，不执行任何后续节点
+		ids = append(ids, "")
+	}
+	state.NextActionIds = ids
+}
+
+// 执行循环体，循环状态保存在会话参数中，每次循环体连回本节点时执行下一个元素
+func (r *ForeachRunner) executeGraph(s *andflow.Session, action *andflow.ActionModel, param *andflow.ActionParam, state *andflow.ActionStateModel, prop map[string]string) (andflow.Result, error) {
+	opt := r.getOption(action, prop)
+
+	index_name := "foreach_index_" + action.Id
+	items_name := "foreach_items_" + action.Id
+	results_name := "foreach_results_" + action.Id
+
+	clear := func() {
+		s.SetParam(index_name, nil)
+		s.SetParam(items_name, nil)
+		s.SetParam(results_name, nil)
+	}
+
+	var items []interface{}
+	var results []interface{}
+	index := 0
+
+	started := s.GetParam(index_name) != nil && r.isLoopBack(s, action, param.PreActionId)
+	if started {
+		items, _ = s.GetParam(items_name).([]interface{})
+		results, _ = s.GetParam(results_name).([]interface{})
+		index, _ = utils.StringToInt(fmt.Sprintf("%v", s.GetParam(index_name)))
+
+		//收集循环体的输出
+		var output interface{}
+		if len(opt.CollectKey) > 0 {
+			output = s.GetParam(opt.CollectKey)
+			results = append(results, output)
+		}
+
+		var item interface{}
+		if index < len(items) {
+			item = items[index]
+		}
+		stop, err := r.checkBreak(s, action, opt.Condition, item, index, output, results)
+		if err != nil {
+			clear()
+			return andflow.RESULT_FAILURE, err
+		}
+		index++
+		if stop {
+			index = len(items)
+		}
+	} else {
+		var err error
+		items, err = toItems(s.GetParam(prop["items_param"]))
+		if err != nil {
+			return andflow.RESULT_FAILURE, errors.New("参数 " + prop["items_param"] + " " + err.Error())
+		}
+		results = make([]interface{}, 0)
+	}
+
+	if opt.MaxItems > 0 && index >= opt.MaxItems {
+		index = len(items)
+	}
+
+	//结束
+	if index >= len(items) {
+		clear()
+		s.SetParam(opt.ParamKey, results)
+		r.route(s, action, state, false)
+		return andflow.RESULT_SUCCESS, nil
+	}
+
+	s.SetParam(index_name, fmt.Sprintf("%d", index))
+	s.SetParam(items_name, items)
+	s.SetParam(results_name, results)
+
+	s.SetParam(opt.ItemKey, items[index])
+	s.SetParam(opt.IndexKey, index)
+
+	r.route(s, action, state, true)
+	return andflow.RESULT_SUCCESS, nil
+}
+
+// 每个元素调用一次工具流程，按照并发数执行，结果按元素顺序保存
+func (r *ForeachRunner) executeSubflow(s *andflow.Session, action *andflow.ActionModel, prop map[string]string) (andflow.Result, error) {
+	opt := r.getOption(action, prop)
+	chatSession := r.getChatSession(s)
+
+	flow_code := prop["flow_code"]
+	flow_params_json := r.getActionParam(s, action, "flow_params", nil)
+
+	items, err := toItems(s.GetParam(prop["items_param"]))
+	if err != nil {
+		return andflow.RESULT_FAILURE, errors.New("参数 " + prop["items_param"] + " " + err.Error())
+	}
+	if opt.MaxItems > 0 && len(items) > opt.MaxItems {
+		items = items[:opt.MaxItems]
+	}
+
+	outputs := make([]interface{}, len(items))
+	done := make([]bool, len(items))
+
+	var lock sync.Mutex
+	var first_err error
+	stopped := false
+
+	sem := make(chan struct{}, opt.Concurrency)
+	var wg sync.WaitGroup
+
+	for i, item := range items {
+		lock.Lock()
+		stop := stopped || first_err != nil
+		lock.Unlock()
+		if stop || s.Operation.GetCmd() == andflow.CMD_STOP {
+			break
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(index int, item interface{}) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			lock.Lock()
+			stop := stopped || first_err != nil
+			lock.Unlock()
+			if stop {
+				return
+			}
+
+			flow_params := make(map[string]string)
+			if len(flow_params_json) > 0 {
+				json.Unmarshal([]byte(flow_params_json), &flow_params)
+			}
+			ps := make(map[string]interface{})
+			for k, v := range s.GetParamMap() {
+				ps[k] = v
+			}
+			ps[opt.ItemKey] = item
+			ps[opt.IndexKey] = index
+			for k, v := range flow_params {
+				vv, err := r.renderTemplate(s, v, "temp_"+action.Id+"_params_"+k, ps)
+				if err == nil {
+					flow_params[k] = vv
+				}
+			}
+			if text, ok := item.(string); ok {
+				flow_params[opt.ItemKey] = text
+			} else {
+				data, _ := json.Marshal(item)
+				flow_params[opt.ItemKey] = string(data)
+			}
+			flow_params[opt.IndexKey] = fmt.Sprintf("%d", index)
+
+			content := flow_params[opt.ItemKey]
+			output, subflowParams, err := invokeWidgetFlow(chatSession, s.Operation.GetRequestId(), flow_code, content, flow_params)
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			if err != nil {
+				if first_err == nil {
+					first_err = err
+				}
+				return
+			}
+
+			var out interface{} = output
+			if len(opt.CollectKey) > 0 {
+				out = subflowParams[opt.CollectKey]
+			}
+			outputs[index] = out
+			done[index] = true
+
+			collected := make([]interface{}, 0)
+			for j := range outputs {
+				if done[j] {
+					collected = append(collected, outputs[j])
+				}
+			}
+			brk, err := r.checkBreak(s, action, opt.Condition, item, index, out, collected)
+			if err != nil {
+				if first_err == nil {
+					first_err = err
+				}
+				return
+			}
+			if brk {
+				stopped = true
+			}
+		}(i, item)
+	}
+	wg.Wait()
+
+	if first_err != nil {
+		s.AddLog_action_error(action.Name, action.Title, first_err.Error())
+		return andflow.RESULT_FAILURE, first_err
+	}
+
+	results := make([]interface{}, 0)
+	for i := range outputs {
+		if done[i] {
+			results = append(results, outputs[i])
+		}
+	}
+	s.SetParam(opt.ParamKey, results)
+
+	return andflow.RESULT_SUCCESS, nil
+}
@@ -0,0 +1,183 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/utils"
+)
+
+func init() {
+	andflow.RegistActionRunner("fork", &ForkRunner{})
+}
+
+// 并行执行后续的所有分支，在 join 节点汇聚
+// 每条连线是一个分支，分支名称为连线名称，没有名称时使用节点标题
+// 分支中写入的参数只在本分支可见，汇聚时合并
+type ForkRunner struct {
+	BaseRunner
+}
+
+func (r *ForkRunner) Properties() []andflow.Prop {
+	return []andflow.Prop{}
+}
+
+// 查找汇聚节点，没有指定时使用分支能够到达的第一个 join 节点
+func (r *ForkRunner) findJoin(s *andflow.Session, action *andflow.ActionModel, join string) *andflow.ActionModel {
+	flow := s.GetFlow()
+
+	if len(join) > 0 {
+		for _, act := range flow.Actions {
+			if act.Name == "join" && (act.Id == join || act.Title == join) {
+				return act
+			}
+		}
+		return nil
+	}
+
+	visited := map[string]bool{action.Id: true}
+	queue := make([]string, 0)
+	for _, link := range flow.GetLinkBySourceId(action.Id) {
+		queue = append(queue, link.TargetId)
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+
+		act := flow.GetAction(id)
+		if act == nil {
+			continue
+		}
+		if act.Name == "join" {
+			return act
+		}
+		for _, link := range flow.GetLinkBySourceId(id) {
+			queue = append(queue, link.TargetId)
+		}
+	}
+	return nil
+}
+
+func (r *ForkRunner) Execute(s *andflow.Session, param *andflow.ActionParam, state *andflow.ActionStateModel) (andflow.Result, error) {
+	action := s.GetFlow().GetAction(param.ActionId)
+
+	log.Printf("fork begin: %v", time.Now())
+	defer log.Printf("fork end: %v", time.Now())
+
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
+	if err != nil {
+		return andflow.RESULT_FAILURE, err
+	}
+
+	join := prop["join"]       //汇聚节点标题或ID
+	timeout := prop["timeout"] //分支超时时间，毫秒，0不限制
+
+	join_action := r.findJoin(s, action, join)
+	if join_action == nil {
+		return andflow.RESULT_FAILURE, errors.New("没有找到汇聚节点")
+	}
+
+	st := &forkState{
+		ForkId:   action.Id,
+		JoinId:   join_action.Id,
+		Branches: make([]string, 0),
+		Targets:  make(map[string]string),
+		Actions:  make(map[string]string),
+		Writes:   make(map[string]map[string]interface{}),
+		Arrived:  make([]string, 0),
+		Started:  time.Now(),
+		Begins:   make(map[string]time.Time),
+		TimedOut: make([]string, 0),
+		cancel:   make(chan struct{}),
+	}
+
+	for _, link := range s.GetFlow().GetLinkBySourceId(action.Id) {
+		if link.Active == "false" {
+			continue
+		}
+		name := strings.Trim(link.Name, " ")
+		if len(name) == 0 {
+			if target := s.GetFlow().GetAction(link.TargetId); target != nil {
+				name = target.Title
+			}
+		}
+		if len(name) == 0 || utils.StringsIndex(st.Branches, name) >= 0 {
+			name = fmt.Sprintf("%s_%s", name, link.TargetId)
+		}
+		st.Branches = append(st.Branches, name)
+		st.Targets[link.TargetId] = name
+	}
+	if len(st.Branches) == 0 {
+		return andflow.RESULT_FAILURE, errors.New("没有并行分支")
+	}
+
+	timeout_ms := 0
+	if len(timeout) > 0 {
+		timeout_ms, _ = utils.StringToInt(timeout)
+	}
+	if timeout_ms > 0 {
+		st.Timeout = time.Duration(timeout_ms) * time.Millisecond
+	}
+
+	addForkState(s.Id, st)
+
+	if st.Timeout > 0 {
+		r.startTimer(s, st)
+	}
+
+	return andflow.RESULT_SUCCESS, nil
+}
+
+// 每个分支单独计时，所有分支都已经到达或者超时后触发汇聚节点
+// 汇聚完成、流程停止或者分支都结束前不结束流程
+func (r *ForkRunner) startTimer(s *andflow.Session, st *forkState) {
+	runtime_id := s.GetRuntime().Id
+
+	s.Operation.WaitAdd(1)
+	go func() {
+		defer s.Operation.WaitDone()
+
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+
+	WAIT:
+		for {
+			select {
+			case <-st.cancel:
+				return
+			case <-s.Ctx.Done():
+				return
+			case <-ticker.C:
+				if s.Operation.GetCmd() == andflow.CMD_STOP {
+					return
+				}
+				st.lock.Lock()
+				if st.Done {
+					st.lock.Unlock()
+					return
+				}
+				st.checkTimeoutLocked(time.Now())
+				fire := len(st.TimedOut) > 0 && st.settledLocked()
+				st.lock.Unlock()
+				if fire {
+					break WAIT
+				}
+			}
+		}
+
+		//使用流程会话调度，分支会话不能调度节点
+		flow_session := andflow.GetSession(runtime_id)
+		if flow_session == nil {
+			return
+		}
+		flow_session.ToAction(&andflow.ActionParam{RuntimeId: runtime_id, ActionId: st.JoinId, PreActionId: ""})
+	}()
+}
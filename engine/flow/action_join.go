@@ -0,0 +1,260 @@
+package flow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/utils"
+)
+
+// 等待方式
+const (
+	JOIN_MODE_ALL   = "all"   //等待所有分支
+	JOIN_MODE_ANY   = "any"   //任意一个分支完成
+	JOIN_MODE_COUNT = "count" //完成指定数量的分支
+)
+
+// 多个分支写入同一个参数时的合并规则
+const (
+	JOIN_MERGE_LAST  = "last"  //按分支顺序，后面的分支覆盖
+	JOIN_MERGE_FIRST = "first" //按分支顺序，前面的分支优先
+	JOIN_MERGE_ARRAY = "array" //按分支顺序合并为数组
+	JOIN_MERGE_ERROR = "error" //汇聚失败
+)
+
+func init() {
+	andflow.RegistActionRunner("join", &JoinRunner{})
+}
+
+// 汇聚 fork 节点的并行分支，满足等待条件或者分支超时后继续执行
+// 汇聚之后到达的分支被忽略，分支中写入的参数不合并
+type JoinRunner struct {
+	BaseRunner
+}
+
+func (r *JoinRunner) Properties() []andflow.Prop {
+	return []andflow.Prop{}
+}
+
+// 不执行后续节点
+func (r *JoinRunner) hold(state *andflow.ActionStateModel) (andflow.Result, error) {
+	state.NextActionIds = []string{""}
+	return andflow.RESULT_SUCCESS, nil
+}
+
+func (r *JoinRunner) Execute(s *andflow.Session, param *andflow.ActionParam, state *andflow.ActionStateModel) (andflow.Result, error) {
+	action := s.GetFlow().GetAction(param.ActionId)
+
+	log.Printf("join begin: %v", time.Now())
+	defer log.Printf("join end: %v", time.Now())
+
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
+	if err != nil {
+		return andflow.RESULT_FAILURE, err
+	}
+
+	mode := prop["mode"]               //等待方式 all、any、count
+	count := prop["count"]             //count 方式下等待的分支数量
+	merge := prop["merge"]             //默认合并规则
+	merge_rules := prop["merge_rules"] //参数合并规则，JSON对象，参数名称 -> 合并规则
+	on_timeout := prop["on_timeout"]   //分支超时后 continue 继续，fail 失败
+	param_key := prop["param_key"]     //汇聚结果
+
+	if len(mode) == 0 {
+		mode = JOIN_MODE_ALL
+	}
+	if len(merge) == 0 {
+		merge = JOIN_MERGE_LAST
+	}
+	if len(param_key) == 0 {
+		param_key = action.Id
+	}
+
+	rules := make(map[string]string)
+	if len(strings.Trim(merge_rules, " \n")) > 0 {
+		err = json.Unmarshal([]byte(merge_rules), &rules)
+		if err != nil {
+			return andflow.RESULT_FAILURE, errors.New("合并规则格式错误: " + err.Error())
+		}
+	}
+	all_rules := []string{merge}
+	for _, rule := range rules {
+		all_rules = append(all_rules, rule)
+	}
+	for _, rule := range all_rules {
+		if utils.StringsIndex([]string{JOIN_MERGE_LAST, JOIN_MERGE_FIRST, JOIN_MERGE_ARRAY, JOIN_MERGE_ERROR}, rule) < 0 {
+			return andflow.RESULT_FAILURE, errors.New("不支持的合并规则: " + rule)
+		}
+	}
+
+	st := getJoinForkState(s.Id, action.Id)
+	if st == nil {
+		//没有并行分支，直接通过
+		return andflow.RESULT_SUCCESS, nil
+	}
+
+	st.lock.Lock()
+
+	//分支到达，到汇聚节点的连线通过时已经记录，汇聚模式下只有最后一个分支执行汇聚节点
+	branch := ""
+	if param.PreActionId == st.ForkId {
+		branch = st.Targets[action.Id]
+	} else if len(param.PreActionId) > 0 {
+		branch = st.Actions[param.PreActionId]
+	}
+	if len(branch) > 0 {
+		st.arriveLocked(branch)
+	}
+
+	if st.Done {
+		st.lock.Unlock()
+		if len(branch) > 0 {
+			s.AddLog_action_info(action.Name, action.Title, "分支在汇聚之后完成，结果被忽略: "+branch)
+		}
+		return r.hold(state)
+	}
+
+	required := len(st.Branches)
+	switch mode {
+	case JOIN_MODE_ALL:
+	case JOIN_MODE_ANY:
+		required = 1
+	case JOIN_MODE_COUNT:
+		n, err := utils.StringToInt(count)
+		if err != nil || n <= 0 {
+			st.lock.Unlock()
+			return andflow.RESULT_FAILURE, errors.New("分支数量格式错误: " + count)
+		}
+		if n < required {
+			required = n
+		}
+	default:
+		st.lock.Unlock()
+		return andflow.RESULT_FAILURE, errors.New("不支持的等待方式: " + mode)
+	}
+
+	if len(branch) > 0 && utils.StringsIndex(st.TimedOut, branch) >= 0 {
+		s.AddLog_action_info(action.Name, action.Title, "分支在超时之后完成，结果被忽略: "+branch)
+	}
+
+	st.checkTimeoutLocked(time.Now())
+	if len(st.Arrived) < required && !st.settledLocked() {
+		st.lock.Unlock()
+		return r.hold(state)
+	}
+
+	st.finishLocked()
+
+	arrived := append([]string{}, st.Arrived...)
+	missing := make([]string, 0)
+	for _, b := range st.Branches {
+		if utils.StringsIndex(arrived, b) < 0 {
+			missing = append(missing, b)
+		}
+	}
+	//按分支顺序合并，和分支到达的先后无关
+	ordered := make([]string, 0)
+	writes := make([]map[string]interface{}, 0)
+	for _, b := range st.Branches {
+		if utils.StringsIndex(arrived, b) < 0 {
+			continue
+		}
+		w := make(map[string]interface{})
+		for k, v := range st.Writes[b] {
+			w[k] = v
+		}
+		ordered = append(ordered, b)
+		writes = append(writes, w)
+	}
+	timed_out_branches := append([]string{}, st.TimedOut...)
+	timed_out := len(timed_out_branches) > 0
+	elapsed := time.Since(st.Started).Milliseconds()
+	st.lock.Unlock()
+
+	if timed_out && len(arrived) < required && on_timeout == "fail" {
+		return andflow.RESULT_FAILURE, errors.New("并行分支执行超时: " + strings.Join(timed_out_branches, ","))
+	}
+
+	merged, conflicts, err := mergeBranchParams(ordered, writes, merge, rules)
+	if err != nil {
+		return andflow.RESULT_FAILURE, err
+	}
+	for k, v := range merged {
+		s.SetParam(k, v)
+	}
+
+	s.SetParam(param_key, map[string]interface{}{
+		"branches":  arrived,
+		"missing":   missing,
+		"timeout":   timed_out,
+		"timed_out": timed_out_branches,
+		"conflicts": conflicts,
+		"elapsed":   elapsed,
+	})
+
+	if len(missing) > 0 {
+		s.AddLog_action_info(action.Name, action.Title, fmt.Sprintf("没有等待的分支: %s", strings.Join(missing, ",")))
+	}
+
+	return andflow.RESULT_SUCCESS, nil
+}
+
+// 按分支顺序合并参数，只有一个分支写入或者写入的值相同时不算冲突
+// 参数按名称排序处理，冲突列表和错误信息不受 map 遍历顺序影响
+func mergeBranchParams(branches []string, writes []map[string]interface{}, merge string, rules map[string]string) (map[string]interface{}, []string, error) {
+	keys := make([]string, 0)
+	values := make(map[string][]interface{})
+	owners := make(map[string][]string)
+	for i, w := range writes {
+		for k, v := range w {
+			if _, ok := values[k]; !ok {
+				keys = append(keys, k)
+			}
+			values[k] = append(values[k], v)
+			owners[k] = append(owners[k], branches[i])
+		}
+	}
+	sort.Strings(keys)
+
+	merged := make(map[string]interface{})
+	conflicts := make([]string, 0)
+	for _, k := range keys {
+		vs := values[k]
+
+		conflict := false
+		for _, v := range vs[1:] {
+			if !reflect.DeepEqual(v, vs[0]) {
+				conflict = true
+				break
+			}
+		}
+		if !conflict {
+			merged[k] = vs[0]
+			continue
+		}
+		conflicts = append(conflicts, k)
+
+		rule := merge
+		if r, ok := rules[k]; ok {
+			rule = r
+		}
+		switch rule {
+		case JOIN_MERGE_FIRST:
+			merged[k] = vs[0]
+		case JOIN_MERGE_ARRAY:
+			merged[k] = vs
+		case JOIN_MERGE_ERROR:
+			return nil, conflicts, fmt.Errorf("分支写入的参数冲突: %s (%s)", k, strings.Join(owners[k], ","))
+		default:
+			merged[k] = vs[len(vs)-1]
+		}
+	}
+	return merged, conflicts, nil
+}
@@ -2,7 +2,6 @@ package flow
 
 import (
 	"log"
-	"regexp"
 	"time"
 
 	"github.com/zone-7/andflow_go/andflow"
@@ -29,7 +28,7 @@ func (r *Json_extract_runner) Execute(s *andflow.Session, param *andflow.ActionP
 	log.Printf("Json_extract_runner begin: %v", time.Now())
 	defer log.Printf("Json_extract_runner end: %v", time.Now())
 
-	prop, err := r.getActionParams(action, s.GetParamMap())
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
 	if err != nil {
 		return andflow.RESULT_FAILURE, err
 	}
@@ -38,15 +37,10 @@ func (r *Json_extract_runner) Execute(s *andflow.Session, param *andflow.ActionP
 	content_temp := prop["content_temp"]     //信息来自哪个参数
 	param_key := prop["param_key"]
 
-	re := regexp.MustCompile(`(?s)\{.*?\}|\$begin:math:display\$.*?\$end:math:display\$`)
-
 	result := ""
 	if content_source == "temp" && len(content_temp) > 0 {
 
-		matches := re.FindAllString(content_temp, -1)
-		if len(matches) > 0 {
-			result = matches[0]
-		}
+		result = extractJsonText(content_temp)
 
 	} else if content_source == "history" {
 
@@ -54,12 +48,9 @@ func (r *Json_extract_runner) Execute(s *andflow.Session, param *andflow.ActionP
 		var i int
 		for i = len(messages) - 1; i >= 0; i-- {
 			text := messages[i].Content
-			// 正则表达式匹配
-
-			// 提取匹配结果
-			matches := re.FindAllString(text, -1)
-			if len(matches) > 0 {
-				result = matches[0]
+			// 提取第一个JSON对象或数组
+			result = extractJsonText(text)
+			if len(result) > 0 {
 				break
 			}
 		}
@@ -75,10 +66,7 @@ func (r *Json_extract_runner) Execute(s *andflow.Session, param *andflow.ActionP
 
 		}
 
-		matches := re.FindAllString(text, -1)
-		if len(matches) > 0 {
-			result = matches[0]
-		}
+		result = extractJsonText(text)
 
 	}
 
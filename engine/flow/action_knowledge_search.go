@@ -29,7 +29,7 @@ func (r *Knowledge_search_Runner) Execute(s *andflow.Session, param *andflow.Act
 
 	chatSession := r.getChatSession(s)
 
-	prop, err := r.getActionParams(action, s.GetParamMap())
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
 	if err != nil {
 		return andflow.RESULT_FAILURE, err
 	}
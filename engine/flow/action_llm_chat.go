@@ -0,0 +1,184 @@
+package flow
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/meta"
+	"github.com/zone-7/chatflow_engine/engine/provider"
+)
+
+func init() {
+	andflow.RegistActionRunner("llm_chat", &LLMChatRunner{})
+
+	//原来的模型节点
+	andflow.RegistActionRunner("openai_chatgpt", &LLMChatRunner{Provider: "openai"})
+	andflow.RegistActionRunner("moonshot_kimi", &LLMChatRunner{Provider: "kimi"})
+	andflow.RegistActionRunner("baidu_ernie", &LLMChatRunner{Provider: "baidu"})
+	andflow.RegistActionRunner("ollama_chat", &LLMChatRunner{Provider: "ollama"})
+}
+
+// 模型对话，通过 chat_provider 使用任意已注册的模型
+type LLMChatRunner struct {
+	BaseRunner
+	Provider string //固定的模型，为空时使用参数 chat_provider
+}
+
+func (r *LLMChatRunner) Properties() []andflow.Prop {
+	return []andflow.Prop{}
+}
+func (r *LLMChatRunner) Execute(s *andflow.Session, param *andflow.ActionParam, state *andflow.ActionStateModel) (andflow.Result, error) {
+	action := s.GetFlow().GetAction(param.ActionId)
+	chatSession := r.getChatSession(s)
+
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
+	if err != nil {
+		return andflow.RESULT_FAILURE, err
+	}
+
+	chat_provider := r.Provider
+	if len(chat_provider) == 0 {
+		chat_provider = prop["chat_provider"]
+	}
+	if len(chat_provider) == 0 {
+		chat_provider = "openai"
+	}
+
+	log.Printf("%s begin: %v", chat_provider, time.Now())
+	defer log.Printf("%s end: %v", chat_provider, time.Now())
+
+	content_source := prop["content_source"] //信息来自参数还是输入
+	content_temp := prop["content_temp"]     //信息来自哪个参数
+
+	image_source := prop["image_source"] //图片信息来自参数还是输入
+	image_temp := prop["image_temp"]     //图片信息来自哪个参数
+
+	res_chat := prop["res_chat"]   //输出到对话
+	param_key := prop["param_key"] //返回内容
+
+	req_cos := prop["req_cosplay"]                 //角色扮演
+	req_user_contract := prop["req_user_contract"] //用户背景要求
+
+	chatting := provider.CreateChatting(chat_provider)
+	if chatting == nil {
+		return andflow.RESULT_FAILURE, errors.New("模型不存在: " + chat_provider)
+	}
+
+	//请求参数
+	params := r.getChatParams(s, prop, "")
+	if len(params["stream"]) == 0 {
+		params["stream"] = "true"
+	}
+	err = provider.PrepareChatParams(chatting, params)
+	if err != nil {
+		return andflow.RESULT_FAILURE, err
+	}
+
+	requestContent := ""
+	if content_source == "temp" && len(content_temp) > 0 {
+		requestContent = content_temp
+	} else {
+		requestContent = chatSession.GetCurrentRequestMessagesContent(1)
+	}
+
+	attachImages := make([]string, 0)
+	if image_source == "temp" && len(image_temp) > 0 {
+		err = json.Unmarshal([]byte(image_temp), &attachImages)
+		if err != nil {
+			return andflow.RESULT_FAILURE, errors.New("图片格式错误")
+		}
+	} else {
+		msgs := chatSession.GetCurrentRequestMessages(1)
+		for _, msg := range msgs {
+			attachImages = append(attachImages, msg.Images...)
+		}
+	}
+
+	requestImages := make([]string, 0)
+	for _, img := range attachImages {
+		start := strings.Index(img, "data:image/")
+		end := strings.Index(img, "base64,")
+
+		if start >= 0 && end > 0 {
+			img = img[end+len("base64,"):]
+		}
+
+		requestImages = append(requestImages, img)
+	}
+
+	if len(requestContent) == 0 && len(requestImages) == 0 {
+		return andflow.RESULT_REJECT, nil
+	}
+
+	//messages
+	messages := []provider.ChatMessage{}
+
+	//设置系统面具定义
+	if len(req_cos) > 0 {
+		messages = append(messages, provider.ChatMessage{Role: provider.MESSAGE_ROLE_SYSTEM, Content: req_cos})
+	}
+
+	//设置用户背景要求
+	if len(req_user_contract) > 0 {
+		messages = append(messages, provider.ChatMessage{Role: provider.MESSAGE_ROLE_USER, Content: req_user_contract})
+	}
+
+	//历史消息，更早的消息总结为摘要
+	summary, history_msgs := r.getHistoryMessages(s, prop, chatting, params)
+	if len(summary) > 0 {
+		messages = append(messages, historySummaryMessage(summary))
+	}
+	messages = append(messages, history_msgs...)
+
+	//消息
+	messages = append(messages, provider.ChatMessage{Role: provider.MESSAGE_ROLE_USER, Content: requestContent, Images: requestImages})
+
+	responseContent := ""
+
+	uid, _ := uuid.NewV4()
+	mid := strings.ReplaceAll(uid.String(), "-", "")
+
+	err = chatting.Chat(params, messages, func(msg []provider.ChatMessage, is_done bool) error {
+		content := ""
+		images := make([]string, 0)
+		for _, m := range msg {
+			content += m.Content
+			if m.Images != nil {
+				images = append(images, m.Images...)
+			}
+		}
+		responseContent += content
+
+		//实时返回到对话
+		if res_chat == "true" || res_chat == "1" {
+			if len(content) > 0 || len(images) > 0 {
+				chatSession.Response(meta.ChatFlowMessage{MessageId: mid, MessageType: meta.CHAT_MESSAGE_TYPE_MESSAGE, Format: meta.CHAT_MESSAGE_FORMAT_TEXT, Role: meta.CHAT_MESSAGE_ROLE_ASSISTANT, Content: content, Images: images, Finish: "no"}, true)
+			}
+
+			if is_done {
+				chatSession.Response(meta.ChatFlowMessage{MessageId: mid, MessageType: meta.CHAT_MESSAGE_TYPE_MESSAGE, Format: meta.CHAT_MESSAGE_FORMAT_TEXT, Role: meta.CHAT_MESSAGE_ROLE_ASSISTANT, Content: "", Finish: "yes"}, true)
+			}
+		}
+
+		return nil
+	}, func() bool {
+		return s.Operation.GetCmd() == andflow.CMD_STOP
+	})
+
+	if err != nil {
+		log.Printf("%s执行异常:%v", chat_provider, err)
+		return andflow.RESULT_FAILURE, err
+	}
+
+	//保存返回内容到参数
+	if len(param_key) > 0 {
+		s.SetParam(param_key, responseContent)
+	}
+
+	return andflow.RESULT_SUCCESS, nil
+}
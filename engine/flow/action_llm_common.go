@@ -0,0 +1,219 @@
+package flow
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"unicode"
+
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/manager"
+	"github.com/zone-7/chatflow_engine/engine/meta"
+	"github.com/zone-7/chatflow_engine/engine/provider"
+	"github.com/zone-7/chatflow_engine/engine/utils"
+)
+
+// 根据节点参数生成模型请求参数，prefix 为参数名前缀
+// 参数 req_xxx 对应请求参数 xxx，req_xxx_other 为手动填写的值，另外包括 url 和流程超时时间
+func (r *BaseRunner) getChatParams(s *andflow.Session, prop map[string]string, prefix string) map[string]string {
+	params := map[string]string{}
+	for k, v := range prop {
+		if !strings.HasPrefix(k, prefix+"req_") || len(v) == 0 {
+			continue
+		}
+		name := strings.TrimPrefix(k, prefix+"req_")
+		//角色和用户背景是消息，不是请求参数
+		if name == "cosplay" || name == "user_contract" || manager.IsPromptRefParam(name) {
+			continue
+		}
+		if strings.HasSuffix(name, "_other") {
+			name = strings.TrimSuffix(name, "_other")
+			if len(prop[prefix+"req_"+name]) > 0 {
+				continue
+			}
+		}
+		params[name] = v
+	}
+	if len(prop[prefix+"url"]) > 0 {
+		params["url"] = prop[prefix+"url"]
+	}
+	params["timeout"] = s.GetFlow().Timeout
+
+	//设置用户ID
+	hash := md5.Sum([]byte(s.GetRuntime().Id))
+	user := hex.EncodeToString(hash[:])
+	params["user"] = user
+	params["user_id"] = user
+
+	return params
+}
+
+// 根据节点参数创建模型对话及请求参数，prefix 为参数名前缀，用于一个节点配置多个模型
+// 参数：chat_provider、url 以及 req_ 开头的请求参数，例如 req_api_key、req_model、req_max_tokens、req_temperature
+func (r *BaseRunner) getChatting(s *andflow.Session, prop map[string]string, prefix string) (provider.Chatting, map[string]string, error) {
+	chat_provider := prop[prefix+"chat_provider"]
+	if len(chat_provider) == 0 {
+		chat_provider = "openai"
+	}
+
+	chatting := provider.CreateChatting(chat_provider)
+	if chatting == nil {
+		return nil, nil, errors.New("模型不存在: " + chat_provider)
+	}
+
+	params := r.getChatParams(s, prop, prefix)
+	params["stream"] = "false"
+
+	err := provider.PrepareChatParams(chatting, params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return chatting, params, nil
+}
+
+// 根据节点参数创建向量模型及请求参数
+// 参数：embed_provider、url、req_api_key、req_model、req_keep_alive
+func (r *BaseRunner) getEmbedding(s *andflow.Session, prop map[string]string, prefix string) (provider.Embedding, map[string]string, error) {
+	embed_provider := prop[prefix+"embed_provider"]
+	if len(embed_provider) == 0 {
+		embed_provider = "openai"
+	}
+
+	embedding := provider.CreateEmbedding(embed_provider)
+	if embedding == nil {
+		return nil, nil, errors.New("向量模型不存在: " + embed_provider)
+	}
+
+	url := prop[prefix+"url"]
+	if len(url) == 0 {
+		return nil, nil, errors.New("参数 URL 地址不能为空")
+	}
+
+	req_model := prop[prefix+"req_model"]
+	if len(req_model) == 0 {
+		req_model = prop[prefix+"req_model_other"]
+	}
+	if len(req_model) == 0 {
+		return nil, nil, errors.New("参数模型不能为空")
+	}
+
+	params := map[string]string{}
+	params["url"] = url
+	params["model"] = req_model
+	params["api_key"] = prop[prefix+"req_api_key"]
+	params["keep_alive"] = prop[prefix+"req_keep_alive"]
+	params["timeout"] = s.GetFlow().Timeout
+
+	return embedding, params, nil
+}
+
+// 非流式请求模型，返回完整回答
+func (r *BaseRunner) chatText(s *andflow.Session, chatting provider.Chatting, params map[string]string, messages []provider.ChatMessage) (string, error) {
+	content := ""
+	err := chatting.Chat(params, messages, func(msg []provider.ChatMessage, is_done bool) error {
+		for _, m := range msg {
+			content += m.Content
+		}
+		return nil
+	}, func() bool {
+		return s.Operation.GetCmd() == andflow.CMD_STOP
+	})
+	return content, err
+}
+
+// 默认的摘要提示词
+const HISTORY_SUMMARY_PROMPT = "请把下面的对话总结为简洁的摘要，保留用户的需求、提到的事实、已经确定的信息和还没有解决的问题，只输出摘要。"
+
+// 根据节点参数获取历史消息，返回更早消息的摘要和最近的消息，不包含当前请求的消息
+// 参数：his_count（条数，默认4）、his_time（秒）、his_max_tokens、his_summary、his_summary_prompt
+// 摘要默认使用节点的模型，也可以用 his_summary_ 前缀单独配置模型
+func (r *BaseRunner) getHistoryMessages(s *andflow.Session, prop map[string]string, chatting provider.Chatting, params map[string]string) (string, []provider.ChatMessage) {
+	chatSession := r.getChatSession(s)
+
+	opt := ChatHistoryOption{Count: 4}
+	if len(prop["his_max_tokens"]) > 0 {
+		opt.MaxTokens, _ = utils.StringToInt(prop["his_max_tokens"])
+		opt.Count = -1
+	}
+	if len(prop["his_count"]) > 0 {
+		opt.Count, _ = utils.StringToInt(prop["his_count"])
+	}
+	if len(prop["his_time"]) > 0 {
+		opt.Time, _ = utils.StringToInt64(prop["his_time"])
+	}
+	opt.Summary = prop["his_summary"] == "true" || prop["his_summary"] == "1"
+
+	var summarizer ChatSummarizer
+	if opt.Summary {
+		summarizer = func(summary string, messages []*meta.ChatFlowMessage) (string, error) {
+			summary_chatting := chatting
+			summary_params := map[string]string{}
+			for k, v := range params {
+				summary_params[k] = v
+			}
+			summary_params["stream"] = "false"
+
+			if len(prop["his_summary_chat_provider"]) > 0 || len(prop["his_summary_url"]) > 0 {
+				c, p, err := r.getChatting(s, prop, "his_summary_")
+				if err != nil {
+					return "", err
+				}
+				summary_chatting, summary_params = c, p
+			}
+			if summary_chatting == nil {
+				return "", errors.New("没有配置摘要模型")
+			}
+
+			prompt := prop["his_summary_prompt"]
+			if len(prompt) == 0 {
+				prompt = HISTORY_SUMMARY_PROMPT
+			}
+
+			content := prompt + "\n\n"
+			if len(summary) > 0 {
+				content += "已有摘要:\n" + summary + "\n\n"
+			}
+			content += "新的对话:\n"
+			for _, m := range messages {
+				if m.Role == meta.CHAT_MESSAGE_ROLE_USER {
+					content += "用户: " + m.Content + "\n"
+				} else {
+					content += "助手: " + m.Content + "\n"
+				}
+			}
+
+			//部分模型不支持系统消息，使用一条用户消息
+			output, err := r.chatText(s, summary_chatting, summary_params, []provider.ChatMessage{{Role: provider.MESSAGE_ROLE_USER, Content: content}})
+			return strings.TrimSpace(output), err
+		}
+	}
+
+	summary, history := chatSession.GetHistory(opt, summarizer)
+
+	messages := make([]provider.ChatMessage, 0)
+	for _, m := range history {
+		messages = append(messages, provider.ChatMessage{Role: m.Role, Content: m.Content, Images: m.Images})
+	}
+	return summary, messages
+}
+
+// 摘要作为系统消息
+func historySummaryMessage(summary string) provider.ChatMessage {
+	return provider.ChatMessage{Role: provider.MESSAGE_ROLE_SYSTEM, Content: "以下是之前对话的摘要:\n" + summary}
+}
+
+// 估算token数量，中文按字计算，其他按4个字符一个token计算
+func estimateTokens(text string) int {
+	count := 0
+	others := 0
+	for _, c := range text {
+		if unicode.Is(unicode.Han, c) {
+			count++
+		} else {
+			others++
+		}
+	}
+	return count + (others+3)/4
+}
@@ -29,7 +29,7 @@ func (r *OllamaEmbeddingRunner) Execute(s *andflow.Session, param *andflow.Actio
 	log.Printf("ollama embedding begin: %v", time.Now())
 	defer log.Printf("ollama embedding end: %v", time.Now())
 
-	prop, err := r.getActionParams(action, s.GetParamMap())
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
 	if err != nil {
 		return andflow.RESULT_FAILURE, err
 	}
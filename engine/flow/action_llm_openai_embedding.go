@@ -27,7 +27,7 @@ func (r *OpenAIEmbeddingRunner) Execute(s *andflow.Session, param *andflow.Actio
 	action := s.GetFlow().GetAction(param.ActionId)
 	chatSession := r.getChatSession(s)
 
-	prop, err := r.getActionParams(action, s.GetParamMap())
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
 	if err != nil {
 		return andflow.RESULT_FAILURE, err
 	}
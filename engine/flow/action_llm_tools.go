@@ -0,0 +1,287 @@
+package flow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/meta"
+	"github.com/zone-7/chatflow_engine/engine/provider"
+	"github.com/zone-7/chatflow_engine/engine/utils"
+)
+
+func init() {
+	andflow.RegistActionRunner("llm_tools", &LLMToolsRunner{})
+}
+
+// 工具定义，每个工具由一个工具流程实现
+type LLMTool struct {
+	Name           string `json:"name"`            //工具名称，字母数字下划线
+	Title          string `json:"title"`           //显示名称
+	Description    string `json:"description"`     //工具说明，提供给模型
+	Parameters     any    `json:"parameters"`      //参数定义，JSON Schema
+	FlowCode       string `json:"flow_code"`       //工具流程编码
+	ResponseParams string `json:"response_params"` //工具流程返回的参数，多个用逗号隔开
+}
+
+// 工具调用记录
+type LLMToolCallLog struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Result    string `json:"result"`
+	Error     string `json:"error"`
+}
+
+type LLMToolsRunner struct {
+	BaseRunner
+}
+
+func (r *LLMToolsRunner) Properties() []andflow.Prop {
+	return []andflow.Prop{}
+}
+func (r *LLMToolsRunner) Execute(s *andflow.Session, param *andflow.ActionParam, state *andflow.ActionStateModel) (andflow.Result, error) {
+	action := s.GetFlow().GetAction(param.ActionId)
+	chatSession := r.getChatSession(s)
+
+	log.Printf("llm tools begin: %v", time.Now())
+	defer log.Printf("llm tools end: %v", time.Now())
+
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
+	if err != nil {
+		return andflow.RESULT_FAILURE, err
+	}
+
+	chat_provider := prop["chat_provider"] //openai、kimi
+
+	content_source := prop["content_source"] //信息来自参数还是输入
+	content_temp := prop["content_temp"]     //信息来自哪个参数
+
+	res_chat := prop["res_chat"] //输出到对话
+
+	param_key := prop["param_key"]             //返回内容
+	tools_param_key := prop["tools_param_key"] //工具调用记录
+
+	req_cos := prop["req_cosplay"]
+	req_api_key := prop["req_api_key"]
+
+	tools_json := prop["tools"]
+	tool_choice := prop["tool_choice"]
+	max_iterations := prop["max_iterations"]
+
+	if len(chat_provider) == 0 {
+		chat_provider = "openai"
+	}
+	chatting := provider.CreateToolChatting(chat_provider)
+	if chatting == nil {
+		return andflow.RESULT_FAILURE, errors.New("模型不支持工具调用: " + chat_provider)
+	}
+
+	//api key
+	if len(req_api_key) == 0 {
+		return andflow.RESULT_FAILURE, errors.New("参数 API KEY 不能为空")
+	}
+
+	//工具
+	llmTools := make([]*LLMTool, 0)
+	if len(tools_json) > 0 {
+		err = json.Unmarshal([]byte(tools_json), &llmTools)
+		if err != nil {
+			return andflow.RESULT_FAILURE, errors.New("工具定义格式错误")
+		}
+	}
+	if len(llmTools) == 0 {
+		return andflow.RESULT_FAILURE, errors.New("工具不能为空")
+	}
+
+	tools := make([]provider.ChatTool, 0)
+	for _, t := range llmTools {
+		if len(t.Name) == 0 || len(t.FlowCode) == 0 {
+			return andflow.RESULT_FAILURE, errors.New("工具名称和工具流程不能为空")
+		}
+		parameters := t.Parameters
+		if parameters == nil {
+			parameters = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+		tools = append(tools, provider.ChatTool{Name: t.Name, Description: t.Description, Parameters: parameters})
+	}
+
+	iterations := 5
+	if len(max_iterations) > 0 {
+		iterations, _ = utils.StringToInt(max_iterations)
+	}
+	if iterations <= 0 {
+		iterations = 5
+	}
+
+	requestContent := ""
+	if content_source == "temp" && len(content_temp) > 0 {
+		requestContent = content_temp
+	} else {
+		requestContent = chatSession.GetCurrentRequestMessagesContent(1)
+		if len(requestContent) == 0 {
+			return andflow.RESULT_REJECT, nil
+		}
+	}
+
+	//请求参数
+	params := r.getChatParams(s, prop, "")
+	params["stream"] = "false"
+	err = provider.PrepareChatParams(chatting, params)
+	if err != nil {
+		return andflow.RESULT_FAILURE, err
+	}
+
+	//messages
+	messages := []provider.ChatMessage{}
+	if len(req_cos) > 0 {
+		messages = append(messages, provider.ChatMessage{Role: provider.MESSAGE_ROLE_SYSTEM, Content: req_cos})
+	}
+
+	//历史消息，更早的消息总结为摘要
+	summary, history_msgs := r.getHistoryMessages(s, prop, chatting, params)
+	if len(summary) > 0 {
+		messages = append(messages, historySummaryMessage(summary))
+	}
+	messages = append(messages, history_msgs...)
+
+	messages = append(messages, provider.ChatMessage{Role: provider.MESSAGE_ROLE_USER, Content: requestContent})
+
+	callLogs := make([]*LLMToolCallLog, 0)
+	responseContent := ""
+	finished := false
+
+	for i := 0; i < iterations; i++ {
+		if s.Operation.GetCmd() == andflow.CMD_STOP {
+			return andflow.RESULT_FAILURE, errors.New("用户停止")
+		}
+
+		res, err := chatting.ChatTools(params, messages, tools, tool_choice)
+		if err != nil {
+			log.Printf("llm tools执行异常:%v", err)
+			return andflow.RESULT_FAILURE, err
+		}
+		if res == nil {
+			return andflow.RESULT_FAILURE, errors.New("模型没有返回内容")
+		}
+
+		//最终回答
+		if len(res.ToolCalls) == 0 {
+			responseContent = res.Content
+			finished = true
+			break
+		}
+
+		messages = append(messages, *res)
+
+		for _, call := range res.ToolCalls {
+			callLog := &LLMToolCallLog{Name: call.Name, Arguments: call.Arguments}
+			callLogs = append(callLogs, callLog)
+
+			result, err := r.callTool(s, chatSession, llmTools, call)
+			if err != nil {
+				callLog.Error = err.Error()
+				result = "工具调用失败: " + err.Error()
+			}
+			callLog.Result = result
+
+			messages = append(messages, provider.ChatMessage{Role: provider.MESSAGE_ROLE_TOOL, Content: result, ToolCallId: call.Id})
+		}
+
+		//指定工具只在第一轮生效，避免重复调用
+		if tool_choice != "none" {
+			tool_choice = "auto"
+		}
+	}
+
+	if len(tools_param_key) > 0 {
+		data, _ := json.Marshal(callLogs)
+		s.SetParam(tools_param_key, string(data))
+	}
+
+	if !finished {
+		return andflow.RESULT_FAILURE, fmt.Errorf("超过最大工具调用次数: %d", iterations)
+	}
+
+	//输出到对话
+	if (res_chat == "true" || res_chat == "1") && len(responseContent) > 0 {
+		uid, _ := uuid.NewV4()
+		mid := strings.ReplaceAll(uid.String(), "-", "")
+		chatSession.Response(meta.ChatFlowMessage{MessageId: mid, MessageType: meta.CHAT_MESSAGE_TYPE_MESSAGE, Format: meta.CHAT_MESSAGE_FORMAT_TEXT, Role: meta.CHAT_MESSAGE_ROLE_ASSISTANT, Content: responseContent, Finish: "yes"}, true)
+	}
+
+	//保存返回内容到参数
+	if len(param_key) > 0 {
+		s.SetParam(param_key, responseContent)
+	}
+
+	return andflow.RESULT_SUCCESS, nil
+}
+
+// 执行工具流程，工具参数作为流程参数传入
+func (r *LLMToolsRunner) callTool(s *andflow.Session, chatSession *ChatSession, llmTools []*LLMTool, call provider.ChatToolCall) (string, error) {
+	var tool *LLMTool
+	for _, t := range llmTools {
+		if t.Name == call.Name {
+			tool = t
+			break
+		}
+	}
+	if tool == nil {
+		return "", errors.New("工具不存在: " + call.Name)
+	}
+
+	title := tool.Title
+	if len(title) == 0 {
+		title = tool.Name
+	}
+	chatSession.ResponseWaitting("调用工具: " + title)
+
+	args := make(map[string]any)
+	if len(strings.Trim(call.Arguments, " ")) > 0 {
+		err := json.Unmarshal([]byte(call.Arguments), &args)
+		if err != nil {
+			return "", errors.New("工具参数格式错误: " + call.Arguments)
+		}
+	}
+
+	flow_params := make(map[string]string)
+	for k, v := range args {
+		if str, ok := v.(string); ok {
+			flow_params[k] = str
+		} else {
+			data, _ := json.Marshal(v)
+			flow_params[k] = string(data)
+		}
+	}
+
+	output, subflowParams, err := invokeWidgetFlow(chatSession, s.Operation.GetRequestId(), tool.FlowCode, call.Arguments, flow_params)
+	if err != nil {
+		return "", err
+	}
+
+	//指定了返回参数时，返回参数的JSON，否则返回工具流程输出的消息
+	keys := getWords(tool.ResponseParams)
+	if len(keys) > 0 {
+		res := make(map[string]interface{})
+		for _, k := range keys {
+			if v, ok := subflowParams[k]; ok {
+				res[k] = v
+			}
+		}
+		data, err := json.Marshal(res)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+
+	if len(output) == 0 {
+		output = "ok"
+	}
+	return output, nil
+}
@@ -2,20 +2,59 @@ package flow
 
 // 网络请求
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/gocolly/colly"
 	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/manager"
+	"github.com/zone-7/chatflow_engine/engine/utils"
 )
 
+// 请求体类型
+const (
+	BODY_TYPE_RAW       = "raw"       //原样发送，GET 请求时拼接到地址
+	BODY_TYPE_JSON      = "json"      //JSON
+	BODY_TYPE_FORM      = "form"      //表单，JSON对象或 a=1&b=2
+	BODY_TYPE_MULTIPART = "multipart" //多部分表单，可以上传文件
+)
+
+// 认证方式
+const (
+	AUTH_TYPE_BEARER  = "bearer"
+	AUTH_TYPE_BASIC   = "basic"
+	AUTH_TYPE_API_KEY = "api_key"
+)
+
+// 状态分支的连线名称
+var status_link_reg = regexp.MustCompile(`^([1-5][0-9]{2}|[1-5]xx|error)$`)
+
+// 日志中最多记录的内容长度
+const net_request_log_limit = 2048
+
 func init() {
 	andflow.RegistActionRunner("net_request", &Net_requestRunner{})
 }
 
+// 请求结果
+type netResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
 type Net_requestRunner struct {
 	BaseRunner
 }
@@ -23,175 +62,497 @@ type Net_requestRunner struct {
 func (r *Net_requestRunner) Properties() []andflow.Prop {
 	return []andflow.Prop{}
 }
-func (r *Net_requestRunner) Execute(s *andflow.Session, param *andflow.ActionParam, state *andflow.ActionStateModel) (andflow.Result, error) {
 
+// 根据状态码选择后续节点，连线名称（没有时使用连线标题）为状态码（例如 404）或状态分类（2xx、4xx、5xx），请求失败时为 error
+// 匹配到分支时返回 true；没有匹配时不走状态分支，只走其他连线
+func (r *Net_requestRunner) routeByStatus(s *andflow.Session, action *andflow.ActionModel, state *andflow.ActionStateModel, names ...string) bool {
+	branches := make(map[string][]string)
+	others := make([]string, 0)
+	for _, link := range s.GetFlow().GetLinkBySourceId(action.Id) {
+		name := strings.ToLower(strings.Trim(link.Name, " "))
+		if len(name) == 0 {
+			name = strings.ToLower(strings.Trim(link.Title, " "))
+		}
+		if status_link_reg.MatchString(name) {
+			branches[name] = append(branches[name], link.TargetId)
+		} else {
+			others = append(others, link.TargetId)
+		}
+	}
+
+	for _, name := range names {
+		if ids := branches[name]; len(ids) > 0 {
+			state.NextActionIds = ids
+			return true
+		}
+	}
+
+	if len(branches) > 0 {
+		if len(others) == 0 {
+			//指定一个不存在的节点，不执行任何后续节点
+			others = append(others, "")
+		}
+		state.NextActionIds = others
+	}
+	return false
+}
+
+func (r *Net_requestRunner) Execute(s *andflow.Session, param *andflow.ActionParam, state *andflow.ActionStateModel) (andflow.Result, error) {
 	var err error
 
 	actionId := param.ActionId
 	action := s.GetFlow().GetAction(param.ActionId)
 
-	prop, err := r.getActionParams(action, s.GetParamMap())
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
 	if err != nil {
 		return andflow.RESULT_FAILURE, err
 	}
 
-	var result string
-
-	var headers = make(map[string]string)
-	var cookies = make(map[string]string)
-
 	dist_url := prop["url"]
-	proxy := prop["proxy"]
-	useragent := prop["user_agent"]
-	param_key := prop["param_key"]
-	method := prop["method"]
-	body := prop["body_template"]
-	headersJson := prop["headers"]
-	cookiesJson := prop["cookies"]
+	method := strings.ToUpper(prop["method"])
+	param_key := prop["param_key"]                 //返回内容
+	status_param_key := prop["status_param_key"]   //状态码
+	headers_param_key := prop["headers_param_key"] //返回的请求头
+	extract := prop["extract"]                     //提取返回内容到参数，JSON对象 参数名:JSONPath
+	timeout := prop["timeout"]                     //超时时间，秒
+	retry_count := prop["retry_count"]             //失败重试次数，网络异常、429 和 5xx 时重试
+	retry_interval := prop["retry_interval"]       //重试间隔，毫秒
+	debug := prop["debug"]                         //记录请求和返回的详细信息
 
 	if len(dist_url) == 0 {
-		s.AddLog_action_error(action.Name, action.Title, "地址不能为空"+err.Error())
-		return andflow.RESULT_FAILURE, err
+		s.AddLog_action_error(action.Name, action.Title, "地址不能为空")
+		return andflow.RESULT_FAILURE, errors.New("地址不能为空")
 	}
 
-	domain := getDomain(dist_url)
-
 	if len(method) == 0 {
-		method = "post"
+		method = http.MethodPost
 	}
 
-	if len(headersJson) > 0 {
-
-		json.Unmarshal([]byte(headersJson), &headers)
+	if len(param_key) == 0 {
+		param_key = actionId
 	}
 
-	if len(cookiesJson) > 0 {
-
-		json.Unmarshal([]byte(cookiesJson), &cookies)
+	//没有设置时使用请求限制中的默认超时时间
+	timeout_second := 0
+	if len(timeout) > 0 {
+		timeout_second, _ = utils.StringToInt(timeout)
 	}
-
-	//网络调用
-	conn := colly.NewCollector(
-		colly.Async(false),
-	)
-	log.Printf("request: %v \n", dist_url)
-	//设置代理
-	if len(proxy) > 0 {
-		conn.SetProxy(proxy)
+	retries := 0
+	if len(retry_count) > 0 {
+		retries, _ = utils.StringToInt(retry_count)
 	}
-	if len(useragent) > 0 {
-		conn.UserAgent = useragent
+	interval := 1000
+	if len(retry_interval) > 0 {
+		interval, _ = utils.StringToInt(retry_interval)
 	}
 
-	if len(cookies) > 0 {
-		cs := make([]*http.Cookie, 0)
-		for k, v := range cookies {
-			c := http.Cookie{Name: k, Value: v, Expires: time.Now().AddDate(0, 0, 1), Domain: domain}
-			cs = append(cs, &c)
-		}
-		conn.SetCookies(dist_url, cs)
+	policy := r.getChatSession(s).Opt.HttpPolicy
+	client, err := newPolicyHttpClient(policy, timeout_second, prop["proxy"])
+	if err != nil {
+		s.AddLog_action_error(action.Name, action.Title, err.Error())
+		return andflow.RESULT_FAILURE, err
 	}
 
-	conn.OnRequest(func(r *colly.Request) {
-
-		if len(headers) > 0 {
-			for k, v := range headers {
-				r.Headers.Set(k, v)
+	var res *netResponse
+	for i := 0; i <= retries; i++ {
+		if i > 0 {
+			if s.Operation.GetCmd() == andflow.CMD_STOP {
+				break
 			}
+			time.Sleep(time.Duration(interval) * time.Millisecond)
+			s.AddLog_action_info(action.Name, action.Title, fmt.Sprintf("第%d次重试", i))
 		}
 
-	})
+		//每次重试重新生成请求，请求体只能读取一次
+		var req *http.Request
+		req, err = r.buildRequest(prop, method, dist_url, r.getOutputDir(s))
+		if err == nil {
+			err = checkHttpPolicy(policy, req.URL)
+		}
+		if err != nil {
+			s.AddLog_action_error(action.Name, action.Title, err.Error())
+			return andflow.RESULT_FAILURE, err
+		}
+		if debug == "true" || debug == "1" {
+			s.AddLog_action_info(action.Name, action.Title, r.dumpRequest(req))
+		}
 
-	conn.OnError(func(res *colly.Response, e error) {
-		s.AddLog_action_error(action.Name, action.Title, "网络请求异常"+e.Error())
+		res, err = r.send(client, req, getHttpMaxBodySize(policy))
+		if err != nil {
+			log.Printf("网络请求异常:%v", err)
+			s.AddLog_action_error(action.Name, action.Title, "网络请求异常"+err.Error())
+			continue
+		}
+		if debug == "true" || debug == "1" {
+			s.AddLog_action_info(action.Name, action.Title, r.dumpResponse(res))
+		}
+		if res.StatusCode != http.StatusTooManyRequests && res.StatusCode < 500 {
+			break
+		}
+		s.AddLog_action_error(action.Name, action.Title, fmt.Sprintf("请求返回状态码 %d", res.StatusCode))
+	}
 
-		err = e
-	})
+	if err != nil || res == nil {
+		if err == nil {
+			err = errors.New("用户停止")
+		}
+		if r.routeByStatus(s, action, state, "error") {
+			s.SetParam(param_key, err.Error())
+			return andflow.RESULT_SUCCESS, nil
+		}
+		return andflow.RESULT_FAILURE, err
+	}
 
-	conn.OnResponse(func(res *colly.Response) {
+	s.SetParam(param_key, string(res.Body))
+	if len(status_param_key) > 0 {
+		s.SetParam(status_param_key, res.StatusCode)
+	}
+	if len(headers_param_key) > 0 {
+		headers := make(map[string]string)
+		for k := range res.Header {
+			headers[k] = res.Header.Get(k)
+		}
+		s.SetParam(headers_param_key, headers)
+	}
 
-		resetValue(&result, string(res.Body))
+	//提取返回内容
+	if len(extract) > 0 {
+		err = r.extractResponse(s, action, extract, res.Body)
+		if err != nil {
+			s.AddLog_action_error(action.Name, action.Title, err.Error())
+			return andflow.RESULT_FAILURE, err
+		}
+	}
 
-	})
+	status := fmt.Sprintf("%d", res.StatusCode)
+	status_class := status[:1] + "xx"
+	if r.routeByStatus(s, action, state, status, status_class) {
+		return andflow.RESULT_SUCCESS, nil
+	}
 
-	conn.OnHTML("", func(el *colly.HTMLElement) {
+	//没有配置分支时只有 2xx 和 3xx 成功
+	if res.StatusCode >= 400 {
+		return andflow.RESULT_FAILURE, fmt.Errorf("请求返回状态码 %d", res.StatusCode)
+	}
 
-	})
+	return andflow.RESULT_SUCCESS, nil
+}
 
-	//onhtml 之后执行
-	conn.OnScraped(func(_ *colly.Response) {
+// 生成请求，包括查询参数、请求体、请求头、Cookie 和认证
+// 上传文件只能读取输出目录 output_dir 中的文件
+func (r *Net_requestRunner) buildRequest(prop map[string]string, method string, dist_url string, output_dir string) (*http.Request, error) {
+	body_type := prop["body_type"]
+	body := prop["body_template"]
+	query_json := prop["query"]     //查询参数，JSON对象
+	files_json := prop["files"]     //上传文件，JSON对象 字段名:文件路径（相对输出目录）
+	headers_json := prop["headers"] //请求头，JSON对象
+	cookies_json := prop["cookies"] //Cookie，JSON对象
+	user_agent := prop["user_agent"]
+
+	if len(body_type) == 0 {
+		body_type = BODY_TYPE_RAW
+	}
 
-	})
+	u, err := url.Parse(dist_url)
+	if err != nil {
+		return nil, errors.New("地址格式错误: " + dist_url)
+	}
+	query := u.Query()
+	if len(query_json) > 0 {
+		values, err := parseValues(query_json)
+		if err != nil {
+			return nil, errors.New("查询参数格式错误")
+		}
+		for k, v := range values {
+			query.Set(k, v)
+		}
+	}
+
+	var reader io.Reader
+	content_type := ""
 
-	//发送
-	if len(dist_url) > 0 {
-		if method == "post" {
-			//POST
-			if len(body) > 0 {
-				err = conn.PostRaw(dist_url, []byte(body))
+	switch body_type {
+	case BODY_TYPE_JSON:
+		if len(body) > 0 {
+			if !json.Valid([]byte(body)) {
+				return nil, errors.New("请求内容不是JSON格式")
+			}
+			reader = strings.NewReader(body)
+		}
+		content_type = "application/json"
+	case BODY_TYPE_FORM:
+		form := url.Values{}
+		if len(body) > 0 {
+			if strings.HasPrefix(strings.TrimSpace(body), "{") {
+				values, err := parseValues(body)
+				if err != nil {
+					return nil, errors.New("表单内容格式错误")
+				}
+				for k, v := range values {
+					form.Set(k, v)
+				}
 			} else {
-				err = conn.Post(dist_url, nil)
+				form, err = url.ParseQuery(body)
+				if err != nil {
+					return nil, errors.New("表单内容格式错误")
+				}
+			}
+		}
+		if method == http.MethodGet {
+			for k, vs := range form {
+				for _, v := range vs {
+					query.Add(k, v)
+				}
 			}
-
 		} else {
-			//GET
-			if len(body) > 0 {
-				if strings.Index(dist_url, "?") >= 0 {
-					dist_url += "&" + body
-				} else {
-					dist_url += "?" + body
+			reader = strings.NewReader(form.Encode())
+			content_type = "application/x-www-form-urlencoded"
+		}
+	case BODY_TYPE_MULTIPART:
+		buf := &bytes.Buffer{}
+		writer := multipart.NewWriter(buf)
+		if len(body) > 0 {
+			values, err := parseValues(body)
+			if err != nil {
+				return nil, errors.New("表单内容格式错误")
+			}
+			for _, k := range sortedValueKeys(values) {
+				writer.WriteField(k, values[k])
+			}
+		}
+		if len(files_json) > 0 {
+			files, err := parseValues(files_json)
+			if err != nil {
+				return nil, errors.New("上传文件格式错误")
+			}
+			for _, k := range sortedValueKeys(files) {
+				file, err := utils.ResolvePathInDir(output_dir, files[k])
+				if err != nil {
+					return nil, err
+				}
+				data, err := os.ReadFile(file)
+				if err != nil {
+					return nil, errors.New("读取文件失败: " + files[k])
 				}
+				part, err := writer.CreateFormFile(k, filepath.Base(file))
+				if err != nil {
+					return nil, err
+				}
+				part.Write(data)
 			}
+		}
+		writer.Close()
+		reader = buf
+		content_type = writer.FormDataContentType()
+	default:
+		//GET 请求时内容拼接到地址
+		if len(body) > 0 {
+			if method == http.MethodGet {
+				values, err := url.ParseQuery(body)
+				if err != nil {
+					return nil, errors.New("查询参数格式错误")
+				}
+				for k, vs := range values {
+					for _, v := range vs {
+						query.Add(k, v)
+					}
+				}
+			} else {
+				reader = strings.NewReader(body)
+			}
+		}
+	}
 
-			err = conn.Visit(dist_url)
+	//API KEY 放在查询参数中
+	if prop["auth_type"] == AUTH_TYPE_API_KEY && prop["auth_key_in"] == "query" {
+		key_name := prop["auth_key_name"]
+		if len(key_name) == 0 {
+			key_name = "api_key"
+		}
+		query.Set(key_name, prop["auth_api_key"])
+	}
+
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
 
+	if len(content_type) > 0 {
+		req.Header.Set("Content-Type", content_type)
+	}
+	if len(user_agent) > 0 {
+		req.Header.Set("User-Agent", user_agent)
+	}
+
+	if len(headers_json) > 0 {
+		headers, err := parseValues(headers_json)
+		if err != nil {
+			return nil, errors.New("请求头格式错误")
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+	}
+
+	if len(cookies_json) > 0 {
+		cookies, err := parseValues(cookies_json)
+		if err != nil {
+			return nil, errors.New("Cookie格式错误")
+		}
+		for _, k := range sortedValueKeys(cookies) {
+			req.AddCookie(&http.Cookie{Name: k, Value: cookies[k]})
+		}
+	}
+
+	//认证
+	switch prop["auth_type"] {
+	case AUTH_TYPE_BEARER:
+		req.Header.Set("Authorization", "Bearer "+prop["auth_token"])
+	case AUTH_TYPE_BASIC:
+		req.SetBasicAuth(prop["auth_username"], prop["auth_password"])
+	case AUTH_TYPE_API_KEY:
+		if prop["auth_key_in"] != "query" {
+			key_name := prop["auth_key_name"]
+			if len(key_name) == 0 {
+				key_name = "X-API-Key"
+			}
+			req.Header.Set(key_name, prop["auth_api_key"])
 		}
 	}
 
+	return req, nil
+}
+
+// 发送请求并读取返回内容
+func (r *Net_requestRunner) send(client *http.Client, req *http.Request, max_body_size int64) (*netResponse, error) {
+	log.Printf("request: %s %v \n", req.Method, redactURL(req.URL))
+
+	resp, err := client.Do(req)
 	if err != nil {
-		s.AddLog_action_error(action.Name, action.Title, "网络请求异常"+err.Error())
-		return andflow.RESULT_FAILURE, err
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	if len(param_key) == 0 {
-		param_key = actionId
+	data, err := io.ReadAll(io.LimitReader(resp.Body, max_body_size))
+	if err != nil {
+		return nil, err
 	}
-	s.SetParam(param_key, result)
 
-	return andflow.RESULT_SUCCESS, nil
+	return &netResponse{StatusCode: resp.StatusCode, Header: resp.Header, Body: data}, nil
+}
+
+// 按 JSONPath 提取返回内容到参数，例如 {"order_id": "$.data.id"}
+func (r *Net_requestRunner) extractResponse(s *andflow.Session, action *andflow.ActionModel, extract string, body []byte) error {
+	paths := make(map[string]string)
+	err := json.Unmarshal([]byte(extract), &paths)
+	if err != nil {
+		return errors.New("提取规则格式错误")
+	}
+
+	var data interface{}
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return errors.New("返回内容不是JSON格式，无法提取")
+	}
+
+	for name, path := range paths {
+		value, err := utils.JsonPath(data, path)
+		if err != nil {
+			s.AddLog_action_info(action.Name, action.Title, fmt.Sprintf("提取 %s 失败: %s", name, err.Error()))
+			continue
+		}
+		s.SetParam(name, value)
+	}
+	return nil
 }
 
-// 字符串覆盖
-func resetValue(s *string, newValue string) {
-	sByte := []byte(*s)
-	for i := 0; i < len(sByte); i++ {
-		sByte[i] = ' '
+// 请求日志，凭据请求头不记录，请求体中可能有密钥，只记录大小
+func (r *Net_requestRunner) dumpRequest(req *http.Request) string {
+	content := fmt.Sprintf("请求: %s %s\n", req.Method, redactURL(req.URL))
+	content += dumpHeaders(req.Header)
+	if req.ContentLength > 0 {
+		content += fmt.Sprintf("\n请求体: %d 字节", req.ContentLength)
 	}
-	*s = newValue
+	return content
+}
+
+// 返回日志
+func (r *Net_requestRunner) dumpResponse(res *netResponse) string {
+	content := fmt.Sprintf("返回: %d\n", res.StatusCode)
+	content += dumpHeaders(res.Header)
+	content += "\n" + limitLogContent(string(res.Body))
+	return content
 }
 
-// 获取域名
-func getDomain(url string) string {
-	if len(url) == 0 {
-		return url
+func dumpHeaders(header http.Header) string {
+	keys := make([]string, 0)
+	for k := range header {
+		keys = append(keys, k)
 	}
-	var domain string
-	//获取Domain
-	start := strings.Index(url, "//")
-	if start >= 0 {
-		domain = url[start+2:]
-	} else {
-		domain = url
+	sort.Strings(keys)
+
+	content := ""
+	for _, k := range keys {
+		v := header.Get(k)
+		if manager.IsCredentialHeader(k) {
+			v = "******"
+		}
+		content += k + ": " + v + "\n"
 	}
+	return content
+}
 
-	end := strings.Index(domain, "/")
-	if end >= 0 {
-		domain = domain[0:end]
+// 地址中的凭据查询参数不记录
+func redactURL(u *url.URL) string {
+	uu := *u
+	query := uu.Query()
+	for k := range query {
+		if manager.IsCredentialHeader(k) {
+			query.Set(k, "******")
+		}
 	}
-	end = strings.Index(domain, ":")
-	if end >= 0 {
-		domain = domain[0:end]
+	uu.RawQuery = strings.ReplaceAll(query.Encode(), "%2A", "*")
+	return uu.Redacted()
+}
+
+func limitLogContent(content string) string {
+	if len(content) > net_request_log_limit {
+		return content[:net_request_log_limit] + "..."
 	}
+	return content
+}
+
+// 解析JSON对象，值转换为字符串
+func parseValues(content string) (map[string]string, error) {
+	data := make(map[string]interface{})
+	err := json.Unmarshal([]byte(content), &data)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	for k, v := range data {
+		switch vv := v.(type) {
+		case nil:
+			values[k] = ""
+		case string:
+			values[k] = vv
+		case map[string]interface{}, []interface{}:
+			bs, _ := json.Marshal(vv)
+			values[k] = string(bs)
+		default:
+			values[k] = fmt.Sprintf("%v", vv)
+		}
+	}
+	return values, nil
+}
 
-	return domain
+func sortedValueKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
@@ -0,0 +1,237 @@
+package flow
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/meta"
+	"github.com/zone-7/chatflow_engine/engine/provider"
+)
+
+// 使用大模型识别修改但没有配置关键词时，出现这些词才调用大模型
+var param_correct_default_words = []string{"改成", "改为", "换成", "改一下", "修改", "更改", "更正", "不是", "错了", "搞错", "写错", "说错", "应该是"}
+
+// 参数修改记录
+type paramChange struct {
+	Param *ParamItem
+	Old   interface{}
+	New   interface{}
+}
+
+// 识别用户对已填写参数的修改，只修改涉及的参数，返回修改记录
+func (r *ParamRunner) correctAnswers(s *andflow.Session, action *andflow.ActionModel, prop map[string]string, params []*ParamItem, messageContent string) []*paramChange {
+	changes := make([]*paramChange, 0)
+
+	param_correct_words := prop["param_correct_words"] //修改关键词，例如：改成,改为,换成
+	param_correct_llm := prop["param_correct_llm"]     //使用大模型识别修改
+
+	use_llm := param_correct_llm == "true" || param_correct_llm == "1"
+	words := getWords(param_correct_words)
+	if len(words) == 0 && use_llm {
+		words = param_correct_default_words
+	}
+
+	//已经填写的参数
+	filled := make([]*ParamItem, 0)
+	for _, p := range params {
+		if s.GetParam(p.Name) != nil {
+			filled = append(filled, p)
+		}
+	}
+	if len(filled) == 0 {
+		return changes
+	}
+
+	//只有出现关键词才识别修改，避免每条消息都调用大模型
+	if len(words) == 0 {
+		return changes
+	}
+	word := ""
+	pos := -1
+	for _, w := range words {
+		if idx := strings.LastIndex(messageContent, w); idx > pos {
+			pos = idx
+			word = w
+		}
+	}
+	if pos < 0 {
+		return changes
+	}
+
+	values := make(map[string]string)
+	if use_llm {
+		res, err := r.correctByLLM(s, prop, filled, messageContent)
+		if err != nil {
+			s.AddLog_action_error(action.Name, action.Title, "大模型识别参数修改异常: "+err.Error())
+			return changes
+		}
+		values = res
+	} else {
+		values = r.correctByPattern(s, filled, messageContent, word)
+	}
+
+	for _, p := range filled {
+		value, ok := values[p.Name]
+		if !ok || len(strings.Trim(value, " ")) == 0 {
+			continue
+		}
+		v, err := coerceParamValue(p, value)
+		if err != nil {
+			continue
+		}
+		old := s.GetParam(p.Name)
+		if reflect.DeepEqual(old, v) || fmt.Sprintf("%v", old) == fmt.Sprintf("%v", v) {
+			continue
+		}
+		s.SetParam(p.Name, v)
+		changes = append(changes, &paramChange{Param: p, Old: old, New: v})
+	}
+
+	return changes
+}
+
+// 按关键词识别修改：提到参数名称的，取关键词后面的内容作为新值；
+// 配置了正则表达式或选项的，从消息中直接提取
+func (r *ParamRunner) correctByPattern(s *andflow.Session, filled []*ParamItem, messageContent string, word string) map[string]string {
+	values := make(map[string]string)
+
+	rest := messageContent
+	if idx := strings.LastIndex(messageContent, word); idx >= 0 {
+		rest = messageContent[idx+len(word):]
+	}
+	rest = strings.Trim(rest, " ，。,.!！?？:：\"'“”")
+
+	mentioned := make([]*ParamItem, 0)
+	for _, p := range filled {
+		if (len(p.Label) > 0 && strings.Contains(messageContent, p.Label)) || strings.Contains(messageContent, p.Name) {
+			mentioned = append(mentioned, p)
+		}
+	}
+
+	for _, p := range filled {
+		if p.ExtractType == EXTRACT_TYPE_FORMAT && len(p.ExtractFormat) > 0 {
+			reg, err := regexp.Compile(p.ExtractFormat)
+			if err == nil {
+				if data := reg.FindString(rest); len(data) > 0 {
+					values[p.Name] = data
+					continue
+				}
+			}
+		}
+		if options := getParamOptions(p.Options); len(options) > 0 {
+			if option, err := matchParamOption(options, rest); err == nil {
+				values[p.Name] = option
+				continue
+			}
+		}
+	}
+
+	//内置提取方式的参数先转换为标准格式
+	getValue := func(p *ParamItem) string {
+		if isNormalizeExtractType(p.ExtractType) {
+			return r.extractByNormalizer(s, p, rest)
+		}
+		return rest
+	}
+
+	//提到参数名称的，使用关键词后面的内容
+	if len(mentioned) == 1 && len(rest) > 0 {
+		if _, ok := values[mentioned[0].Name]; !ok {
+			values[mentioned[0].Name] = getValue(mentioned[0])
+		}
+	}
+
+	//没有提到参数名称，只有一个参数能接受新值时修改该参数
+	if len(values) == 0 && len(mentioned) == 0 && len(rest) > 0 {
+		candidates := make(map[string]string)
+		for _, p := range filled {
+			if !isNormalizeExtractType(p.ExtractType) && (len(p.DataType) == 0 || p.DataType == DATA_TYPE_STRING) {
+				continue
+			}
+			value := getValue(p)
+			if len(value) == 0 {
+				continue
+			}
+			if _, err := coerceParamValue(p, value); err == nil {
+				candidates[p.Name] = value
+			}
+		}
+		if len(candidates) == 1 {
+			values = candidates
+		}
+	}
+
+	return values
+}
+
+// 大模型识别修改，返回需要修改的参数及新值
+func (r *ParamRunner) correctByLLM(s *andflow.Session, prop map[string]string, filled []*ParamItem, messageContent string) (map[string]string, error) {
+	chatting, chat_params, err := r.getChatting(s, prop, "param_llm_")
+	if err != nil {
+		return nil, err
+	}
+	chat_params["response_format"] = "json_object"
+	chat_params["format"] = "json"
+
+	slots := ""
+	for _, p := range filled {
+		label := p.Label
+		if len(label) == 0 {
+			label = p.Name
+		}
+		slots += fmt.Sprintf("- %s（%s）当前值: %v\n", p.Name, label, s.GetParam(p.Name))
+	}
+
+	system_prompt := "用户已经填写了以下参数:\n" + slots
+	system_prompt += "\n判断用户的话是否要求修改其中的参数。只输出JSON对象，键为需要修改的参数名称，值为新的值；没有要求修改时输出 {}。不要猜测，只包含用户明确要求修改的参数。"
+
+	messages := []provider.ChatMessage{
+		{Role: provider.MESSAGE_ROLE_SYSTEM, Content: system_prompt},
+		{Role: provider.MESSAGE_ROLE_USER, Content: messageContent},
+	}
+
+	output, err := r.chatText(s, chatting, chat_params, messages)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]interface{})
+	err = json.Unmarshal([]byte(extractJsonText(output)), &res)
+	if err != nil {
+		return nil, fmt.Errorf("返回格式错误: %s", output)
+	}
+
+	values := make(map[string]string)
+	for k, v := range res {
+		if v == nil {
+			continue
+		}
+		if str, ok := v.(string); ok {
+			values[k] = str
+		} else {
+			values[k] = fmt.Sprintf("%v", v)
+		}
+	}
+	return values, nil
+}
+
+// 告诉用户修改了哪些参数
+func (r *ParamRunner) responseChanges(chatSession *ChatSession, changes []*paramChange) {
+	lines := make([]string, 0)
+	for _, c := range changes {
+		label := c.Param.Label
+		if len(label) == 0 {
+			label = c.Param.Name
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s → %s", label, unescapeHTML(c.Old), unescapeHTML(c.New)))
+	}
+
+	uid, _ := uuid.NewV4()
+	mid := strings.ReplaceAll(uid.String(), "-", "")
+	chatSession.Response(meta.ChatFlowMessage{MessageId: mid, Content: "已修改:\n" + strings.Join(lines, "\n"), MessageType: meta.CHAT_MESSAGE_TYPE_MESSAGE}, true)
+}
@@ -0,0 +1,119 @@
+package flow
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/provider"
+)
+
+// 从一条消息中提取所有还没有填写的参数
+// 正则表达式和提取流程按参数分别提取，配置了大模型时由大模型一次提取剩下的参数
+func (r *ParamRunner) fillMultiAnswers(s *andflow.Session, action *andflow.ActionModel, prop map[string]string, params []*ParamItem, messageContent string) {
+	chatSession := r.getChatSession(s)
+	opt := chatSession.Opt
+
+	missing := make([]*ParamItem, 0)
+	for _, p := range params {
+		if s.GetParam(p.Name) != nil {
+			continue
+		}
+
+		var data string
+		if p.ExtractType == EXTRACT_TYPE_FORMAT && len(p.ExtractFormat) > 0 {
+			reg, err := regexp.Compile(p.ExtractFormat)
+			if err == nil {
+				data = reg.FindString(messageContent)
+			}
+		} else if p.ExtractType == EXTRACT_TYPE_FLOW && len(p.ExtractFlow) > 0 {
+			data, _ = r.extractByFlow(opt, p.ExtractFlow, messageContent)
+		} else if isNormalizeExtractType(p.ExtractType) {
+			data = r.extractByNormalizer(s, p, messageContent)
+		}
+
+		//答案不正确的留给正在提问的参数处理
+		if len(data) == 0 || r.setAnswer(s, p, data) != nil {
+			missing = append(missing, p)
+		}
+	}
+
+	if len(missing) == 0 || (prop["param_extract_llm"] != "true" && prop["param_extract_llm"] != "1") {
+		return
+	}
+
+	values, err := r.extractByLLM(s, prop, missing, messageContent)
+	if err != nil {
+		s.AddLog_action_error(action.Name, action.Title, "大模型提取参数异常: "+err.Error())
+		return
+	}
+
+	for _, p := range missing {
+		v, ok := values[p.Name]
+		if !ok || v == nil {
+			continue
+		}
+		data := ""
+		if str, ok := v.(string); ok {
+			data = str
+		} else {
+			data = fmt.Sprintf("%v", v)
+		}
+		if len(strings.Trim(data, " ")) == 0 {
+			continue
+		}
+		r.setAnswer(s, p, data)
+	}
+}
+
+// 大模型根据所有参数定义提取参数值，返回参数名称到值的对象
+func (r *ParamRunner) extractByLLM(s *andflow.Session, prop map[string]string, params []*ParamItem, messageContent string) (map[string]interface{}, error) {
+	chatting, chat_params, err := r.getChatting(s, prop, "param_llm_")
+	if err != nil {
+		return nil, err
+	}
+	chat_params["response_format"] = "json_object"
+	chat_params["format"] = "json"
+
+	slots := ""
+	for _, p := range params {
+		slot := "- " + p.Name
+		if len(p.Label) > 0 {
+			slot += "（" + p.Label + "）"
+		}
+		if len(p.DataType) > 0 {
+			slot += " 类型: " + p.DataType
+		}
+		if options := getParamOptions(p.Options); len(options) > 0 {
+			slot += " 选项: " + strings.Join(options, "、")
+		}
+		if len(p.Ask) > 0 {
+			slot += " 说明: " + p.Ask
+		}
+		slots += slot + "\n"
+	}
+
+	system_prompt := "从用户的话中提取以下参数:\n" + slots
+	system_prompt += "\n今天是 " + time.Now().Format("2006-01-02 Monday") + "，日期使用 yyyy-MM-dd 格式，时间使用 yyyy-MM-dd HH:mm:ss 格式。"
+	system_prompt += "\n只输出JSON对象，键为参数名称，用户没有提到或者无法确定的参数值为null，不要猜测。"
+
+	messages := []provider.ChatMessage{
+		{Role: provider.MESSAGE_ROLE_SYSTEM, Content: system_prompt},
+		{Role: provider.MESSAGE_ROLE_USER, Content: messageContent},
+	}
+
+	output, err := r.chatText(s, chatting, chat_params, messages)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{})
+	err = json.Unmarshal([]byte(extractJsonText(output)), &values)
+	if err != nil {
+		return nil, fmt.Errorf("返回格式错误: %s", output)
+	}
+	return values, nil
+}
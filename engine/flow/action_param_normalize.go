@@ -0,0 +1,69 @@
+package flow
+
+import (
+	"time"
+
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/utils"
+)
+
+// 内置的提取方式，把自然语言的日期、时间、数字和金额转换为标准格式
+func isNormalizeExtractType(extract_type string) bool {
+	switch extract_type {
+	case EXTRACT_TYPE_DATE, EXTRACT_TYPE_TIME, EXTRACT_TYPE_DATETIME, EXTRACT_TYPE_NUMBER, EXTRACT_TYPE_MONEY:
+		return true
+	}
+	return false
+}
+
+// 会话的当前时间，使用会话时区和执行时设置的 datetime 参数
+func (r *ParamRunner) getSessionNow(s *andflow.Session) time.Time {
+	loc := r.getChatSession(s).GetLocation()
+	if tz, ok := s.GetParam("timezone").(string); ok && len(tz) > 0 {
+		if l, err := time.LoadLocation(tz); err == nil {
+			loc = l
+		}
+	}
+	if datetime, ok := s.GetParam("datetime").(string); ok {
+		if now, err := time.ParseInLocation(DATETIME_FORMAT, datetime, loc); err == nil {
+			return now
+		}
+	}
+	return time.Now().In(loc)
+}
+
+// 按照内置的提取方式提取，没有提取到时返回空字符串
+// 金额的货币代码保存到参数 <name>_currency
+func (r *ParamRunner) extractByNormalizer(s *andflow.Session, p *ParamItem, messageContent string) string {
+	switch p.ExtractType {
+	case EXTRACT_TYPE_DATE:
+		t, err := utils.NormalizeDate(messageContent, r.getSessionNow(s))
+		if err == nil {
+			return t.Format(DATE_FORMAT)
+		}
+	case EXTRACT_TYPE_TIME:
+		t, err := utils.NormalizeDateTime(messageContent, r.getSessionNow(s))
+		if err == nil {
+			return t.Format("15:04:05")
+		}
+	case EXTRACT_TYPE_DATETIME:
+		t, err := utils.NormalizeDateTime(messageContent, r.getSessionNow(s))
+		if err == nil {
+			return t.Format(DATETIME_FORMAT)
+		}
+	case EXTRACT_TYPE_NUMBER:
+		v, err := utils.NormalizeNumber(messageContent)
+		if err == nil {
+			return utils.Float64ToString(v)
+		}
+	case EXTRACT_TYPE_MONEY:
+		v, currency, err := utils.NormalizeMoney(messageContent)
+		if err == nil {
+			if len(currency) > 0 {
+				s.SetParam(p.Name+"_currency", currency)
+			}
+			return utils.Float64ToString(v)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,140 @@
+package flow
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/manager"
+	"github.com/zone-7/chatflow_engine/engine/meta"
+)
+
+// 记住用户的答案，下次会话时预先填写，由用户确认是否继续使用
+
+func isRememberParam(p *ParamItem) bool {
+	return p.Remember == "true" || p.Remember == "1"
+}
+
+// 加载用户上次保存的答案，填写还没有填写的参数，返回预先填写的参数
+func (r *ParamRunner) prefillAnswers(s *andflow.Session, params []*ParamItem) []*ParamItem {
+	prefilled := make([]*ParamItem, 0)
+
+	chatSession := r.getChatSession(s)
+	user_id := chatSession.Info.UserId
+	flow_code := chatSession.Info.FlowCode
+	if len(user_id) == 0 || len(flow_code) == 0 {
+		return prefilled
+	}
+
+	remember := false
+	for _, p := range params {
+		if isRememberParam(p) && s.GetParam(p.Name) == nil {
+			remember = true
+			break
+		}
+	}
+	if !remember {
+		return prefilled
+	}
+
+	sessionManager := manager.NewChatSessionInfoManager(chatSession.Opt)
+	values, err := sessionManager.LoadUserChatFlowParams(user_id, flow_code)
+	if err != nil {
+		return prefilled
+	}
+
+	for _, p := range params {
+		if !isRememberParam(p) || s.GetParam(p.Name) != nil {
+			continue
+		}
+		for _, v := range values {
+			if v.Name != p.Name || len(v.Value) == 0 {
+				continue
+			}
+			//保存之后参数定义可能修改过，重新校验
+			if r.setAnswer(s, p, v.Value) == nil {
+				prefilled = append(prefilled, p)
+			}
+			break
+		}
+	}
+
+	return prefilled
+}
+
+// 保存需要记住的答案到用户参数
+func (r *ParamRunner) storeAnswers(s *andflow.Session, action *andflow.ActionModel, params []*ParamItem) {
+	chatSession := r.getChatSession(s)
+	user_id := chatSession.Info.UserId
+	flow_code := chatSession.Info.FlowCode
+	if len(user_id) == 0 || len(flow_code) == 0 {
+		return
+	}
+
+	answers := make([]*ParamItem, 0)
+	for _, p := range params {
+		if isRememberParam(p) && s.GetParam(p.Name) != nil {
+			answers = append(answers, p)
+		}
+	}
+	if len(answers) == 0 {
+		return
+	}
+
+	sessionManager := manager.NewChatSessionInfoManager(chatSession.Opt)
+	values, err := sessionManager.LoadUserChatFlowParams(user_id, flow_code)
+	if err != nil || values == nil {
+		values = make([]*meta.ChatFlowParam, 0)
+	}
+
+	for _, p := range answers {
+		value := string(unescapeHTML(s.GetParam(p.Name)))
+
+		var item *meta.ChatFlowParam
+		for _, v := range values {
+			if v.Name == p.Name {
+				item = v
+				break
+			}
+		}
+		if item == nil {
+			item = &meta.ChatFlowParam{Name: p.Name}
+			values = append(values, item)
+		}
+		item.Label = p.Label
+		item.Value = value
+		item.InputType = p.InputType
+	}
+
+	err = sessionManager.StoreUserChatFlowParams(meta.UserChatFlowParams{UserId: user_id, FlowCode: flow_code, Params: values})
+	if err != nil {
+		log.Printf("保存用户参数异常:%v", err)
+		s.AddLog_action_error(action.Name, action.Title, "保存用户参数异常: "+err.Error())
+	}
+}
+
+// 提示用户确认是否使用上次的答案，可以使用 {{remembered}} 和参数名称
+func (r *ParamRunner) responsePrefilled(s *andflow.Session, action *andflow.ActionModel, chatSession *ChatSession, prefilled []*ParamItem) {
+	lines := make([]string, 0)
+	for _, p := range prefilled {
+		label := p.Label
+		if len(label) == 0 {
+			label = p.Name
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", label, unescapeHTML(s.GetParam(p.Name))))
+	}
+	remembered := strings.Join(lines, "\n")
+
+	content := "上次填写的信息:\n" + remembered + "\n是否继续使用？"
+	temp := r.getActionParam(s, action, "param_remember_ask", nil)
+	if len(temp) > 0 {
+		ps := s.GetParamMap()
+		ps["remembered"] = remembered
+		if vv, err := replaceTemplate(temp, "param_remember_ask", ps); err == nil {
+			content = vv
+		}
+	}
+
+	chatSession.Response(meta.ChatFlowMessage{Content: content, MessageType: meta.CHAT_MESSAGE_TYPE_MESSAGE}, true)
+}
@@ -14,6 +14,16 @@ const (
 	EXTRACT_TYPE_FULL   = "full"
 	EXTRACT_TYPE_FLOW   = "flow"
 	EXTRACT_TYPE_FORMAT = "format"
+
+	//内置的提取方式，相对日期按照会话时区计算
+	EXTRACT_TYPE_DATE     = "date"     //日期，例如 明天、下周五、next Tuesday，转换为 yyyy-MM-dd
+	EXTRACT_TYPE_TIME     = "time"     //时间，例如 下午三点半、3pm，转换为 HH:mm:ss
+	EXTRACT_TYPE_DATETIME = "datetime" //日期时间，例如 明天下午三点，转换为 yyyy-MM-dd HH:mm:ss
+	EXTRACT_TYPE_NUMBER   = "number"   //数字，例如 三百五十、1.5万、twenty
+	EXTRACT_TYPE_MONEY    = "money"    //金额，例如 三百五十元、五块五、$12.5
+
+	PARAM_EXTRACT_MODE_SINGLE = ""      //只填写正在提问的参数
+	PARAM_EXTRACT_MODE_MULTI  = "multi" //每条消息填写所有能提取的参数
 )
 
 func init() {
@@ -32,6 +42,8 @@ type ParamItem struct {
 	ExtractFormat string `json:"extract_format" orm:"size(200);null"` //实体提取正则表达式
 	Options       string `json:"options" orm:"size(200);null"`        //选项
 	Scope         string `json:"scope" orm:"size(200);null"`          //取值范围
+	InvalidAsk    string `json:"invalid_ask" orm:"size(200);null"`    //答案不正确时的提示
+	Remember      string `json:"remember" orm:"size(10);null"`        //是否记住答案，下次会话时预先填写 true、false
 	OrderNo       int    `json:"order_no" orm:"default(0)"`           //顺序
 }
 
@@ -47,13 +59,15 @@ func (r *ParamRunner) Execute(s *andflow.Session, param *andflow.ActionParam, st
 	action := s.GetFlow().GetAction(param.ActionId)
 	chatSession := r.getChatSession(s)
 
-	prop, err := r.getActionParams(action, s.GetParamMap())
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
 	if err != nil {
 		return andflow.RESULT_FAILURE, err
 	}
 
 	// 提示词
 	param_ask := prop["param_ask"]
+	// 答案不正确时的提示词，可以使用 {{label}} {{value}} {{error}} {{ask}}
+	param_invalid_ask := r.getActionParam(s, action, "param_invalid_ask", nil)
 
 	// 返回
 	param_back_links := prop["param_back_links"]
@@ -66,6 +80,17 @@ func (r *ParamRunner) Execute(s *andflow.Session, param *andflow.ActionParam, st
 	param_check_words_no := prop["param_check_words_no"]
 	param_check_links := prop["param_check_links"]
 
+	//不使用上次记住的答案的关键词
+	param_remember_words_no := prop["param_remember_words_no"]
+	if len(param_remember_words_no) == 0 {
+		param_remember_words_no = param_check_words_no
+	}
+	if len(param_remember_words_no) == 0 {
+		param_remember_words_no = "不用,不是,不要,不对,否,no"
+	}
+
+	param_extract_mode := prop["param_extract_mode"] //提取方式
+
 	param_source := prop["param_source"]           //参数信息来自参数还是输入
 	param_source_temp := prop["param_source_temp"] //参数信息来自哪个参数
 
@@ -110,16 +135,68 @@ func (r *ParamRunner) Execute(s *andflow.Session, param *andflow.ActionParam, st
 
 	/*参数*/
 
+	//正在确认是否使用上次记住的答案，不使用就清空预先填写的参数
+	if action.GetParam("asking_param_remember_"+action.Id) == "true" {
+		action.SetParam("asking_param_remember_"+action.Id, "")
+		for _, wn := range getWords(param_remember_words_no) {
+			if containsWord(requestContent_param, wn) {
+				for _, name := range strings.Split(action.GetParam("param_prefilled_"+action.Id), ",") {
+					if len(name) > 0 {
+						s.SetParam(name, nil)
+					}
+				}
+				break
+			}
+		}
+		action.SetParam("param_prefilled_"+action.Id, "")
+	}
+
+	//修改已经填写的参数，修改后需要重新确认
+	corrected := false
+	if len(requestContent_param) > 0 {
+		changes := r.correctAnswers(s, action, prop, params, requestContent_param)
+		if len(changes) > 0 {
+			corrected = true
+			r.responseChanges(chatSession, changes)
+			action.SetParam("asking_param_check_"+action.Id, "")
+			action.SetParam("param_checked_"+action.Id, "")
+		}
+	}
+
+	//多卡槽模式，从每条消息中提取所有还没有填写的参数
+	if param_extract_mode == PARAM_EXTRACT_MODE_MULTI && len(requestContent_param) > 0 {
+		r.fillMultiAnswers(s, action, prop, params, requestContent_param)
+	}
+
+	//第一次提问前，使用上次记住的答案预先填写，由用户确认
+	if r.isFirstAsk(s, action, params) && len(action.GetParam("param_remembered_"+action.Id)) == 0 {
+		action.SetParam("param_remembered_"+action.Id, "true")
+		prefilled := r.prefillAnswers(s, params)
+		if len(prefilled) > 0 {
+			names := make([]string, 0)
+			for _, p := range prefilled {
+				names = append(names, p.Name)
+			}
+			action.SetParam("param_prefilled_"+action.Id, strings.Join(names, ","))
+			action.SetParam("asking_param_remember_"+action.Id, "true")
+			r.responsePrefilled(s, action, chatSession, prefilled)
+			return andflow.RESULT_REJECT, nil
+		}
+	}
+
 	//没有消息就发送提示词
-	if r.isFirstAsk(s, action, params) {
+	if r.isFirstAsk(s, action, params) && r.hasMissing(s, params) {
 		chatSession.Response(meta.ChatFlowMessage{Content: param_ask, MessageType: meta.CHAT_MESSAGE_TYPE_MESSAGE}, true)
 		action.SetParam("param_first_ask_"+action.Id, "false")
 	}
 
 	//正在提问，由用户提供的实体，用户答案
 	asking_param_name := action.GetParam("asking_param_name_" + action.Id)
-	if len(asking_param_name) > 0 {
-		r.fillAnswers(s, action, params, asking_param_name, requestContent_param)
+	if len(asking_param_name) > 0 && s.GetParam(asking_param_name) == nil && !corrected {
+		invalid := r.fillAnswers(s, action, params, asking_param_name, requestContent_param)
+		if invalid != nil {
+			r.responseInvalid(chatSession, invalid, param_invalid_ask)
+		}
 	}
 
 	//根据参数提出疑问
@@ -187,7 +264,7 @@ func (r *ParamRunner) Execute(s *andflow.Session, param *andflow.ActionParam, st
 		param_checked := action.GetParam("param_checked_" + action.Id)
 		//如果还没有回答就提示用户确认
 		if param_checked == "" || param_checked == "false" {
-			param_check_ask := r.getActionParam(action, "param_check_ask", s.GetParamMap())
+			param_check_ask := r.getActionParam(s, action, "param_check_ask", s.GetParamMap())
 
 			if len(param_check_ask) > 0 {
 
@@ -208,6 +285,9 @@ func (r *ParamRunner) Execute(s *andflow.Session, param *andflow.ActionParam, st
 		state.NextActionIds = append(state.NextActionIds, act.Id)
 	}
 
+	//保存需要记住的答案
+	r.storeAnswers(s, action, params)
+
 	//清空状态
 	r.clearStates(s, action)
 
@@ -240,6 +320,19 @@ func (r *ParamRunner) clearStates(s *andflow.Session, action *andflow.ActionMode
 	action.SetParam("asking_param_check_"+action.Id, "")
 	action.SetParam("param_checked_"+action.Id, "")
 	action.SetParam("param_first_ask_"+action.Id, "")
+	action.SetParam("asking_param_remember_"+action.Id, "")
+	action.SetParam("param_prefilled_"+action.Id, "")
+	action.SetParam("param_remembered_"+action.Id, "")
+}
+
+// 是否还有没有填写的参数
+func (r *ParamRunner) hasMissing(s *andflow.Session, params []*ParamItem) bool {
+	for _, p := range params {
+		if s.GetParam(p.Name) == nil {
+			return true
+		}
+	}
+	return false
 }
 
 func (r *ParamRunner) isFirstAsk(s *andflow.Session, action *andflow.ActionModel, params []*ParamItem) bool {
@@ -259,8 +352,68 @@ func (r *ParamRunner) clearAnswers(s *andflow.Session, action *andflow.ActionMod
 	}
 }
 
-// 解析用户提问或者回答内容，答案提取
-func (r *ParamRunner) fillAnswers(s *andflow.Session, action *andflow.ActionModel, params []*ParamItem, param_name string, messageContent string) {
+// 答案校验不通过
+type paramInvalid struct {
+	Param *ParamItem
+	Value string
+	Error error
+}
+
+// 提示答案不正确，之后会重新提问
+func (r *ParamRunner) responseInvalid(chatSession *ChatSession, invalid *paramInvalid, param_invalid_ask string) {
+	temp := invalid.Param.InvalidAsk
+	if len(temp) == 0 {
+		temp = param_invalid_ask
+	}
+
+	content := invalid.Error.Error()
+	if len(temp) > 0 {
+		label := invalid.Param.Label
+		if len(label) == 0 {
+			label = invalid.Param.Name
+		}
+		ps := map[string]interface{}{"label": label, "name": invalid.Param.Name, "value": invalid.Value, "error": invalid.Error.Error(), "ask": invalid.Param.Ask}
+		if vv, err := replaceTemplate(temp, "param_invalid_ask", ps); err == nil {
+			content = vv
+		}
+	}
+
+	chatSession.Response(meta.ChatFlowMessage{Content: content, MessageType: meta.CHAT_MESSAGE_TYPE_MESSAGE}, true)
+}
+
+// 校验并转换答案类型后保存
+func (r *ParamRunner) setAnswer(s *andflow.Session, p *ParamItem, value string) *paramInvalid {
+	v, err := coerceParamValue(p, value)
+	if err != nil {
+		return &paramInvalid{Param: p, Value: value, Error: err}
+	}
+	s.SetParam(p.Name, v)
+	return nil
+}
+
+// 调用实体提取流程，返回流程的回答
+func (r *ParamRunner) extractByFlow(opt meta.Option, flow_code string, messageContent string) (string, error) {
+	msg := meta.ChatFlowMessage{}
+	msg.Content = messageContent
+	msg.FlowCode = flow_code
+	msg.FlowSpace = meta.FLOW_SPACE_PRODUCT
+
+	subChatSession, err := OpenChatSession(opt, msg, []string{meta.CHAT_MESSAGE_TYPE_MESSAGE}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	subChatSession.Chat(msg)
+	responses := subChatSession.GetCurrentResponseMessages()
+	data := ""
+	for _, m := range responses {
+		data += m.Content
+	}
+	return data, nil
+}
+
+// 解析用户提问或者回答内容，答案提取，答案不正确时返回错误
+func (r *ParamRunner) fillAnswers(s *andflow.Session, action *andflow.ActionModel, params []*ParamItem, param_name string, messageContent string) *paramInvalid {
 	chatSession := r.getChatSession(s)
 	opt := chatSession.Opt
 
@@ -271,38 +424,32 @@ func (r *ParamRunner) fillAnswers(s *andflow.Session, action *andflow.ActionMode
 		}
 
 		if p.ExtractType == EXTRACT_TYPE_FULL { //全部提取
-			s.SetParam(name, messageContent)
+			return r.setAnswer(s, p, messageContent)
 		} else if p.ExtractType == EXTRACT_TYPE_FORMAT && len(p.ExtractFormat) > 0 { //使用正则表达式提取
 
 			reg := regexp.MustCompile(p.ExtractFormat)
 			data := reg.Find([]byte(messageContent))
 			if data != nil {
-				s.SetParam(name, string(data))
+				return r.setAnswer(s, p, string(data))
 			}
 		} else if p.ExtractType == EXTRACT_TYPE_FLOW && len(p.ExtractFlow) > 0 { //调用模型服务提取实体信息
 
-			msg := meta.ChatFlowMessage{}
-			msg.Content = messageContent
-			msg.FlowCode = p.ExtractFlow
-			msg.FlowSpace = meta.FLOW_SPACE_PRODUCT
-
-			subChatSession, err := OpenChatSession(opt, msg, []string{meta.CHAT_MESSAGE_TYPE_MESSAGE}, nil)
+			data, err := r.extractByFlow(opt, p.ExtractFlow, messageContent)
 			if err != nil {
 				continue
 			}
-
-			subChatSession.Chat(msg)
-			responses := subChatSession.GetCurrentResponseMessages()
-			data := ""
-			for _, m := range responses {
-				data += m.Content
-			}
 			if len(data) > 0 {
-				s.SetParam(name, data)
+				return r.setAnswer(s, p, data)
 			}
 
+		} else if isNormalizeExtractType(p.ExtractType) { //内置的日期、时间、数字和金额提取
+			data := r.extractByNormalizer(s, p, messageContent)
+			if len(data) > 0 {
+				return r.setAnswer(s, p, data)
+			}
 		}
 
 	}
 
+	return nil
 }
@@ -0,0 +1,233 @@
+package flow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DATA_TYPE_STRING   = "string"
+	DATA_TYPE_NUMBER   = "number"
+	DATA_TYPE_INTEGER  = "integer"
+	DATA_TYPE_DATE     = "date"
+	DATA_TYPE_DATETIME = "datetime"
+	DATA_TYPE_ENUM     = "enum"
+
+	DATE_FORMAT     = "2006-01-02"
+	DATETIME_FORMAT = "2006-01-02 15:04:05"
+)
+
+var param_number_reg = regexp.MustCompile(`[-+]?\d+(\.\d+)?`)
+
+// 可以识别的日期格式
+var param_date_layouts = []string{
+	"2006-01-02", "2006/01/02", "2006.01.02", "20060102", "2006年01月02日", "2006年1月2日", "2006-1-2", "2006/1/2",
+}
+
+// 可以识别的时间格式
+var param_datetime_layouts = []string{
+	"2006-01-02 15:04:05", "2006-01-02 15:04", "2006/01/02 15:04:05", "2006/01/02 15:04", "2006-01-02T15:04:05Z07:00", "2006-01-02T15:04:05",
+	"2006年01月02日 15:04:05", "2006年01月02日 15:04", "2006年1月2日 15:04", "2006年1月2日15点04分", "2006年1月2日15点",
+}
+
+// 按照数据类型、选项和取值范围校验答案，返回转换后的值
+func coerceParamValue(p *ParamItem, value string) (interface{}, error) {
+	value = strings.Trim(value, " \r\n\t")
+	if len(value) == 0 {
+		return nil, errors.New("内容不能为空")
+	}
+
+	//选项
+	options := getParamOptions(p.Options)
+	if len(options) > 0 || p.DataType == DATA_TYPE_ENUM {
+		option, err := matchParamOption(options, value)
+		if err != nil {
+			return nil, err
+		}
+		if p.DataType == DATA_TYPE_ENUM || len(p.DataType) == 0 || p.DataType == DATA_TYPE_STRING {
+			return option, nil
+		}
+		value = option
+	}
+
+	min, max := getParamScope(p.Scope)
+
+	switch p.DataType {
+	case DATA_TYPE_NUMBER, "float", "double":
+		num, err := parseParamNumber(value)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkNumberScope(num, min, max); err != nil {
+			return nil, err
+		}
+		return num, nil
+
+	case DATA_TYPE_INTEGER, "int", "long":
+		num, err := parseParamNumber(value)
+		if err != nil {
+			return nil, err
+		}
+		if num != float64(int64(num)) {
+			return nil, errors.New("请输入整数")
+		}
+		if err := checkNumberScope(num, min, max); err != nil {
+			return nil, err
+		}
+		return int64(num), nil
+
+	case DATA_TYPE_DATE:
+		t, err := parseParamTime(value, param_date_layouts)
+		if err != nil {
+			t, err = parseParamTime(value, param_datetime_layouts)
+		}
+		if err != nil {
+			return nil, errors.New("日期格式不正确")
+		}
+		date := t.Format(DATE_FORMAT)
+		if err := checkStringScope(date, min, max, DATE_FORMAT); err != nil {
+			return nil, err
+		}
+		return date, nil
+
+	case DATA_TYPE_DATETIME:
+		t, err := parseParamTime(value, param_datetime_layouts)
+		if err != nil {
+			t, err = parseParamTime(value, param_date_layouts)
+		}
+		if err != nil {
+			return nil, errors.New("时间格式不正确")
+		}
+		datetime := t.Format(DATETIME_FORMAT)
+		if err := checkStringScope(datetime, min, max, DATETIME_FORMAT); err != nil {
+			return nil, err
+		}
+		return datetime, nil
+	}
+
+	//文本的取值范围为长度范围
+	if len(min) > 0 || len(max) > 0 {
+		length := float64(len([]rune(value)))
+		if err := checkNumberScope(length, min, max); err != nil {
+			return nil, errors.New("长度" + err.Error())
+		}
+	}
+
+	return value, nil
+}
+
+// 选项，JSON数组或者逗号、换行分隔
+func getParamOptions(options string) []string {
+	options = strings.Trim(options, " ")
+	if len(options) == 0 {
+		return nil
+	}
+	list := make([]string, 0)
+	if strings.HasPrefix(options, "[") && json.Unmarshal([]byte(options), &list) == nil {
+		return list
+	}
+	for _, o := range strings.FieldsFunc(options, func(r rune) bool { return r == ',' || r == '，' || r == '\n' || r == '、' }) {
+		o = strings.Trim(o, " \r")
+		if len(o) > 0 {
+			list = append(list, o)
+		}
+	}
+	return list
+}
+
+// 匹配选项，先完全匹配，再匹配包含选项的回答
+func matchParamOption(options []string, value string) (string, error) {
+	for _, o := range options {
+		if strings.EqualFold(o, value) {
+			return o, nil
+		}
+	}
+	matched := make([]string, 0)
+	for _, o := range options {
+		if strings.Contains(value, o) {
+			matched = append(matched, o)
+		}
+	}
+	if len(matched) == 1 {
+		return matched[0], nil
+	}
+	return "", errors.New("请从以下选项中选择: " + strings.Join(options, "、"))
+}
+
+// 取值范围，格式 min,max 或 min~max，可以用[]括起来，某一端为空表示不限制
+func getParamScope(scope string) (string, string) {
+	scope = strings.Trim(scope, " []()（）")
+	if len(scope) == 0 {
+		return "", ""
+	}
+	for _, sep := range []string{",", "，", "~", "至"} {
+		if idx := strings.Index(scope, sep); idx >= 0 {
+			return strings.Trim(scope[:idx], " "), strings.Trim(scope[idx+len(sep):], " ")
+		}
+	}
+	return "", ""
+}
+
+func parseParamNumber(value string) (float64, error) {
+	num, err := strconv.ParseFloat(strings.ReplaceAll(value, ",", ""), 64)
+	if err == nil {
+		return num, nil
+	}
+	found := param_number_reg.FindString(value)
+	if len(found) == 0 {
+		return 0, errors.New("请输入数字")
+	}
+	return strconv.ParseFloat(found, 64)
+}
+
+func parseParamTime(value string, layouts []string) (time.Time, error) {
+	for _, layout := range layouts {
+		t, err := time.ParseInLocation(layout, value, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("时间格式不正确")
+}
+
+func checkNumberScope(num float64, min string, max string) error {
+	if len(min) > 0 {
+		m, err := strconv.ParseFloat(min, 64)
+		if err == nil && num < m {
+			return fmt.Errorf("不能小于%s", min)
+		}
+	}
+	if len(max) > 0 {
+		m, err := strconv.ParseFloat(max, 64)
+		if err == nil && num > m {
+			return fmt.Errorf("不能大于%s", max)
+		}
+	}
+	return nil
+}
+
+// 日期范围，范围值按照相同格式比较
+func checkStringScope(value string, min string, max string, layout string) error {
+	if len(min) > 0 {
+		if t, err := parseParamTime(min, append(param_datetime_layouts, param_date_layouts...)); err == nil {
+			min = t.Format(layout)
+		}
+		if value < min {
+			return fmt.Errorf("不能早于%s", min)
+		}
+	}
+	if len(max) > 0 {
+		if t, err := parseParamTime(max, append(param_datetime_layouts, param_date_layouts...)); err == nil {
+			max = t.Format(layout)
+		}
+		if value > max {
+			return fmt.Errorf("不能晚于%s", max)
+		}
+	}
+	return nil
+}
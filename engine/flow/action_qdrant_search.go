@@ -28,7 +28,7 @@ func (r *Qdrant_search_Runner) Execute(s *andflow.Session, param *andflow.Action
 
 	action := s.GetFlow().GetAction(param.ActionId)
 
-	prop, err := r.getActionParams(action, s.GetParamMap())
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
 	if err != nil {
 		return andflow.RESULT_FAILURE, err
 	}
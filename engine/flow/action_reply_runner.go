@@ -26,7 +26,7 @@ func (r *ReplyRunner) Execute(s *andflow.Session, param *andflow.ActionParam, st
 	action := s.GetFlow().GetAction(param.ActionId)
 	chatSession := r.getChatSession(s)
 
-	prop, err := r.getActionParams(action, s.GetParamMap())
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
 	if err != nil {
 		return andflow.RESULT_FAILURE, err
 	}
@@ -0,0 +1,119 @@
+package flow
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/provider"
+	"github.com/zone-7/chatflow_engine/engine/utils"
+)
+
+// 大模型意图分类结果
+type routeLLMResult struct {
+	Branch     interface{} `json:"branch"`     //分支编号，0表示都不符合
+	Confidence float64     `json:"confidence"` //置信度 0~1
+}
+
+// 分支说明，来自连线和后续节点的名称、标题和关键词
+func getRouteBranchDescription(link *andflow.LinkModel, target *andflow.ActionModel) string {
+	names := make([]string, 0)
+	for _, n := range []string{link.Title, link.Name, target.Title, target.Name} {
+		n = strings.Trim(n, " ")
+		if len(n) > 0 && utils.StringsIndex(names, n) < 0 {
+			names = append(names, n)
+		}
+	}
+
+	desc := strings.Join(names, " / ")
+
+	words := append(getWords(link.Keywords), getWords(target.Keywords)...)
+	if len(words) > 0 {
+		desc += "，例如: " + strings.Join(words, "、")
+	}
+	return desc
+}
+
+// 通过大模型判断走哪个分支，置信度低于阈值时返回空
+func (r *RouteRunner) getNextActionsByLLM(s *andflow.Session, action *andflow.ActionModel, prop map[string]string, content string) ([]*andflow.ActionModel, error) {
+	var nas []*andflow.ActionModel
+
+	route_threshold := prop["route_threshold"]   //置信度阈值
+	route_llm_prompt := prop["route_llm_prompt"] //补充说明
+	route_param_key := prop["route_param_key"]   //分类结果
+
+	threshold := 0.6
+	if len(route_threshold) > 0 {
+		//格式错误时使用默认阈值，不能变成0接受所有分类
+		v, err := utils.StringToFloat64(route_threshold)
+		if err != nil || v < 0 || v > 1 {
+			s.AddLog_action_error(action.Name, action.Title, "置信度阈值格式错误，使用默认值0.6: "+route_threshold)
+		} else {
+			threshold = v
+		}
+	}
+
+	chatting, params, err := r.getChatting(s, prop, "route_llm_")
+	if err != nil {
+		return nil, err
+	}
+	//支持JSON模式的模型约束输出格式，openai、kimi 使用 response_format，ollama 使用 format
+	params["response_format"] = "json_object"
+	params["format"] = "json"
+
+	nextLinks := s.GetFlow().GetLinkBySourceId(action.Id)
+	if len(nextLinks) == 0 {
+		return nas, nil
+	}
+
+	targets := make([]*andflow.ActionModel, 0)
+	branches := ""
+	for i, link := range nextLinks {
+		target := s.GetFlow().GetAction(link.TargetId)
+		targets = append(targets, target)
+		branches += fmt.Sprintf("%d. %s\n", i+1, getRouteBranchDescription(link, target))
+	}
+
+	system_prompt := "你是一个意图分类器，需要判断用户的话属于以下哪个分支:\n" + branches
+	system_prompt += "0. 都不符合\n"
+	if len(route_llm_prompt) > 0 {
+		system_prompt += "\n" + route_llm_prompt + "\n"
+	}
+	system_prompt += "\n只输出JSON，不要输出其他内容，格式: {\"branch\": 分支编号, \"confidence\": 0到1之间的置信度}"
+
+	messages := []provider.ChatMessage{
+		{Role: provider.MESSAGE_ROLE_SYSTEM, Content: system_prompt},
+		{Role: provider.MESSAGE_ROLE_USER, Content: content},
+	}
+
+	output, err := r.chatText(s, chatting, params, messages)
+	if err != nil {
+		return nil, err
+	}
+
+	result := routeLLMResult{}
+	err = json.Unmarshal([]byte(extractJsonText(output)), &result)
+	if err != nil {
+		//结果无法解析时明确回退到关键词匹配，不当作“都不符合”
+		s.AddLog_action_error(action.Name, action.Title, "意图分类结果格式错误，使用关键词匹配: "+output)
+		if len(route_param_key) > 0 {
+			s.SetParam(route_param_key, map[string]interface{}{"branch": 0, "confidence": 0, "fallback": "keyword", "error": err.Error()})
+		}
+		return r.getNextActionsByKeyword(s, action, content), nil
+	}
+
+	branch, _ := strconv.Atoi(strings.Trim(fmt.Sprintf("%v", result.Branch), " "))
+
+	if len(route_param_key) > 0 {
+		s.SetParam(route_param_key, map[string]interface{}{"branch": branch, "confidence": result.Confidence})
+	}
+
+	if branch <= 0 || branch > len(targets) || result.Confidence < threshold {
+		return nas, nil
+	}
+
+	nas = append(nas, targets[branch-1])
+	return nas, nil
+}
@@ -26,7 +26,7 @@ func (r *RouteRunner) Execute(s *andflow.Session, param *andflow.ActionParam, st
 	action := s.GetFlow().GetAction(param.ActionId)
 	chatSession := r.getChatSession(s)
 
-	prop, err := r.getActionParams(action, s.GetParamMap())
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
 	if err != nil {
 		return andflow.RESULT_FAILURE, err
 	}
@@ -57,7 +57,34 @@ func (r *RouteRunner) Execute(s *andflow.Session, param *andflow.ActionParam, st
 		}
 	}
 
-	if len(requestContent_route) > 0 {
+	if len(requestContent_route) > 0 && route_method == "llm" {
+		// 大模型意图分类，置信度不够时提问
+		routeNextActions, err := r.getNextActionsByLLM(s, action, prop, requestContent_route)
+		if err != nil {
+			return andflow.RESULT_FAILURE, err
+		}
+		if len(routeNextActions) > 0 {
+			for _, a := range routeNextActions {
+				state.NextActionIds = append(state.NextActionIds, a.Id)
+			}
+			return andflow.RESULT_SUCCESS, nil
+		}
+	} else if len(requestContent_route) > 0 && route_method == "semantic" {
+		// 语义相似度匹配，没有匹配时走没有关键词的路径
+		routeNextActions, err := r.getNextActionsBySemantic(s, action, prop, requestContent_route)
+		if err != nil {
+			return andflow.RESULT_FAILURE, err
+		}
+		if len(routeNextActions) == 0 {
+			routeNextActions = r.getNextActionsByEmptyKeyword(s, action)
+		}
+		if len(routeNextActions) > 0 {
+			for _, a := range routeNextActions {
+				state.NextActionIds = append(state.NextActionIds, a.Id)
+			}
+			return andflow.RESULT_SUCCESS, nil
+		}
+	} else if len(requestContent_route) > 0 {
 		keyword := ""
 
 		// 调用子流程
@@ -0,0 +1,195 @@
+package flow
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/provider"
+	"github.com/zone-7/chatflow_engine/engine/utils"
+)
+
+// 分支示例语句的向量
+type routeBranchVectors struct {
+	TargetId string
+	Name     string
+	Vectors  [][]float64
+}
+
+// 每个路由节点的示例向量，流程版本变化后重新计算
+type routeVectorCache struct {
+	Edition  int64
+	Model    string
+	Branches []*routeBranchVectors
+}
+
+var route_vector_cache = make(map[string]*routeVectorCache)
+var route_vector_lock sync.Mutex
+
+// 分支得分
+type routeBranchScore struct {
+	TargetId string  `json:"target_id"`
+	Name     string  `json:"name"`
+	Score    float64 `json:"score"`
+}
+
+// 分支示例语句，每行一句，句子中可以有空格
+func getRouteExamples(text string) []string {
+	examples := make([]string, 0)
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.Trim(line, " \r\t")
+		if len(line) > 0 {
+			examples = append(examples, line)
+		}
+	}
+	return examples
+}
+
+// 获取分支示例向量，按流程版本缓存
+func (r *RouteRunner) getBranchVectors(s *andflow.Session, action *andflow.ActionModel, embedding provider.Embedding, params map[string]string) ([]*routeBranchVectors, error) {
+	chatSession := r.getChatSession(s)
+
+	var edition int64
+	flow_space := ""
+	if chatSession.Chatflow != nil {
+		edition = chatSession.Chatflow.Edition
+	}
+	if chatSession.Info != nil {
+		flow_space = chatSession.Info.FlowSpace
+	}
+
+	model := params["url"] + "/" + params["model"]
+
+	key := flow_space + "/" + s.GetFlow().Code + "/" + action.Id
+
+	route_vector_lock.Lock()
+	cache := route_vector_cache[key]
+	route_vector_lock.Unlock()
+
+	if cache != nil && cache.Edition == edition && cache.Model == model {
+		return cache.Branches, nil
+	}
+
+	branches := make([]*routeBranchVectors, 0)
+	contents := make([]string, 0)
+	counts := make([]int, 0)
+
+	nextLinks := s.GetFlow().GetLinkBySourceId(action.Id)
+	for _, link := range nextLinks {
+		target := s.GetFlow().GetAction(link.TargetId)
+
+		//示例语句来自连线和后续节点的关键词
+		words := append(getRouteExamples(link.Keywords), getRouteExamples(target.Keywords)...)
+		if len(words) == 0 {
+			continue
+		}
+
+		name := link.Name
+		if len(name) == 0 {
+			name = target.Name
+		}
+
+		branches = append(branches, &routeBranchVectors{TargetId: target.Id, Name: name, Vectors: make([][]float64, 0)})
+		contents = append(contents, words...)
+		counts = append(counts, len(words))
+	}
+
+	if len(contents) > 0 {
+		vectors, err := embedding.Embed(params, contents)
+		if err != nil {
+			return nil, err
+		}
+		if len(vectors) != len(contents) {
+			return nil, fmt.Errorf("向量数量不一致: %d/%d", len(vectors), len(contents))
+		}
+
+		index := 0
+		for i, count := range counts {
+			branches[i].Vectors = vectors[index : index+count]
+			index += count
+		}
+	}
+
+	route_vector_lock.Lock()
+	route_vector_cache[key] = &routeVectorCache{Edition: edition, Model: model, Branches: branches}
+	route_vector_lock.Unlock()
+
+	return branches, nil
+}
+
+// 通过语义相似度判断走哪个分支，最高分需要超过阈值，并且与第二名的差距超过间隔
+func (r *RouteRunner) getNextActionsBySemantic(s *andflow.Session, action *andflow.ActionModel, prop map[string]string, content string) ([]*andflow.ActionModel, error) {
+	var nas []*andflow.ActionModel
+
+	route_score := prop["route_score"]         //相似度阈值
+	route_margin := prop["route_margin"]       //与第二名的最小差距
+	route_param_key := prop["route_param_key"] //分支得分
+
+	threshold := 0.75
+	if len(route_score) > 0 {
+		threshold, _ = utils.StringToFloat64(route_score)
+	}
+	var margin float64
+	if len(route_margin) > 0 {
+		margin, _ = utils.StringToFloat64(route_margin)
+	}
+
+	embedding, params, err := r.getEmbedding(s, prop, "route_embed_")
+	if err != nil {
+		return nil, err
+	}
+
+	branches, err := r.getBranchVectors(s, action, embedding, params)
+	if err != nil {
+		return nil, err
+	}
+	if len(branches) == 0 {
+		return nas, nil
+	}
+	vectors, err := embedding.Embed(params, []string{content})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) == 0 {
+		return nil, fmt.Errorf("向量返回空")
+	}
+
+	scores := make([]*routeBranchScore, 0)
+	for _, b := range branches {
+		score := &routeBranchScore{TargetId: b.TargetId, Name: b.Name}
+		for _, v := range b.Vectors {
+			sim := utils.CosineSimilarity(vectors[0], v)
+			if sim > score.Score {
+				score.Score = sim
+			}
+		}
+		scores = append(scores, score)
+	}
+
+	sort.SliceStable(scores, func(i, j int) bool {
+		return scores[i].Score > scores[j].Score
+	})
+
+	best := scores[0]
+	var second float64
+	if len(scores) > 1 {
+		second = scores[1].Score
+	}
+
+	matched := best.Score >= threshold && best.Score-second >= margin
+
+	if len(route_param_key) == 0 {
+		route_param_key = "route_scores_" + action.Id
+	}
+	s.SetParam(route_param_key, map[string]interface{}{"matched": matched, "branch": best.Name, "score": best.Score, "second_score": second, "scores": scores})
+
+	if !matched {
+		s.AddLog_action_info(action.Name, action.Title, fmt.Sprintf("语义路由未匹配: %s %.4f/%.4f", strings.Trim(best.Name, " "), best.Score, second))
+		return nas, nil
+	}
+
+	nas = append(nas, s.GetFlow().GetAction(best.TargetId))
+	return nas, nil
+}
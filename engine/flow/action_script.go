@@ -0,0 +1,296 @@
+package flow
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/meta"
+	"github.com/zone-7/chatflow_engine/engine/utils"
+)
+
+// 默认执行时间限制，毫秒
+const script_timeout = 5000
+
+// 执行时间限制的最大值，毫秒
+const script_max_timeout = 60000
+
+// 每个脚本可以使用的内存，按照原生函数和内置函数生成的数据累计
+const script_max_memory = 32 * 1024 * 1024
+
+// 最大调用栈深度
+const script_max_call_stack = 1024
+
+func init() {
+	andflow.RegistActionRunner("script", &ScriptRunner{})
+}
+
+// 编译后的脚本，按流程版本缓存
+type scriptProgram struct {
+	Edition int64
+	Hash    string
+	Program *goja.Program
+}
+
+var script_programs = make(map[string]*scriptProgram)
+var script_programs_lock sync.Mutex
+
+// 获取编译后的脚本，流程版本或者脚本内容变化时重新编译
+func getScriptProgram(key string, edition int64, source string) (*goja.Program, error) {
+	hash := md5.Sum([]byte(source))
+	source_hash := hex.EncodeToString(hash[:])
+
+	script_programs_lock.Lock()
+	cached, ok := script_programs[key]
+	script_programs_lock.Unlock()
+	if ok && cached.Edition == edition && cached.Hash == source_hash {
+		return cached.Program, nil
+	}
+
+	//脚本放在函数中执行，可以使用 return 返回结果
+	program, err := goja.Compile(key, "(function(){\n"+source+"\n})()", false)
+	if err != nil {
+		return nil, err
+	}
+
+	script_programs_lock.Lock()
+	script_programs[key] = &scriptProgram{Edition: edition, Hash: source_hash, Program: program}
+	script_programs_lock.Unlock()
+
+	return program, nil
+}
+
+// JavaScript 脚本，可以读写参数、访问历史消息、发起HTTP请求、检索知识库和调用其他流程
+type ScriptRunner struct {
+	BaseRunner
+}
+
+func (r *ScriptRunner) Properties() []andflow.Prop {
+	return []andflow.Prop{}
+}
+func (r *ScriptRunner) Execute(s *andflow.Session, param *andflow.ActionParam, state *andflow.ActionStateModel) (andflow.Result, error) {
+	action := s.GetFlow().GetAction(param.ActionId)
+	chatSession := r.getChatSession(s)
+
+	log.Printf("script begin: %v", time.Now())
+	defer log.Printf("script end: %v", time.Now())
+
+	//脚本不做模版替换，其他参数单独替换
+	ps := s.GetParamMap()
+	source := r.getActionParam(s, action, "script", nil)
+	param_key := r.getActionParam(s, action, "param_key", ps) //返回结果
+	timeout := r.getActionParam(s, action, "timeout", ps)     //执行时间限制，毫秒
+
+	if len(source) == 0 {
+		return andflow.RESULT_FAILURE, errors.New("脚本不能为空")
+	}
+
+	timeout_ms := script_timeout
+	if len(timeout) > 0 {
+		if v, err := utils.StringToInt(timeout); err == nil && v > 0 {
+			timeout_ms = v
+		}
+	}
+	if timeout_ms > script_max_timeout {
+		timeout_ms = script_max_timeout
+	}
+
+	flow_space := meta.FLOW_SPACE_PRODUCT
+	if chatSession.Info != nil && len(chatSession.Info.FlowSpace) > 0 {
+		flow_space = chatSession.Info.FlowSpace
+	}
+	key := fmt.Sprintf("%s/%s/%s", flow_space, chatSession.Chatflow.Code, action.Id)
+	program, err := getScriptProgram(key, chatSession.Chatflow.Edition, source)
+	if err != nil {
+		s.AddLog_action_error(action.Name, action.Title, "脚本编译错误: "+err.Error())
+		return andflow.RESULT_FAILURE, errors.New("脚本编译错误: " + err.Error())
+	}
+
+	deadline := time.Now().Add(time.Duration(timeout_ms) * time.Millisecond)
+
+	vm := goja.New()
+	vm.SetMaxCallStackSize(script_max_call_stack)
+	budget := &scriptBudget{limit: script_max_memory}
+	limitScriptBuiltins(vm, budget)
+	r.setScriptLibrary(vm, s, action, state, chatSession, deadline, budget)
+
+	//超时和停止时中断执行
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if time.Now().After(deadline) {
+					vm.Interrupt(fmt.Sprintf("执行超过%d毫秒", timeout_ms))
+					return
+				}
+				if s.Operation.GetCmd() == andflow.CMD_STOP {
+					vm.Interrupt("用户停止")
+					return
+				}
+			}
+		}
+	}()
+
+	result, err := vm.RunProgram(program)
+	if err != nil {
+		if interrupted, ok := err.(*goja.InterruptedError); ok {
+			err = fmt.Errorf("脚本中断: %v", interrupted.Value())
+		} else {
+			err = errors.New("脚本执行错误: " + err.Error())
+		}
+		s.AddLog_action_error(action.Name, action.Title, err.Error())
+		return andflow.RESULT_FAILURE, err
+	}
+
+	//返回 false 时不执行后续节点
+	if result != nil && !goja.IsUndefined(result) && !goja.IsNull(result) {
+		value := result.Export()
+		if b, ok := value.(bool); ok && !b {
+			return andflow.RESULT_REJECT, nil
+		}
+		if len(param_key) > 0 {
+			if err := budget.charge(scriptValueSize(value, 0)); err != nil {
+				s.AddLog_action_error(action.Name, action.Title, err.Error())
+				return andflow.RESULT_FAILURE, err
+			}
+			s.SetParam(param_key, value)
+		}
+	}
+
+	return andflow.RESULT_SUCCESS, nil
+}
+
+// 脚本内存预算，goja 不能统计虚拟机占用的内存，
+// 只累计原生函数返回的外部数据、会大量生成数据的内置函数和写出的参数
+type scriptBudget struct {
+	limit int64
+	used  int64
+}
+
+func (b *scriptBudget) charge(size int64) error {
+	b.used += size
+	if b.used > b.limit {
+		return fmt.Errorf("脚本使用内存超过%dMB", b.limit/1024/1024)
+	}
+	return nil
+}
+
+// 超过预算时中断执行，脚本不能捕获后继续运行
+func (b *scriptBudget) use(vm *goja.Runtime, size int64) {
+	if err := b.charge(size); err != nil {
+		vm.Interrupt(err.Error())
+		panic(vm.NewGoError(err))
+	}
+}
+
+// 估算导出值占用的内存
+func scriptValueSize(value interface{}, depth int) int64 {
+	if depth > 32 {
+		return 0
+	}
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(len(v))
+	case map[string]interface{}:
+		var size int64
+		for k, item := range v {
+			size += int64(len(k)) + scriptValueSize(item, depth+1)
+		}
+		return size
+	case []map[string]interface{}:
+		var size int64
+		for _, item := range v {
+			size += scriptValueSize(item, depth+1)
+		}
+		return size
+	case []interface{}:
+		var size int64
+		for _, item := range v {
+			size += scriptValueSize(item, depth+1)
+		}
+		return size
+	default:
+		return 8
+	}
+}
+
+// 两个数相乘，超过 max 时返回 max
+func scriptSizeMul(a int64, b int64, max int64) int64 {
+	if a <= 0 || b <= 0 {
+		return 0
+	}
+	if a > max/b {
+		return max
+	}
+	return a * b
+}
+
+// 重复、填充和拼接可以用很短的脚本生成大量数据，执行前按照结果大小计入预算
+func limitScriptBuiltins(vm *goja.Runtime, budget *scriptBudget) {
+	over := budget.limit + 1
+	length := func(v goja.Value) int64 {
+		if v == nil || goja.IsUndefined(v) || goja.IsNull(v) {
+			return 0
+		}
+		return v.ToObject(vm).Get("length").ToInteger()
+	}
+	arg := func(call goja.FunctionCall, i int) int64 {
+		v := call.Argument(i)
+		if goja.IsUndefined(v) {
+			return 0
+		}
+		return v.ToInteger()
+	}
+	wrap := func(proto *goja.Object, name string, size func(call goja.FunctionCall) int64) {
+		fn, ok := goja.AssertFunction(proto.Get(name))
+		if !ok {
+			return
+		}
+		proto.DefineDataProperty(name, vm.ToValue(func(call goja.FunctionCall) goja.Value {
+			budget.use(vm, size(call))
+			res, err := fn(call.This, call.Arguments...)
+			if err != nil {
+				panic(err)
+			}
+			return res
+		}), goja.FLAG_TRUE, goja.FLAG_TRUE, goja.FLAG_FALSE)
+	}
+
+	string_proto := vm.Get("String").ToObject(vm).Get("prototype").ToObject(vm)
+	wrap(string_proto, "repeat", func(call goja.FunctionCall) int64 {
+		return scriptSizeMul(length(call.This), arg(call, 0), over)
+	})
+	pad := func(call goja.FunctionCall) int64 {
+		return scriptSizeMul(arg(call, 0)-length(call.This), 1, over)
+	}
+	wrap(string_proto, "padStart", pad)
+	wrap(string_proto, "padEnd", pad)
+
+	array_proto := vm.Get("Array").ToObject(vm).Get("prototype").ToObject(vm)
+	wrap(array_proto, "fill", func(call goja.FunctionCall) int64 {
+		return scriptSizeMul(length(call.This), 16, over)
+	})
+	wrap(array_proto, "join", func(call goja.FunctionCall) int64 {
+		sep := int64(1)
+		if v := call.Argument(0); !goja.IsUndefined(v) {
+			sep = int64(len(v.String()))
+		}
+		return scriptSizeMul(length(call.This), sep, over)
+	})
+}
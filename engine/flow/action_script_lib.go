@@ -0,0 +1,421 @@
+package flow
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/gofrs/uuid"
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/manager"
+	"github.com/zone-7/chatflow_engine/engine/meta"
+	"github.com/zone-7/chatflow_engine/engine/utils"
+)
+
+// 脚本中可以使用的函数：
+// params.get(name)、params.set(name, value)、params.all()
+// chat.message()、chat.send(content, format)、chat.waiting(content)、chat.history(count)
+// fetch(url, {method, headers, body, timeout})，按照请求限制访问
+// fetch、knowledge.search、flow.call 不能超过脚本的执行时间，生成的数据计入脚本内存预算
+// crypto.md5、crypto.sha1、crypto.sha256、crypto.hmacSha256(key, data)、crypto.base64Encode、crypto.base64Decode、crypto.uuid
+// date.now()、date.format(ms, layout)、date.parse(text)
+// knowledge.search(knowledge_id, text, limit, score)
+// flow.call(flow_code, content, params)
+// next(name...) 指定后续节点、log(...) 记录日志
+// 兼容节点脚本的 sendMessage、sendWaiting、getMessage
+func (r *ScriptRunner) setScriptLibrary(vm *goja.Runtime, s *andflow.Session, action *andflow.ActionModel, state *andflow.ActionStateModel, chatSession *ChatSession, deadline time.Time, budget *scriptBudget) {
+	throw := func(err error) {
+		panic(vm.NewGoError(err))
+	}
+	str := func(v goja.Value) string {
+		if v == nil || goja.IsUndefined(v) || goja.IsNull(v) {
+			return ""
+		}
+		return v.String()
+	}
+
+	//参数
+	params := vm.NewObject()
+	params.Set("get", func(name string) interface{} {
+		return s.GetParam(name)
+	})
+	params.Set("set", func(name string, value goja.Value) {
+		if value == nil || goja.IsUndefined(value) {
+			s.SetParam(name, nil)
+			return
+		}
+		v := value.Export()
+		budget.use(vm, scriptValueSize(v, 0))
+		s.SetParam(name, v)
+	})
+	params.Set("all", func() map[string]interface{} {
+		return s.GetParamMap()
+	})
+	vm.Set("params", params)
+
+	//对话
+	send := func(content goja.Value, format goja.Value) {
+		text := str(content)
+		budget.use(vm, int64(len(text)))
+		f := str(format)
+		if len(f) == 0 {
+			f = meta.CHAT_MESSAGE_FORMAT_TEXT
+		}
+		chatSession.Response(meta.ChatFlowMessage{MessageType: meta.CHAT_MESSAGE_TYPE_MESSAGE, Content: text, Format: f, Finish: "yes"}, true)
+	}
+	waiting := func(content goja.Value) {
+		chatSession.ResponseWaitting(str(content))
+	}
+	message := func() string {
+		return chatSession.GetCurrentRequestMessagesContent(1)
+	}
+
+	chat := vm.NewObject()
+	chat.Set("message", message)
+	chat.Set("send", send)
+	chat.Set("waiting", waiting)
+	chat.Set("history", func(count goja.Value) []map[string]interface{} {
+		n := 10
+		if c := count; c != nil && !goja.IsUndefined(c) && !goja.IsNull(c) {
+			n = int(c.ToInteger())
+		}
+		list := make([]map[string]interface{}, 0)
+		for _, m := range chatSession.GetMessages() {
+			if m.MessageType != meta.CHAT_MESSAGE_TYPE_MESSAGE || (m.Role != meta.CHAT_MESSAGE_ROLE_USER && m.Role != meta.CHAT_MESSAGE_ROLE_ASSISTANT) {
+				continue
+			}
+			list = append(list, map[string]interface{}{"role": m.Role, "content": m.Content, "time": m.SendTime})
+		}
+		if n >= 0 && len(list) > n {
+			list = list[len(list)-n:]
+		}
+		budget.use(vm, scriptValueSize(list, 0))
+		return list
+	})
+	vm.Set("chat", chat)
+
+	vm.Set("sendMessage", send)
+	vm.Set("sendWaiting", waiting)
+	vm.Set("getMessage", message)
+
+	//HTTP请求
+	vm.Set("fetch", func(address string, options goja.Value) map[string]interface{} {
+		opts := make(map[string]interface{})
+		if options != nil && !goja.IsUndefined(options) && !goja.IsNull(options) {
+			if m, ok := options.Export().(map[string]interface{}); ok {
+				opts = m
+			}
+		}
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+		res, err := waitScriptCall(s, deadline, func() (interface{}, error) {
+			return r.scriptFetch(ctx, chatSession.Opt.HttpPolicy, address, opts)
+		}, cancel)
+		if err != nil {
+			throw(err)
+		}
+		budget.use(vm, scriptValueSize(res, 0))
+		return res.(map[string]interface{})
+	})
+
+	//加密
+	hexHash := func(data []byte) string {
+		return hex.EncodeToString(data)
+	}
+	cryptoObj := vm.NewObject()
+	cryptoObj.Set("md5", func(text string) string {
+		h := md5.Sum([]byte(text))
+		return hexHash(h[:])
+	})
+	cryptoObj.Set("sha1", func(text string) string {
+		h := sha1.Sum([]byte(text))
+		return hexHash(h[:])
+	})
+	cryptoObj.Set("sha256", func(text string) string {
+		h := sha256.Sum256([]byte(text))
+		return hexHash(h[:])
+	})
+	cryptoObj.Set("hmacSha256", func(key string, text string) string {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(text))
+		return hexHash(mac.Sum(nil))
+	})
+	cryptoObj.Set("base64Encode", func(text string) string {
+		budget.use(vm, int64(base64.StdEncoding.EncodedLen(len(text))))
+		return base64.StdEncoding.EncodeToString([]byte(text))
+	})
+	cryptoObj.Set("base64Decode", func(text string) string {
+		data, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			throw(err)
+		}
+		budget.use(vm, int64(len(data)))
+		return string(data)
+	})
+	cryptoObj.Set("uuid", func() string {
+		uid, _ := uuid.NewV4()
+		return uid.String()
+	})
+	vm.Set("crypto", cryptoObj)
+
+	//日期，按照会话时区
+	loc := chatSession.GetLocation()
+	date := vm.NewObject()
+	date.Set("now", func() int64 {
+		return time.Now().UnixNano() / 1e6
+	})
+	date.Set("format", func(ms goja.Value, layout goja.Value) string {
+		t := time.Now().In(loc)
+		if ms != nil && !goja.IsUndefined(ms) && !goja.IsNull(ms) {
+			t = time.UnixMilli(ms.ToInteger()).In(loc)
+		}
+		l := str(layout)
+		if len(l) == 0 {
+			l = DATETIME_FORMAT
+		}
+		return t.Format(l)
+	})
+	date.Set("parse", func(text string) interface{} {
+		for _, layout := range []string{DATETIME_FORMAT, DATE_FORMAT, time.RFC3339} {
+			if t, err := time.ParseInLocation(layout, text, loc); err == nil {
+				return t.UnixMilli()
+			}
+		}
+		t, err := utils.NormalizeDateTime(text, time.Now().In(loc))
+		if err != nil {
+			return nil
+		}
+		return t.UnixMilli()
+	})
+	vm.Set("date", date)
+
+	//知识库
+	knowledge := vm.NewObject()
+	knowledge.Set("search", func(knowledge_id string, text string, limit goja.Value, score goja.Value) []interface{} {
+		lm := 1
+		if limit != nil && !goja.IsUndefined(limit) && !goja.IsNull(limit) {
+			lm = int(limit.ToInteger())
+		}
+		sc := 0.0
+		if score != nil && !goja.IsUndefined(score) && !goja.IsNull(score) {
+			sc = score.ToFloat()
+		}
+		res, err := waitScriptCall(s, deadline, func() (interface{}, error) {
+			kno := manager.KnowledgeManager{Opt: chatSession.Opt}
+			results, err := kno.SearchKnowledge(knowledge_id, text, sc, lm)
+			if err != nil {
+				return nil, err
+			}
+			list := make([]interface{}, 0)
+			for _, result := range results {
+				list = append(list, map[string]interface{}{"id": result.Id, "score": result.Score, "payload": result.Payload})
+			}
+			return list, nil
+		}, nil)
+		if err != nil {
+			throw(err)
+		}
+		budget.use(vm, scriptValueSize(res, 0))
+		return res.([]interface{})
+	})
+	vm.Set("knowledge", knowledge)
+
+	//调用工具流程
+	flowObj := vm.NewObject()
+	flowObj.Set("call", func(flow_code string, content goja.Value, ps goja.Value) map[string]interface{} {
+		flow_params := make(map[string]string)
+		if ps != nil && !goja.IsUndefined(ps) && !goja.IsNull(ps) {
+			if m, ok := ps.Export().(map[string]interface{}); ok {
+				for k, v := range m {
+					if text, ok := v.(string); ok {
+						flow_params[k] = text
+					} else {
+						data, _ := json.Marshal(v)
+						flow_params[k] = string(data)
+					}
+				}
+			}
+		}
+		text := str(content)
+		abort := make(chan struct{})
+		res, err := waitScriptCall(s, deadline, func() (interface{}, error) {
+			output, subflowParams, err := invokeWidgetFlowAbortable(chatSession, s.Operation.GetRequestId(), flow_code, text, flow_params, abort)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"output": output, "params": subflowParams}, nil
+		}, func() {
+			close(abort)
+		})
+		if err != nil {
+			throw(err)
+		}
+		budget.use(vm, scriptValueSize(res, 0))
+		return res.(map[string]interface{})
+	})
+	vm.Set("flow", flowObj)
+
+	//后续节点
+	vm.Set("next", func(call goja.FunctionCall) goja.Value {
+		for _, arg := range call.Arguments {
+			for _, act := range r.getNextActionsByName(s, action, str(arg)) {
+				state.NextActionIds = append(state.NextActionIds, act.Id)
+			}
+		}
+		return goja.Undefined()
+	})
+
+	vm.Set("log", func(call goja.FunctionCall) goja.Value {
+		items := make([]string, 0)
+		for _, arg := range call.Arguments {
+			if o, ok := arg.(*goja.Object); ok && o.ClassName() != "Function" {
+				data, err := json.Marshal(o.Export())
+				if err == nil {
+					items = append(items, string(data))
+					continue
+				}
+			}
+			items = append(items, str(arg))
+		}
+		text := strings.Join(items, " ")
+		budget.use(vm, int64(len(text)))
+		s.AddLog_action_info(action.Name, action.Title, text)
+		return goja.Undefined()
+	})
+}
+
+// 调用在后台执行，超过脚本执行时间或者用户停止时不再等待，cancel 用于取消后台的调用
+func waitScriptCall(s *andflow.Session, deadline time.Time, call func() (interface{}, error), cancel func()) (interface{}, error) {
+	type callResult struct {
+		value interface{}
+		err   error
+	}
+	ch := make(chan callResult, 1)
+	go func() {
+		value, err := call()
+		ch <- callResult{value: value, err: err}
+	}()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case res := <-ch:
+			return res.value, res.err
+		case <-timer.C:
+			if cancel != nil {
+				cancel()
+			}
+			return nil, errors.New("调用超过脚本执行时间")
+		case <-ticker.C:
+			if s.Operation.GetCmd() == andflow.CMD_STOP {
+				if cancel != nil {
+					cancel()
+				}
+				return nil, errors.New("用户停止")
+			}
+		}
+	}
+}
+
+// 脚本中的HTTP请求，同步执行，ctx 的截止时间为脚本剩余的执行时间
+// body 为对象时按JSON发送，返回 {status, ok, headers, body, json}
+func (r *ScriptRunner) scriptFetch(ctx context.Context, policy meta.HttpPolicy, address string, opts map[string]interface{}) (map[string]interface{}, error) {
+	method := http.MethodGet
+	headers := make(map[string]string)
+	var body io.Reader
+	timeout := 0
+
+	if opts != nil {
+		if m, ok := opts["method"].(string); ok && len(m) > 0 {
+			method = strings.ToUpper(m)
+		}
+		if hs, ok := opts["headers"].(map[string]interface{}); ok {
+			for k, v := range hs {
+				headers[k] = fmt.Sprintf("%v", v)
+			}
+		}
+		switch b := opts["body"].(type) {
+		case nil:
+		case string:
+			body = strings.NewReader(b)
+		default:
+			data, err := json.Marshal(b)
+			if err != nil {
+				return nil, err
+			}
+			body = strings.NewReader(string(data))
+			if _, ok := headers["Content-Type"]; !ok {
+				headers["Content-Type"] = "application/json"
+			}
+		}
+		if t, ok := opts["timeout"]; ok {
+			timeout, _ = utils.StringToInt(fmt.Sprintf("%v", t))
+		}
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("地址格式错误: %s", address)
+	}
+	err = checkHttpPolicy(policy, u)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newPolicyHttpClient(policy, timeout, "")
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, getHttpMaxBodySize(policy)))
+	if err != nil {
+		return nil, err
+	}
+
+	res_headers := make(map[string]interface{})
+	for k := range resp.Header {
+		res_headers[k] = resp.Header.Get(k)
+	}
+
+	res := map[string]interface{}{
+		"status":  resp.StatusCode,
+		"ok":      resp.StatusCode >= 200 && resp.StatusCode < 300,
+		"headers": res_headers,
+		"body":    string(data),
+	}
+	var obj interface{}
+	if json.Unmarshal(data, &obj) == nil {
+		res["json"] = obj
+	}
+	return res, nil
+}
@@ -0,0 +1,166 @@
+package flow
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/provider"
+	"github.com/zone-7/chatflow_engine/engine/utils"
+)
+
+// 结构化输出，按照JSON Schema让大模型返回JSON，校验不通过时带上错误重新请求
+
+// 最多重试次数
+const structured_output_max_retries = 5
+
+func init() {
+	andflow.RegistActionRunner("structured_output", &StructuredOutputRunner{})
+}
+
+type StructuredOutputRunner struct {
+	BaseRunner
+}
+
+func (r *StructuredOutputRunner) Properties() []andflow.Prop {
+	return []andflow.Prop{}
+}
+func (r *StructuredOutputRunner) Execute(s *andflow.Session, param *andflow.ActionParam, state *andflow.ActionStateModel) (andflow.Result, error) {
+	action := s.GetFlow().GetAction(param.ActionId)
+	chatSession := r.getChatSession(s)
+
+	log.Printf("structured output begin: %v", time.Now())
+	defer log.Printf("structured output end: %v", time.Now())
+
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
+	if err != nil {
+		return andflow.RESULT_FAILURE, err
+	}
+
+	content_source := prop["content_source"] //信息来自参数还是输入
+	content_temp := prop["content_temp"]     //信息来自哪个参数
+
+	req_cos := prop["req_cosplay"]     //任务说明
+	schema_json := prop["schema"]      //JSON Schema
+	json_mode := prop["json_mode"]     //是否使用模型的JSON模式
+	max_retries := prop["max_retries"] //校验失败后重新请求次数
+
+	param_key := prop["param_key"]       //对象保存到参数
+	param_spread := prop["param_spread"] //对象的字段分别保存到参数，默认不保存
+	error_param_key := prop["error_param_key"]
+
+	if len(schema_json) == 0 {
+		return andflow.RESULT_FAILURE, errors.New("JSON Schema不能为空")
+	}
+	var schema interface{}
+	err = json.Unmarshal([]byte(schema_json), &schema)
+	if err != nil {
+		return andflow.RESULT_FAILURE, errors.New("JSON Schema格式错误")
+	}
+
+	chatting, params, err := r.getChatting(s, prop, "")
+	if err != nil {
+		return andflow.RESULT_FAILURE, err
+	}
+	if json_mode != "false" && json_mode != "0" {
+		params["response_format"] = "json_object"
+		params["format"] = "json"
+	}
+
+	retries := 2
+	if len(max_retries) > 0 {
+		retries, _ = utils.StringToInt(max_retries)
+	}
+	if retries < 0 {
+		retries = 0
+	}
+	//每次重试都会调用模型，限制最大次数
+	if retries > structured_output_max_retries {
+		retries = structured_output_max_retries
+	}
+
+	requestContent := ""
+	if content_source == "temp" && len(content_temp) > 0 {
+		requestContent = content_temp
+	} else {
+		requestContent = chatSession.GetCurrentRequestMessagesContent(1)
+		if len(requestContent) == 0 {
+			return andflow.RESULT_REJECT, nil
+		}
+	}
+
+	system_prompt := ""
+	if len(req_cos) > 0 {
+		system_prompt += req_cos + "\n\n"
+	}
+	system_prompt += "请按照以下 JSON Schema 输出JSON，只输出JSON，不要输出其他内容:\n" + schema_json
+
+	messages := []provider.ChatMessage{
+		{Role: provider.MESSAGE_ROLE_SYSTEM, Content: system_prompt},
+		{Role: provider.MESSAGE_ROLE_USER, Content: requestContent},
+	}
+
+	var value interface{}
+	var errs []string
+
+	for i := 0; i <= retries; i++ {
+		if s.Operation.GetCmd() == andflow.CMD_STOP {
+			return andflow.RESULT_FAILURE, errors.New("用户停止")
+		}
+
+		output, err := r.chatText(s, chatting, params, messages)
+		if err != nil {
+			log.Printf("structured output执行异常:%v", err)
+			return andflow.RESULT_FAILURE, err
+		}
+
+		value = nil
+		text := extractJsonText(output)
+		if len(text) == 0 {
+			errs = []string{"没有找到JSON"}
+		} else if err := json.Unmarshal([]byte(text), &value); err != nil {
+			errs = []string{"JSON格式错误: " + err.Error()}
+		} else {
+			errs = utils.ValidateJsonSchema(schema, value)
+		}
+
+		if len(errs) == 0 {
+			break
+		}
+
+		s.AddLog_action_info(action.Name, action.Title, "JSON校验不通过: "+strings.Join(errs, "; "))
+
+		//带上错误信息重新请求
+		messages = append(messages, provider.ChatMessage{Role: provider.MESSAGE_ROLE_ASSISTANT, Content: output})
+		messages = append(messages, provider.ChatMessage{Role: provider.MESSAGE_ROLE_USER, Content: "输出的JSON不符合要求:\n- " + strings.Join(errs, "\n- ") + "\n请修正后重新输出完整的JSON。"})
+	}
+
+	if len(error_param_key) > 0 {
+		s.SetParam(error_param_key, errs)
+	}
+
+	if len(errs) > 0 {
+		return andflow.RESULT_FAILURE, errors.New("JSON校验不通过: " + strings.Join(errs, "; "))
+	}
+
+	if len(param_key) > 0 {
+		s.SetParam(param_key, value)
+	}
+
+	//字段保存到参数，模版中可以直接使用 {{field}}，不覆盖会话的系统参数
+	if param_spread == "true" || param_spread == "1" {
+		if obj, ok := value.(map[string]interface{}); ok {
+			for k, v := range obj {
+				if isChatReservedParam(k) || k == param_key || k == error_param_key {
+					continue
+				}
+				s.SetParam(k, v)
+			}
+		}
+	}
+
+	return andflow.RESULT_SUCCESS, nil
+}
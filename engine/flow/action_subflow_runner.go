@@ -26,7 +26,7 @@ func (r *SubflowRunner) Execute(s *andflow.Session, param *andflow.ActionParam,
 	action := s.GetFlow().GetAction(param.ActionId)
 	chatSession := r.getChatSession(s)
 
-	prop, err := r.getActionParams(action, s.GetParamMap())
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
 
 	if err != nil {
 		return andflow.RESULT_FAILURE, err
@@ -37,7 +37,7 @@ func (r *SubflowRunner) Execute(s *andflow.Session, param *andflow.ActionParam,
 
 	flow_code := prop["flow_code"]
 	response_params := prop["response_params"]
-	flow_params_json := r.getActionParam(action, "flow_params", nil)
+	flow_params_json := r.getActionParam(s, action, "flow_params", nil)
 
 	flow_params := make(map[string]string)
 
@@ -46,7 +46,7 @@ func (r *SubflowRunner) Execute(s *andflow.Session, param *andflow.ActionParam,
 	}
 
 	for k, v := range flow_params {
-		vv, err := replaceTemplate(v, "temp_"+action.Id+"_params_"+k, s.GetParamMap())
+		vv, err := r.renderTemplate(s, v, "temp_"+action.Id+"_params_"+k, s.GetParamMap())
 		if err == nil {
 			flow_params[k] = vv
 		}
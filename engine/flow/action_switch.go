@@ -0,0 +1,134 @@
+package flow
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/manager"
+	"github.com/zone-7/chatflow_engine/engine/utils"
+)
+
+// 条件匹配方式
+const (
+	SWITCH_MATCH_FIRST = "first" //执行第一个满足条件的分支
+	SWITCH_MATCH_ALL   = "all"   //执行所有满足条件的分支
+)
+
+func init() {
+	andflow.RegistActionRunner("switch", &SwitchRunner{})
+}
+
+// 解析后的表达式
+var switch_expressions sync.Map
+
+// 获取解析后的表达式，相同的表达式只解析一次
+func getSwitchExpression(text string) (*utils.Expression, error) {
+	if e, ok := switch_expressions.Load(text); ok {
+		return e.(*utils.Expression), nil
+	}
+	e, err := utils.ParseExpression(text)
+	if err != nil {
+		return nil, err
+	}
+	switch_expressions.Store(text, e)
+	return e, nil
+}
+
+// 按条件选择分支，条件写在连线的关键词中，例如 score > 0.8 && len(hits) > 0
+// 条件计算出错时记录日志并当作不满足，没有满足条件的分支时执行默认分支
+type SwitchRunner struct {
+	BaseRunner
+}
+
+func (r *SwitchRunner) Properties() []andflow.Prop {
+	return []andflow.Prop{}
+}
+
+func (r *SwitchRunner) Execute(s *andflow.Session, param *andflow.ActionParam, state *andflow.ActionStateModel) (andflow.Result, error) {
+	action := s.GetFlow().GetAction(param.ActionId)
+
+	log.Printf("switch begin: %v", time.Now())
+	defer log.Printf("switch end: %v", time.Now())
+
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
+	if err != nil {
+		return andflow.RESULT_FAILURE, err
+	}
+
+	match := prop["match"]         //匹配方式 first、all
+	param_key := prop["param_key"] //执行的分支名称
+
+	if len(match) == 0 {
+		match = SWITCH_MATCH_FIRST
+	}
+
+	params := s.GetParamMap()
+
+	matched := make([]string, 0)
+	names := make([]string, 0)
+	defaults := make([]*andflow.LinkModel, 0)
+
+	for _, link := range s.GetFlow().GetLinkBySourceId(action.Id) {
+		if link.Active == "false" {
+			continue
+		}
+		if manager.IsSwitchDefaultLink(link) {
+			defaults = append(defaults, link)
+			continue
+		}
+		if match == SWITCH_MATCH_FIRST && len(matched) > 0 {
+			continue
+		}
+
+		expression := strings.TrimSpace(link.Keywords)
+		e, err := getSwitchExpression(expression)
+		if err != nil {
+			s.AddLog_action_error(action.Name, action.Title, fmt.Sprintf("条件格式错误 %s: %v", expression, err))
+			continue
+		}
+		ok, err := e.EvalBool(params)
+		if err != nil {
+			s.AddLog_action_error(action.Name, action.Title, fmt.Sprintf("条件计算错误 %s: %v", expression, err))
+			continue
+		}
+		if ok {
+			matched = append(matched, link.TargetId)
+			names = append(names, r.getLinkName(s, link))
+		}
+	}
+
+	if len(matched) == 0 {
+		for _, link := range defaults {
+			matched = append(matched, link.TargetId)
+			names = append(names, r.getLinkName(s, link))
+		}
+	}
+
+	if len(param_key) > 0 {
+		s.SetParam(param_key, names)
+	}
+
+	if len(matched) == 0 {
+		s.AddLog_action_info(action.Name, action.Title, "没有满足条件的分支")
+		//指定一个不存在的节点，不执行任何后续节点
+		matched = append(matched, "")
+	}
+	state.NextActionIds = matched
+
+	return andflow.RESULT_SUCCESS, nil
+}
+
+// 分支名称，没有连线名称时使用节点标题
+func (r *SwitchRunner) getLinkName(s *andflow.Session, link *andflow.LinkModel) string {
+	if len(strings.Trim(link.Name, " ")) > 0 {
+		return link.Name
+	}
+	if target := s.GetFlow().GetAction(link.TargetId); target != nil {
+		return target.Title
+	}
+	return link.TargetId
+}
@@ -0,0 +1,210 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/manager"
+	"github.com/zone-7/chatflow_engine/engine/meta"
+)
+
+// 用户回复时的处理方式
+const (
+	WAIT_REPLY_CONTINUE = "continue" //提前结束等待，继续执行
+	WAIT_REPLY_CANCEL   = "cancel"   //取消等待，不执行后续节点
+	WAIT_REPLY_IGNORE   = "ignore"   //继续等待到恢复时间
+)
+
+func init() {
+	andflow.RegistActionRunner("wait", &WaitRunner{})
+}
+
+// 等待一段时间后继续执行，等待期间会话挂起，恢复时间保存在工作空间中，重启后仍然有效
+// 到期后由等待调度任务发送事件消息恢复执行，连线名称 timeout、reply、cancel 对应不同的结束方式，
+// 没有对应名称的连线时执行其他没有命名的连线
+type WaitRunner struct {
+	BaseRunner
+}
+
+func (r *WaitRunner) Properties() []andflow.Prop {
+	return []andflow.Prop{}
+}
+
+func (r *WaitRunner) Execute(s *andflow.Session, param *andflow.ActionParam, state *andflow.ActionStateModel) (andflow.Result, error) {
+	action := s.GetFlow().GetAction(param.ActionId)
+
+	log.Printf("wait begin: %v", time.Now())
+	defer log.Printf("wait end: %v", time.Now())
+
+	prop, err := r.getActionParams(s, action, s.GetParamMap())
+	if err != nil {
+		return andflow.RESULT_FAILURE, err
+	}
+
+	duration := prop["duration"]   //等待时间，秒数或者 30m、1h30m 格式
+	until := prop["until"]         //恢复时间，格式 2006-01-02 15:04:05，按会话时区，优先于等待时间
+	on_reply := prop["on_reply"]   //用户回复时 continue 提前继续、cancel 取消等待、ignore 继续等待
+	param_key := prop["param_key"] //等待结果
+
+	if len(on_reply) == 0 {
+		on_reply = WAIT_REPLY_CONTINUE
+	}
+	if len(param_key) == 0 {
+		param_key = action.Id
+	}
+
+	chatSession := r.getChatSession(s)
+	if chatSession == nil || chatSession.Info == nil {
+		return andflow.RESULT_FAILURE, errors.New("等待节点只能在对话会话中执行")
+	}
+
+	wait_manager := manager.NewWaitScheduleManager(chatSession.Opt)
+	wait_id := manager.GetWaitScheduleId(chatSession.Info.Id, action.Id)
+
+	schedule, err := wait_manager.LoadWaitSchedule(wait_id)
+	if err != nil {
+		return andflow.RESULT_FAILURE, err
+	}
+
+	//开始等待
+	if schedule == nil {
+		resume_time, err := r.getResumeTime(chatSession, duration, until)
+		if err != nil {
+			return andflow.RESULT_FAILURE, err
+		}
+
+		now := time.Now()
+		schedule = &meta.WaitSchedule{
+			Id:         wait_id,
+			UserId:     chatSession.Info.UserId,
+			FlowCode:   chatSession.Info.FlowCode,
+			FlowSpace:  chatSession.Info.FlowSpace,
+			SessionId:  chatSession.Info.Id,
+			ActionId:   action.Id,
+			ResumeTime: resume_time.UnixNano() / 1e6,
+			CreateTime: now.UnixNano() / 1e6,
+		}
+
+		//恢复时间已经过去，直接继续
+		if !resume_time.After(now) {
+			return r.finish(s, action, state, wait_manager, schedule, meta.WAIT_END_TIMEOUT, param_key)
+		}
+
+		err = wait_manager.StoreWaitSchedule(schedule)
+		if err != nil {
+			return andflow.RESULT_FAILURE, err
+		}
+		s.AddLog_action_info(action.Name, action.Title, "等待到 "+resume_time.In(chatSession.GetLocation()).Format("2006-01-02 15:04:05"))
+
+		return andflow.RESULT_REJECT, nil
+	}
+
+	//等待到期
+	if event, event_wait_id := r.getEvent(s); event {
+		if event_wait_id != wait_id {
+			//其他等待节点的事件
+			return andflow.RESULT_REJECT, nil
+		}
+		return r.finish(s, action, state, wait_manager, schedule, meta.WAIT_END_TIMEOUT, param_key)
+	}
+
+	//用户回复
+	switch on_reply {
+	case WAIT_REPLY_IGNORE:
+		return andflow.RESULT_REJECT, nil
+	case WAIT_REPLY_CANCEL:
+		return r.finish(s, action, state, wait_manager, schedule, meta.WAIT_END_CANCEL, param_key)
+	case WAIT_REPLY_CONTINUE:
+		return r.finish(s, action, state, wait_manager, schedule, meta.WAIT_END_REPLY, param_key)
+	default:
+		return andflow.RESULT_FAILURE, errors.New("不支持的回复处理方式: " + on_reply)
+	}
+}
+
+// 计算恢复时间
+func (r *WaitRunner) getResumeTime(chatSession *ChatSession, duration string, until string) (time.Time, error) {
+	now := time.Now()
+
+	until = strings.Trim(until, " ")
+	if len(until) > 0 {
+		t, err := time.ParseInLocation("2006-01-02 15:04:05", until, chatSession.GetLocation())
+		if err != nil {
+			return now, errors.New("恢复时间格式错误: " + until)
+		}
+		return t, nil
+	}
+
+	duration = strings.Trim(duration, " ")
+	if len(duration) == 0 {
+		return now, errors.New("没有设置等待时间")
+	}
+	if seconds, err := strconv.ParseFloat(duration, 64); err == nil {
+		return now.Add(time.Duration(seconds * float64(time.Second))), nil
+	}
+	d, err := time.ParseDuration(duration)
+	if err != nil {
+		return now, errors.New("等待时间格式错误: " + duration)
+	}
+	return now.Add(d), nil
+}
+
+// 当前消息是否等待到期事件，返回事件对应的等待ID
+func (r *WaitRunner) getEvent(s *andflow.Session) (bool, string) {
+	msg, ok := s.GetParam("message").(map[string]interface{})
+	if !ok {
+		return false, ""
+	}
+	if msg["message_type"] != meta.CHAT_MESSAGE_TYPE_EVENT {
+		return false, ""
+	}
+	params, _ := msg["params"].(map[string]interface{})
+	if params == nil || params["wait_id"] == nil {
+		return true, ""
+	}
+	return true, fmt.Sprintf("%v", params["wait_id"])
+}
+
+// 结束等待，删除调度并按结束方式选择后续节点
+func (r *WaitRunner) finish(s *andflow.Session, action *andflow.ActionModel, state *andflow.ActionStateModel, wait_manager manager.WaitScheduleManager, schedule *meta.WaitSchedule, end string, param_key string) (andflow.Result, error) {
+	err := wait_manager.RemoveWaitSchedule(schedule.Id)
+	if err != nil {
+		return andflow.RESULT_FAILURE, err
+	}
+
+	now := time.Now().UnixNano() / 1e6
+	s.SetParam(param_key, map[string]interface{}{
+		"end":         end,
+		"resume_time": schedule.ResumeTime,
+		"waited":      now - schedule.CreateTime,
+	})
+	s.AddLog_action_info(action.Name, action.Title, "等待结束: "+end)
+
+	ids := make([]string, 0)
+	others := make([]string, 0)
+	for _, link := range s.GetFlow().GetLinkBySourceId(action.Id) {
+		name := strings.ToLower(strings.Trim(link.Name, " "))
+		switch name {
+		case end:
+			ids = append(ids, link.TargetId)
+		case meta.WAIT_END_TIMEOUT, meta.WAIT_END_REPLY, meta.WAIT_END_CANCEL:
+		default:
+			others = append(others, link.TargetId)
+		}
+	}
+	//取消时只执行 cancel 连线
+	if len(ids) == 0 && end != meta.WAIT_END_CANCEL {
+		ids = others
+	}
+	if len(ids) == 0 {
+		//指定一个不存在的节点，不执行任何后续节点
+		ids = append(ids, "")
+	}
+	state.NextActionIds = ids
+
+	return andflow.RESULT_SUCCESS, nil
+}
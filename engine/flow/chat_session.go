@@ -20,6 +20,14 @@ import (
 )
 
 var Sessions = make(map[string]*ChatSession)
+var sessions_lock sync.RWMutex
+
+// 每次执行时由会话设置的参数，节点不能批量覆盖
+var chat_reserved_params = []string{"message", "timezone", "year", "month", "weekday", "datetime", "date"}
+
+func isChatReservedParam(name string) bool {
+	return utils.StringsIndex(chat_reserved_params, name) >= 0
+}
 
 func init() {
 	go monitSession()
@@ -43,6 +51,10 @@ type ChatSession struct {
 	input_chan chan meta.ChatFlowMessage
 	store_chan chan string
 	wg         sync.WaitGroup
+
+	runtime_lock sync.RWMutex //运行时读写锁，节点并发执行时使用
+	message_lock sync.RWMutex //消息记录读写锁
+	output_lock  sync.Mutex   //输出锁，保证回调函数按顺序收到消息
 }
 
 // 打开会话
@@ -106,18 +118,31 @@ func OpenChatSession(opt meta.Option, message meta.ChatFlowMessage, rsesponseMes
 
 // 获取会话
 func GetChatSession(session_id string) *ChatSession {
-
+	sessions_lock.RLock()
 	chatSession := Sessions[session_id]
+	sessions_lock.RUnlock()
 
 	return chatSession
 
 }
 
+// 当前所有会话
+func listChatSessions() []*ChatSession {
+	sessions_lock.RLock()
+	defer sessions_lock.RUnlock()
+
+	list := make([]*ChatSession, 0, len(Sessions))
+	for _, s := range Sessions {
+		list = append(list, s)
+	}
+	return list
+}
+
 // 通过流程编码关闭所有会话
 func CloseChatSessionsByFlowCode(flow_code string) {
 
 	session_ids_del := make([]string, 0)
-	for _, s := range Sessions {
+	for _, s := range listChatSessions() {
 		if s.Info.FlowCode == flow_code {
 			session_ids_del = append(session_ids_del, s.Info.Id)
 		}
@@ -129,18 +154,21 @@ func CloseChatSessionsByFlowCode(flow_code string) {
 
 // 关闭会话
 func CloseChatSession(session_id string) {
+	sessions_lock.Lock()
 	session := Sessions[session_id]
+	delete(Sessions, session_id)
+	sessions_lock.Unlock()
+
 	if session == nil {
 		return
 	}
 
+	clearForkStates(session_id)
 	session.Close()
-
-	delete(Sessions, session_id)
 }
 
 func CloseAllChatSession(user_id, flow_code string) {
-	for _, session := range Sessions {
+	for _, session := range listChatSessions() {
 		if session.Info.UserId == user_id && session.Info.FlowCode == flow_code {
 			CloseChatSession(session.Info.Id)
 		}
@@ -197,7 +225,9 @@ func CreateChatSession(opt meta.Option, info *meta.ChatSessionInfo, history []*m
 
 	session.ActiveTime = time.Now() //活动时间
 
+	sessions_lock.Lock()
 	Sessions[info.Id] = session
+	sessions_lock.Unlock()
 
 	return session, nil
 }
@@ -259,27 +289,49 @@ func (s *ChatSession) Reset() {
 		return
 	}
 
+	if s.Runtime != nil {
+		clearForkStates(s.Runtime.Id)
+	}
 	s.Runtime = andflow.CreateRuntime(s.Chatflow.FlowModel, nil)
 	s.Runtime.Id = s.Info.Id         //ID 直接复制给运行时状态ID
 	s.Runtime.UserId = s.Info.UserId //用户ID复制给运行时状态的用户ID
 
+	s.message_lock.Lock()
 	s.Messages = make([]*meta.ChatFlowMessage, 0)
+	s.message_lock.Unlock()
+	s.Info.Memory = nil
+
+	//挂起的等待节点已经不存在，删除等待调度
+	wait_manager := manager.NewWaitScheduleManager(s.Opt)
+	err := wait_manager.RemoveSessionWaitSchedules(s.Info.Id)
+	if err != nil {
+		fmt.Printf("wait schedule: %v\n", err)
+	}
 }
 
 // 获取历史消息
 func (s *ChatSession) GetMessages() []*meta.ChatFlowMessage {
-	return s.Messages
+	s.message_lock.RLock()
+	defer s.message_lock.RUnlock()
+
+	if s.Messages == nil {
+		return nil
+	}
+	return append([]*meta.ChatFlowMessage{}, s.Messages...)
 }
 
 // 添加到历史消息
 func (s *ChatSession) AddMessage(msg *meta.ChatFlowMessage) {
 	if msg.MessageType == meta.CHAT_MESSAGE_TYPE_MESSAGE {
+		s.message_lock.Lock()
+		defer s.message_lock.Unlock()
+
 		if s.Messages == nil {
 			s.Messages = make([]*meta.ChatFlowMessage, 0)
 		}
 
 		//合并消息
-		msgs := s.GetMessage(msg.MessageId)
+		msgs := s.getMessage(msg.MessageId)
 		var oldmsg *meta.ChatFlowMessage
 		if msgs != nil && len(msgs) > 0 {
 			oldmsg = msgs[0]
@@ -296,6 +348,13 @@ func (s *ChatSession) AddMessage(msg *meta.ChatFlowMessage) {
 
 // 根据消息ID获取消息
 func (s *ChatSession) GetMessage(messageId string) []*meta.ChatFlowMessage {
+	s.message_lock.RLock()
+	defer s.message_lock.RUnlock()
+
+	return s.getMessage(messageId)
+}
+
+func (s *ChatSession) getMessage(messageId string) []*meta.ChatFlowMessage {
 	msgs := make([]*meta.ChatFlowMessage, 0)
 	if s.Messages != nil {
 		for _, msg := range s.Messages {
@@ -316,6 +375,9 @@ func (s *ChatSession) GetCurrentRequestMessages(history_count int) []*meta.ChatF
 		history_count = 1
 	}
 
+	s.message_lock.RLock()
+	defer s.message_lock.RUnlock()
+
 	msgs := make([]*meta.ChatFlowMessage, 0)
 	if s.Messages != nil {
 		for i := len(s.Messages) - 1; i >= 0 && len(msgs) < history_count; i-- {
@@ -345,6 +407,9 @@ func (s *ChatSession) GetCurrentRequestMessagesContent(history_count int) string
 func (s *ChatSession) GetCurrentResponseMessages() []*meta.ChatFlowMessage {
 	requestId := s.Runtime.RequestId
 
+	s.message_lock.RLock()
+	defer s.message_lock.RUnlock()
+
 	msgs := make([]*meta.ChatFlowMessage, 0)
 	if s.Messages != nil {
 		for _, msg := range s.Messages {
@@ -357,6 +422,18 @@ func (s *ChatSession) GetCurrentResponseMessages() []*meta.ChatFlowMessage {
 	return msgs
 }
 
+// 会话时区，没有配置或者配置错误时使用服务器时区
+func (s *ChatSession) GetLocation() *time.Location {
+	if len(s.Opt.TimeZone) > 0 {
+		loc, err := time.LoadLocation(s.Opt.TimeZone)
+		if err == nil {
+			return loc
+		}
+		log.Printf("时区配置错误:%s", s.Opt.TimeZone)
+	}
+	return time.Local
+}
+
 // 执行
 func (s *ChatSession) Execute(msg meta.ChatFlowMessage) error {
 	defer func() {
@@ -385,6 +462,30 @@ func (s *ChatSession) Execute(msg meta.ChatFlowMessage) error {
 	if s.Running {
 		return nil
 	}
+
+	//事件消息只用于恢复挂起的节点，没有挂起的节点时忽略
+	event := msg.MessageType == meta.CHAT_MESSAGE_TYPE_EVENT
+	if event && len(s.Runtime.RunningActions) == 0 {
+		return nil
+	}
+	//指定了节点的事件只恢复该节点，节点已经不在挂起时忽略，避免从头执行流程
+	resume_ids := make([]string, 0)
+	if event && len(msg.Params["action_id"]) > 0 {
+		action_id := msg.Params["action_id"]
+		found := false
+		s.runtime_lock.RLock()
+		for _, param := range s.Runtime.RunningActions {
+			if param.ActionId == action_id {
+				found = true
+				break
+			}
+		}
+		s.runtime_lock.RUnlock()
+		if !found {
+			return nil
+		}
+		resume_ids = append(resume_ids, action_id)
+	}
 	// 正在执行标志
 	s.Running = true
 
@@ -408,7 +509,9 @@ func (s *ChatSession) Execute(msg meta.ChatFlowMessage) error {
 	msg.FlowCode = s.Info.FlowCode
 	msg.SessionId = s.Info.Id
 	msg.UserId = s.Info.UserId
-	msg.MessageType = meta.CHAT_MESSAGE_TYPE_MESSAGE
+	if !event {
+		msg.MessageType = meta.CHAT_MESSAGE_TYPE_MESSAGE
+	}
 
 	if len(msg.Mid) == 0 {
 		uid, _ := uuid.NewV4()
@@ -429,6 +532,9 @@ func (s *ChatSession) Execute(msg meta.ChatFlowMessage) error {
 
 	if len(msg.Role) == 0 {
 		msg.Role = meta.CHAT_MESSAGE_ROLE_USER
+		if event {
+			msg.Role = meta.CHAT_MESSAGE_ROLE_SYSTEM
+		}
 	}
 
 	if msg.SendTime == 0 {
@@ -436,7 +542,7 @@ func (s *ChatSession) Execute(msg meta.ChatFlowMessage) error {
 	}
 
 	// 会话标题，默认为发送内容的前面几个字
-	if len(s.Info.Title) == 0 {
+	if len(s.Info.Title) == 0 && !event {
 		s.Info.Title = msg.Content
 		s.Info.Title = strings.ReplaceAll(s.Info.Title, "\n", "")
 		//截取Title长度，避免过长，用循环的方式可以防止中文乱吗
@@ -447,19 +553,21 @@ func (s *ChatSession) Execute(msg meta.ChatFlowMessage) error {
 		}
 	}
 
-	// 添加到消息记录列表
-	s.AddMessage(&msg)
+	// 添加到消息记录列表，事件消息不记录
+	if !event {
+		s.AddMessage(&msg)
+	}
 
 	// 激活时间
 	s.ActiveTime = time.Now()
 
-	// runtime operation
-	runtimeOperation := &andflow.CommonRuntimeOperation{}
-	runtimeOperation.Init(s.Runtime)
+	// runtime operation，节点可能并发执行，读写运行时需要加锁
+	runtimeOperation := newSafeRuntimeOperation(s.Runtime, &s.runtime_lock)
 
 	runtimeOperation.OnChangeFunc = func(event string, runtime *andflow.RuntimeModel) {
 		s.ResponseRuntime()
 	}
+	runtimeOperation.SetResumeActions(resume_ids)
 	//设置流程的运行时，requestid = 本次消息的requestId
 	runtimeOperation.SetRequestId(msg.RequestId)
 
@@ -478,15 +586,17 @@ func (s *ChatSession) Execute(msg meta.ChatFlowMessage) error {
 		}
 	}
 
-	//时间相关参数
-	runtimeOperation.SetParam("year", time.Now().Year())
-	runtimeOperation.SetParam("month", time.Now().Month())
-	runtimeOperation.SetParam("weekday", time.Now().Weekday())
-	runtimeOperation.SetParam("datetime", time.Now().Format("2006-01-02 15:04:05"))
-	runtimeOperation.SetParam("date", time.Now().Format("2006-01-02"))
+	//时间相关参数，按照会话时区
+	now := time.Now().In(s.GetLocation())
+	runtimeOperation.SetParam("timezone", now.Location().String())
+	runtimeOperation.SetParam("year", now.Year())
+	runtimeOperation.SetParam("month", now.Month())
+	runtimeOperation.SetParam("weekday", now.Weekday())
+	runtimeOperation.SetParam("datetime", now.Format("2006-01-02 15:04:05"))
+	runtimeOperation.SetParam("date", now.Format("2006-01-02"))
 
 	//对话用户提交的参数，覆盖
-	if msg.Params != nil {
+	if msg.Params != nil && !event {
 		for k, v := range msg.Params {
 			runtimeOperation.SetParam(k, v)
 		}
@@ -495,8 +605,8 @@ func (s *ChatSession) Execute(msg meta.ChatFlowMessage) error {
 	//flowrouter
 	flowRouter := &andflow.CommonFlowRouter{}
 
-	//flowrunner
-	flowRunner := &andflow.CommonFlowRunner{}
+	//flowrunner，并行分支中的节点使用分支参数
+	flowRunner := &chatFlowRunner{}
 
 	flowRunner.SetActionScriptFunc(func(rts *goja.Runtime, session *andflow.Session, param *andflow.ActionParam, state *andflow.ActionStateModel) {
 		rts.Set("sendWaiting", func(call goja.FunctionCall) goja.Value {
@@ -613,7 +723,7 @@ func (s *ChatSession) Execute(msg meta.ChatFlowMessage) error {
 	})
 
 	// 发送“请等待”提示信息
-	if len(strings.Trim(s.Chatflow.WaittingText, " ")) > 0 {
+	if len(strings.Trim(s.Chatflow.WaittingText, " ")) > 0 && !event {
 		s.ResponseWaitting(s.Chatflow.WaittingText)
 	}
 
@@ -635,6 +745,13 @@ func (s *ChatSession) Execute(msg meta.ChatFlowMessage) error {
 	// 执行andflow
 	andflow.Execute(runtimeOperation, flowRouter, flowRunner, timeout)
 
+	// 清除已经汇聚的并行分支状态，没有挂起的节点时全部清除
+	if len(s.Runtime.RunningActions) == 0 {
+		clearForkStates(s.Runtime.Id)
+	} else {
+		releaseForkStates(s.Runtime.Id)
+	}
+
 	// complete
 	s.ResponseComplete()
 
@@ -724,7 +841,9 @@ func (s *ChatSession) Response(msg meta.ChatFlowMessage, add_history bool) {
 
 	//输出到回调函数
 	if s.OutputFunc != nil {
+		s.output_lock.Lock()
 		s.OutputFunc(msg)
+		s.output_lock.Unlock()
 	}
 
 }
@@ -736,6 +855,10 @@ func (s *ChatSession) ResponseRuntime() {
 	if runtime == nil {
 		return
 	}
+
+	//读取运行时需要加锁，节点可能正在并发修改，消息在释放锁之后发送
+	s.runtime_lock.RLock()
+	request_id := runtime.RequestId
 	runtime_res := andflow.RuntimeModel{}
 	runtime_res.ActionStates = runtime.ActionStates
 	runtime_res.LinkStates = runtime.LinkStates
@@ -745,17 +868,40 @@ func (s *ChatSession) ResponseRuntime() {
 	runtime_res.Id = runtime.Id
 	runtime_res.IsError = runtime.IsError
 	runtime_res.IsRunning = runtime.IsRunning
-	runtime_res.Param = runtime.Param
-	runtime_res.Logs = runtime.Logs
+
+	//设计空间给设计者发送完整运行状态，不进入历史消息
+	var detail []byte
+	if s.isDevelop() {
+		runtime_res.Param = runtime.Param
+		runtime_res.Logs = runtime.Logs
+
+		rt, err := json.Marshal(runtime_res)
+		if err == nil {
+			detail = rt
+		}
+	}
+
+	//客户端只能看到脱敏后的参数
+	runtime_res.Param = s.getRedactedParams(runtime.Param)
+	runtime_res.Logs = nil
+	if s.isShowRuntimeLogs() {
+		runtime_res.Logs = runtime.Logs
+	}
 
 	rt, err := json.Marshal(runtime_res)
+	s.runtime_lock.RUnlock()
+
+	if detail != nil {
+		s.Response(meta.ChatFlowMessage{RequestId: request_id, MessageType: meta.CHAT_MESSAGE_TYPE_RUNTIME_DETAIL, Role: meta.CHAT_MESSAGE_ROLE_SYSTEM, Content: string(detail), Finish: "yes"}, false)
+	}
+
 	if err != nil {
 		return
 	}
 
 	content := string(rt)
 
-	s.Response(meta.ChatFlowMessage{RequestId: runtime.RequestId, MessageType: meta.CHAT_MESSAGE_TYPE_RUNTIME, Role: meta.CHAT_MESSAGE_ROLE_SYSTEM, Content: content, Finish: "yes"}, true)
+	s.Response(meta.ChatFlowMessage{RequestId: request_id, MessageType: meta.CHAT_MESSAGE_TYPE_RUNTIME, Role: meta.CHAT_MESSAGE_ROLE_SYSTEM, Content: content, Finish: "yes"}, true)
 }
 
 func (s *ChatSession) ResponseSession() {
@@ -850,14 +996,82 @@ func (s *ChatSession) store_process() {
 func (s *ChatSession) StoreSession() {
 	session_manager := manager.ChatSessionInfoManager{Opt: s.Opt}
 	session_manager.StoreSessionInfo(s.Info)
-	session_manager.StoreSessionMessages(s.Info, s.Messages)
+	session_manager.StoreSessionMessages(s.Info, s.GetMessages())
+
+	s.runtime_lock.RLock()
+	defer s.runtime_lock.RUnlock()
 	session_manager.StoreSessionRuntime(s.Info, s.Runtime)
 }
 
+// 启动后台会话清理，内存中正在使用的会话不清理
+func StartSessionJanitor(opt meta.Option, interval time.Duration) {
+	manager.StartRetentionJanitor(opt, interval, func(user_id string, flow_code string, session_id string) bool {
+		return GetChatSession(session_id) != nil
+	})
+}
+
+// 停止后台会话清理
+func StopSessionJanitor(opt meta.Option) {
+	manager.StopRetentionJanitor(opt)
+}
+
+// 启动等待调度，到期后恢复挂起的会话
+// 会话不在内存中时重新打开，消息通过 output 输出，例如推送给用户
+func StartWaitScheduler(opt meta.Option, interval time.Duration, output func(message meta.ChatFlowMessage)) {
+	manager.StartWaitScheduler(opt, interval, func(schedule *meta.WaitSchedule) bool {
+		return resumeWaitSession(opt, schedule, output)
+	})
+}
+
+// 停止等待调度
+func StopWaitScheduler(opt meta.Option) {
+	manager.StopWaitScheduler(opt)
+}
+
+// 发送等待到期事件，会话正在执行时返回false，下次再触发
+func resumeWaitSession(opt meta.Option, schedule *meta.WaitSchedule, output func(message meta.ChatFlowMessage)) bool {
+	msg := meta.ChatFlowMessage{
+		FlowSpace:   schedule.FlowSpace,
+		FlowCode:    schedule.FlowCode,
+		SessionId:   schedule.SessionId,
+		UserId:      schedule.UserId,
+		MessageType: meta.CHAT_MESSAGE_TYPE_EVENT,
+		Role:        meta.CHAT_MESSAGE_ROLE_SYSTEM,
+		Content:     meta.WAIT_EVENT_TIMEOUT,
+		Params:      map[string]string{"wait_id": schedule.Id, "action_id": schedule.ActionId},
+	}
+
+	chatSession := GetChatSession(schedule.SessionId)
+	if chatSession == nil {
+		//会话已经删除
+		session_manager := manager.NewChatSessionInfoManager(opt)
+		info, _ := session_manager.LoadSessionInfo(schedule.UserId, schedule.FlowCode, schedule.SessionId)
+		if info == nil {
+			wait_manager := manager.NewWaitScheduleManager(opt)
+			wait_manager.RemoveWaitSchedule(schedule.Id)
+			return true
+		}
+
+		var err error
+		chatSession, err = OpenChatSession(opt, msg, []string{meta.CHAT_MESSAGE_TYPE_MESSAGE, meta.CHAT_MESSAGE_TYPE_ERROR}, output)
+		if err != nil {
+			fmt.Printf("wait schedule %s: %v\n", schedule.Id, err)
+			return false
+		}
+	}
+
+	if chatSession.Running {
+		return false
+	}
+
+	chatSession.ChatAsync(msg)
+	return true
+}
+
 // 监控会话是否过期，过期就关闭
 func monitSession() {
 	for {
-		for _, s := range Sessions {
+		for _, s := range listChatSessions() {
 			if s == nil || s.Chatflow.SessionTimeout == 0 {
 				continue
 			}
@@ -0,0 +1,96 @@
+package flow
+
+import (
+	"log"
+	"time"
+
+	"github.com/zone-7/chatflow_engine/engine/meta"
+)
+
+// 历史消息选项
+type ChatHistoryOption struct {
+	Count     int   //最多携带的消息条数，小于0不限制
+	Time      int64 //只携带最近多少秒内的消息，0不限制
+	MaxTokens int   //携带的消息和摘要的token预算，0不限制
+	Summary   bool  //没有携带的更早消息总结为摘要
+}
+
+// 根据原来的摘要和新的消息生成新的摘要
+type ChatSummarizer func(summary string, messages []*meta.ChatFlowMessage) (string, error)
+
+// 获取历史消息，不包含当前请求的消息，返回更早消息的摘要和最近的消息
+// 开启摘要时，超出条数、时间或token预算的更早消息由 summarizer 总结，摘要保存在会话信息中
+func (s *ChatSession) GetHistory(opt ChatHistoryOption, summarizer ChatSummarizer) (string, []*meta.ChatFlowMessage) {
+	requestId := ""
+	if s.Runtime != nil {
+		requestId = s.Runtime.RequestId
+	}
+
+	summary := ""
+	var until int64
+	count := 0
+	if opt.Summary && s.Info.Memory != nil {
+		summary = s.Info.Memory.Summary
+		until = s.Info.Memory.Until
+		count = s.Info.Memory.Count
+	}
+
+	//已经总结过的消息不再携带
+	candidates := make([]*meta.ChatFlowMessage, 0)
+	for _, m := range s.GetMessages() {
+		if m.MessageType != meta.CHAT_MESSAGE_TYPE_MESSAGE || (len(requestId) > 0 && m.RequestId == requestId) {
+			continue
+		}
+		if m.Role != meta.CHAT_MESSAGE_ROLE_USER && m.Role != meta.CHAT_MESSAGE_ROLE_ASSISTANT {
+			continue
+		}
+		if len(m.Content) == 0 && len(m.Images) == 0 {
+			continue
+		}
+		if opt.Summary && until > 0 && m.SendTime <= until {
+			continue
+		}
+		candidates = append(candidates, m)
+	}
+
+	//从最新的消息开始选择
+	now := time.Now().UnixNano() / 1e6 //毫秒
+	tokens := estimateTokens(summary)
+	start := len(candidates)
+	for i := len(candidates) - 1; i >= 0; i-- {
+		m := candidates[i]
+		if opt.Count >= 0 && len(candidates)-i > opt.Count {
+			break
+		}
+		if opt.Time > 0 && now-m.SendTime > opt.Time*1000 {
+			break
+		}
+		t := estimateTokens(m.Content)
+		if opt.MaxTokens > 0 && tokens+t > opt.MaxTokens {
+			break
+		}
+		tokens += t
+		start = i
+	}
+
+	recent := candidates[start:]
+	older := candidates[:start]
+
+	if opt.Summary && len(older) > 0 && summarizer != nil {
+		newSummary, err := summarizer(summary, older)
+		if err != nil {
+			//没有总结的消息下次重新总结
+			log.Printf("总结历史消息异常:%v", err)
+		} else if len(newSummary) > 0 {
+			summary = newSummary
+			s.Info.Memory = &meta.ChatSessionMemory{
+				Summary:    summary,
+				Until:      older[len(older)-1].SendTime,
+				Count:      count + len(older),
+				UpdateTime: now,
+			}
+		}
+	}
+
+	return summary, recent
+}
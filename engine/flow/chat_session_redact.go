@@ -0,0 +1,169 @@
+package flow
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"sync"
+
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/manager"
+	"github.com/zone-7/chatflow_engine/engine/meta"
+	"github.com/zone-7/chatflow_engine/engine/utils"
+)
+
+const REDACT_VALUE = "******"
+
+// 默认需要脱敏的参数名
+var redact_default_reg = regexp.MustCompile(`(?i)(password|passwd|secret|token|api_?key|dsn|datasource|credential)`)
+
+// 流程配置的脱敏规则，每个表达式只编译一次，格式错误的保存为nil
+var redact_regs sync.Map
+
+func getRedactRegexp(pattern string) *regexp.Regexp {
+	if reg, ok := redact_regs.Load(pattern); ok {
+		return reg.(*regexp.Regexp)
+	}
+	reg, err := regexp.Compile(pattern)
+	if err != nil {
+		reg = nil
+	}
+	redact_regs.Store(pattern, reg)
+	return reg
+}
+
+// 参数是否需要脱敏
+func (s *ChatSession) isRedactParam(name string) bool {
+	if s.Chatflow == nil {
+		return false
+	}
+
+	//流程参数中的密码
+	for _, p := range s.Chatflow.Params {
+		if p.Name == name && p.InputType == "password" {
+			return true
+		}
+	}
+
+	if redact_default_reg.MatchString(name) {
+		return true
+	}
+
+	if s.Chatflow.Redact != nil {
+		for _, pattern := range s.Chatflow.Redact.Patterns {
+			reg := getRedactRegexp(pattern)
+			if reg != nil && reg.MatchString(name) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// 客户端可见的运行参数
+func (s *ChatSession) getRedactedParams(params []*andflow.RuntimeParamModel) []*andflow.RuntimeParamModel {
+	res := make([]*andflow.RuntimeParamModel, 0)
+
+	var allowlist []string
+	if s.Chatflow != nil && s.Chatflow.Redact != nil {
+		allowlist = s.Chatflow.Redact.Allowlist
+	}
+
+	for _, p := range params {
+		if p == nil {
+			continue
+		}
+
+		//配置了可见列表时，只有列表中的参数可见
+		if len(allowlist) > 0 && utils.StringsIndex(allowlist, p.Name) < 0 {
+			continue
+		}
+
+		if s.isRedactParam(p.Name) {
+			res = append(res, &andflow.RuntimeParamModel{Name: p.Name, Value: REDACT_VALUE})
+			continue
+		}
+
+		//对象和数组中的字段，例如请求结果中的请求头
+		if value, changed := s.redactValue(p.Value); changed {
+			res = append(res, &andflow.RuntimeParamModel{Name: p.Name, Value: value})
+			continue
+		}
+
+		res = append(res, p)
+	}
+
+	return res
+}
+
+// 对象中的字段是否需要脱敏
+func (s *ChatSession) isRedactKey(name string) bool {
+	return s.isRedactParam(name) || manager.IsCredentialHeader(name)
+}
+
+// 递归脱敏对象和数组中的字段，没有需要脱敏的字段时返回原值
+func (s *ChatSession) redactValue(value interface{}) (interface{}, bool) {
+	if value == nil {
+		return value, false
+	}
+	switch reflect.Indirect(reflect.ValueOf(value)).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct:
+	default:
+		return value, false
+	}
+
+	//转换为通用的对象和数组再检查
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value, false
+	}
+	var obj interface{}
+	err = json.Unmarshal(data, &obj)
+	if err != nil {
+		return value, false
+	}
+
+	changed := s.redactObject(obj, 0)
+	if !changed {
+		return value, false
+	}
+	return obj, true
+}
+
+func (s *ChatSession) redactObject(obj interface{}, depth int) bool {
+	if depth > 32 {
+		return false
+	}
+	changed := false
+	switch v := obj.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			if s.isRedactKey(k) {
+				v[k] = REDACT_VALUE
+				changed = true
+				continue
+			}
+			if s.redactObject(item, depth+1) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if s.redactObject(item, depth+1) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// 客户端是否可见运行日志
+func (s *ChatSession) isShowRuntimeLogs() bool {
+	return s.Chatflow != nil && s.Chatflow.Redact != nil && s.Chatflow.Redact.ShowLogs
+}
+
+// 是否是设计空间的会话
+func (s *ChatSession) isDevelop() bool {
+	return s.Info != nil && s.Info.FlowSpace == meta.FLOW_SPACE_DEVELOP
+}
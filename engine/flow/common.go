@@ -3,6 +3,8 @@ package flow
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 
@@ -10,6 +12,7 @@ import (
 	"log"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/beego/beego/orm"
@@ -113,6 +116,75 @@ func dbQuery(driverName string, alias string, datasource string, query string, a
 	return list, nil
 }
 
+// 只读查询，在只读事务中执行并且总是回滚，用于由模型决定参数的查询
+func dbQueryReadOnly(driverName string, alias string, datasource string, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	db, err := orm.GetDB(alias)
+	if err != nil || db == nil {
+		dbRegist(driverName, alias, datasource)
+		db, err = orm.GetDB(alias)
+	}
+	if err != nil || db == nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	//sqlite 驱动不支持只读事务，使用 query_only 禁止写入
+	if driverName == "sqlite3" || driverName == "sqlite" {
+		_, err = conn.ExecContext(ctx, "PRAGMA query_only = ON")
+		if err != nil {
+			return nil, err
+		}
+		defer conn.ExecContext(ctx, "PRAGMA query_only = OFF")
+	}
+
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		err = rows.Scan(pointers...)
+		if err != nil {
+			return nil, err
+		}
+		item := make(map[string]interface{})
+		for i, col := range columns {
+			if b, ok := values[i].([]byte); ok {
+				item[col] = string(b)
+			} else {
+				item[col] = values[i]
+			}
+		}
+		list = append(list, item)
+	}
+
+	return list, rows.Err()
+}
+
 // 获取词语列表，逗号或者换行分隔
 func getWords(keywords string) []string {
 	words := make([]string, 0)
@@ -135,23 +207,56 @@ func getWords(keywords string) []string {
 	return words
 }
 
+// 文本中是否出现词语，英文词语按整个单词匹配，no 不匹配 know、nothing
+func containsWord(text string, word string) bool {
+	if len(word) == 0 {
+		return false
+	}
+	if !isAsciiWord(word) {
+		return strings.Contains(text, word)
+	}
+	text = strings.ToLower(text)
+	word = strings.ToLower(word)
+	for start := 0; ; {
+		idx := strings.Index(text[start:], word)
+		if idx < 0 {
+			return false
+		}
+		idx += start
+		end := idx + len(word)
+		if (idx == 0 || !isAsciiWordByte(text[idx-1])) && (end == len(text) || !isAsciiWordByte(text[end])) {
+			return true
+		}
+		start = idx + 1
+	}
+}
+
+func isAsciiWord(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isAsciiWordByte(s[i]) && s[i] != ' ' && s[i] != '\'' && s[i] != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAsciiWordByte(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_'
+}
+
 func unescapeHTML(s any) template.HTML {
 
 	var str string
 	if v, ok := s.(string); ok {
 		str = v
 	} else {
-		switch s.(type) {
-		case string:
+		switch v := s.(type) {
 		case float32:
+			str = strconv.FormatFloat(float64(v), 'f', -1, 32)
 		case float64:
-		case int:
-		case int16:
-		case int32:
-		case int64:
-		case bool:
+			str = strconv.FormatFloat(v, 'f', -1, 64)
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, bool:
 			str = fmt.Sprintf("%v", s)
-			break
 		default:
 			data, err := json.Marshal(s)
 			if err == nil {
@@ -167,6 +272,26 @@ func unescapeHTML(s any) template.HTML {
 
 // 模版替换
 func replaceTemplate(temp string, name string, params map[string]any) (string, error) {
+	return replaceTemplateFuncs(temp, name, params, nil)
+}
+
+// 模版替换，可以使用自定义函数，例如 {{secret "name"}}
+func replaceTemplateFuncs(temp string, name string, params map[string]any, funcs template.FuncMap) (string, error) {
+	return executeTemplate(temp, name, params, funcs, "unescapeHTML")
+}
+
+// 模版替换，参数值按HTML转义，用于生成HTML内容，例如邮件正文
+func replaceTemplateEscaped(temp string, name string, params map[string]any, funcs template.FuncMap) (string, error) {
+	return executeTemplate(temp, name, params, funcs, "templateValue")
+}
+
+// 参数值转换为文本，不标记为HTML，由模版转义
+func templateValue(s any) string {
+	return string(unescapeHTML(s))
+}
+
+// value_func 是输出参数值的函数，unescapeHTML 原样输出，templateValue 转义输出
+func executeTemplate(temp string, name string, params map[string]any, funcs template.FuncMap, value_func string) (string, error) {
 	if strings.Index(temp, "{{") < 0 || strings.Index(temp, "}}") < 0 {
 		return temp, nil
 	}
@@ -184,11 +309,14 @@ func replaceTemplate(temp string, name string, params map[string]any) (string, e
 		key = strings.Trim(key, " ")
 
 		newkey := key
-		if strings.Index(key, "$.") != 0 && strings.Index(key, ".") != 0 {
+		if _, ok := funcs[strings.Split(key, " ")[0]]; ok {
+			//函数调用
+			newkey = "(" + key + ")"
+		} else if strings.Index(key, "$.") != 0 && strings.Index(key, ".") != 0 {
 			newkey = "." + key
 		}
 
-		need_replaces[str] = "{{ unescapeHTML " + newkey + "}}"
+		need_replaces[str] = "{{ " + value_func + " " + newkey + "}}"
 
 	}
 	for o, n := range need_replaces {
@@ -196,7 +324,11 @@ func replaceTemplate(temp string, name string, params map[string]any) (string, e
 	}
 
 	//解析模板
-	t, err := template.New(name).Funcs(template.FuncMap{"unescapeHTML": unescapeHTML}).Parse(temp)
+	funcMap := template.FuncMap{"unescapeHTML": unescapeHTML, "templateValue": templateValue}
+	for k, f := range funcs {
+		funcMap[k] = f
+	}
+	t, err := template.New(name).Funcs(funcMap).Parse(temp)
 
 	if err != nil {
 		return temp, err
@@ -210,3 +342,45 @@ func replaceTemplate(temp string, name string, params map[string]any) (string, e
 
 	return string(b.Bytes()), err
 }
+
+// 从文本中提取第一个合法的JSON对象或数组，括号需要配对，忽略字符串中的括号
+func extractJsonText(text string) string {
+	for start := 0; start < len(text); start++ {
+		if text[start] != '{' && text[start] != '[' {
+			continue
+		}
+
+		depth := 0
+		in_string := false
+		escaped := false
+		for i := start; i < len(text); i++ {
+			c := text[i]
+			if in_string {
+				if escaped {
+					escaped = false
+				} else if c == '\\' {
+					escaped = true
+				} else if c == '"' {
+					in_string = false
+				}
+				continue
+			}
+
+			if c == '"' {
+				in_string = true
+			} else if c == '{' || c == '[' {
+				depth++
+			} else if c == '}' || c == ']' {
+				depth--
+				if depth == 0 {
+					candidate := text[start : i+1]
+					if json.Valid([]byte(candidate)) {
+						return candidate
+					}
+					break
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,303 @@
+package flow
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/utils"
+)
+
+// 一次并行执行的状态，fork 节点创建，join 节点汇聚
+// 分支中写入的参数先保存在分支中，汇聚时按照冲突规则合并到流程参数
+type forkState struct {
+	lock sync.Mutex
+
+	ForkId   string
+	JoinId   string
+	Branches []string                          //分支名称，按连线顺序
+	Targets  map[string]string                 //分支第一个节点 -> 分支名称
+	Actions  map[string]string                 //分支中已经执行的节点 -> 分支名称
+	Writes   map[string]map[string]interface{} //分支名称 -> 分支写入的参数
+	Arrived  []string                          //到达汇聚节点的分支，按到达顺序
+	Started  time.Time
+	Timeout  time.Duration        //分支超时时间，0不限制
+	Begins   map[string]time.Time //分支第一个节点开始执行的时间
+	TimedOut []string             //超时的分支，按超时顺序
+	Done     bool
+
+	cancel chan struct{}
+}
+
+// 运行时ID -> 正在执行的并行状态，后创建的在后面
+var fork_states = make(map[string][]*forkState)
+var fork_states_lock sync.Mutex
+
+// 登记并行状态，同一个 fork 节点再次执行时替换之前的状态
+func addForkState(runtime_id string, st *forkState) {
+	fork_states_lock.Lock()
+	defer fork_states_lock.Unlock()
+
+	list := make([]*forkState, 0)
+	for _, old := range fork_states[runtime_id] {
+		if old.ForkId == st.ForkId {
+			old.finish()
+			continue
+		}
+		list = append(list, old)
+	}
+	fork_states[runtime_id] = append(list, st)
+}
+
+// 会话重置或者关闭后清除所有并行状态
+func clearForkStates(runtime_id string) {
+	fork_states_lock.Lock()
+	defer fork_states_lock.Unlock()
+
+	for _, st := range fork_states[runtime_id] {
+		st.finish()
+	}
+	delete(fork_states, runtime_id)
+}
+
+// 清除已经汇聚的并行状态，还没有汇聚的保留到下次执行，分支中挂起的节点恢复后继续使用
+func releaseForkStates(runtime_id string) {
+	fork_states_lock.Lock()
+	defer fork_states_lock.Unlock()
+
+	list := make([]*forkState, 0)
+	for _, st := range fork_states[runtime_id] {
+		st.lock.Lock()
+		done := st.Done
+		st.lock.Unlock()
+		if !done {
+			list = append(list, st)
+		}
+	}
+	if len(list) == 0 {
+		delete(fork_states, runtime_id)
+		return
+	}
+	fork_states[runtime_id] = list
+}
+
+// 分支到汇聚节点的连线通过后记录分支到达
+func arriveForkJoin(runtime_id string, source_id string, join_id string) {
+	fork_states_lock.Lock()
+	list := append([]*forkState{}, fork_states[runtime_id]...)
+	fork_states_lock.Unlock()
+
+	for i := len(list) - 1; i >= 0; i-- {
+		st := list[i]
+		if st.JoinId != join_id {
+			continue
+		}
+
+		st.lock.Lock()
+		branch, ok := "", false
+		if source_id == st.ForkId {
+			branch, ok = st.Targets[join_id]
+		} else {
+			branch, ok = st.Actions[source_id]
+		}
+		if ok {
+			st.arriveLocked(branch)
+		}
+		st.lock.Unlock()
+
+		if ok {
+			return
+		}
+	}
+}
+
+// 查找汇聚节点对应的并行状态
+func getJoinForkState(runtime_id string, join_id string) *forkState {
+	fork_states_lock.Lock()
+	defer fork_states_lock.Unlock()
+
+	list := fork_states[runtime_id]
+	for i := len(list) - 1; i >= 0; i-- {
+		if list[i].JoinId == join_id {
+			return list[i]
+		}
+	}
+	return nil
+}
+
+// 查找节点所在的分支，从 fork 节点出发或者上一个节点在分支中
+func getForkBranch(runtime_id string, action_id string, pre_action_id string, record bool) (*forkState, string) {
+	fork_states_lock.Lock()
+	list := append([]*forkState{}, fork_states[runtime_id]...)
+	fork_states_lock.Unlock()
+
+	for i := len(list) - 1; i >= 0; i-- {
+		st := list[i]
+		if action_id == st.JoinId {
+			return nil, ""
+		}
+
+		st.lock.Lock()
+		branch, ok := "", false
+		if pre_action_id == st.ForkId {
+			branch, ok = st.Targets[action_id]
+		} else {
+			branch, ok = st.Actions[pre_action_id]
+		}
+		if ok && record {
+			st.Actions[action_id] = branch
+			if _, started := st.Begins[branch]; !started && pre_action_id == st.ForkId {
+				st.Begins[branch] = time.Now()
+			}
+		}
+		st.lock.Unlock()
+
+		if ok {
+			return st, branch
+		}
+	}
+	return nil, ""
+}
+
+// 记录分支到达，已经超时的分支不再计入
+func (st *forkState) arriveLocked(branch string) {
+	if utils.StringsIndex(st.TimedOut, branch) >= 0 || utils.StringsIndex(st.Arrived, branch) >= 0 {
+		return
+	}
+	st.Arrived = append(st.Arrived, branch)
+}
+
+// 标记超时的分支，分支从第一个节点开始执行时计时，还没有开始的从 fork 节点执行时计时
+func (st *forkState) checkTimeoutLocked(now time.Time) {
+	if st.Timeout <= 0 {
+		return
+	}
+	for _, b := range st.Branches {
+		if utils.StringsIndex(st.Arrived, b) >= 0 || utils.StringsIndex(st.TimedOut, b) >= 0 {
+			continue
+		}
+		begin, ok := st.Begins[b]
+		if !ok {
+			begin = st.Started
+		}
+		if now.Sub(begin) >= st.Timeout {
+			st.TimedOut = append(st.TimedOut, b)
+		}
+	}
+}
+
+// 所有分支都已经到达或者超时
+func (st *forkState) settledLocked() bool {
+	return len(st.Arrived)+len(st.TimedOut) >= len(st.Branches)
+}
+
+// 结束并行状态，停止超时计时
+func (st *forkState) finish() {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	st.finishLocked()
+}
+
+func (st *forkState) finishLocked() {
+	if st.Done {
+		return
+	}
+	st.Done = true
+	close(st.cancel)
+}
+
+func (st *forkState) setParam(branch string, key string, val interface{}) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	writes := st.Writes[branch]
+	if writes == nil {
+		writes = make(map[string]interface{})
+		st.Writes[branch] = writes
+	}
+	writes[key] = val
+}
+
+func (st *forkState) getParam(branch string, key string) (interface{}, bool) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	val, ok := st.Writes[branch][key]
+	return val, ok
+}
+
+func (st *forkState) getParamMap(branch string) map[string]interface{} {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	res := make(map[string]interface{})
+	for k, v := range st.Writes[branch] {
+		res[k] = v
+	}
+	return res
+}
+
+// 分支中的运行时操作，参数读写使用分支参数，其他操作使用流程运行时
+type forkBranchOperation struct {
+	andflow.RuntimeOperation
+	fork   *forkState
+	branch string
+}
+
+func (o *forkBranchOperation) SetParam(key string, val interface{}) {
+	o.fork.setParam(o.branch, key, val)
+}
+
+func (o *forkBranchOperation) GetParam(key string) interface{} {
+	if val, ok := o.fork.getParam(o.branch, key); ok {
+		return val
+	}
+	return o.RuntimeOperation.GetParam(key)
+}
+
+func (o *forkBranchOperation) GetParamMap() map[string]interface{} {
+	res := o.RuntimeOperation.GetParamMap()
+	if res == nil {
+		res = make(map[string]interface{})
+	}
+	for k, v := range o.fork.getParamMap(o.branch) {
+		res[k] = v
+	}
+	return res
+}
+
+// 创建分支会话，节点执行时使用，流程调度仍然使用原来的会话
+func newForkBranchSession(s *andflow.Session, st *forkState, branch string) *andflow.Session {
+	return &andflow.Session{
+		Id:        s.Id,
+		Ctx:       s.Ctx,
+		Operation: &forkBranchOperation{RuntimeOperation: s.Operation, fork: st, branch: branch},
+		Router:    s.Router,
+		Runner:    s.Runner,
+	}
+}
+
+// 对话流程执行器，并行分支中的节点和连线在分支会话中执行
+type chatFlowRunner struct {
+	andflow.CommonFlowRunner
+}
+
+func (r *chatFlowRunner) ExecuteAction(s *andflow.Session, param *andflow.ActionParam, state *andflow.ActionStateModel) (andflow.Result, error) {
+	st, branch := getForkBranch(s.Id, param.ActionId, param.PreActionId, true)
+	if st != nil {
+		return r.CommonFlowRunner.ExecuteAction(newForkBranchSession(s, st, branch), param, state)
+	}
+	return r.CommonFlowRunner.ExecuteAction(s, param, state)
+}
+
+func (r *chatFlowRunner) ExecuteLink(s *andflow.Session, param *andflow.LinkParam, state *andflow.LinkStateModel) (andflow.Result, error) {
+	st, branch := getForkBranch(s.Id, param.TargetId, param.SourceId, false)
+	if st != nil {
+		return r.CommonFlowRunner.ExecuteLink(newForkBranchSession(s, st, branch), param, state)
+	}
+	res, err := r.CommonFlowRunner.ExecuteLink(s, param, state)
+	if res == andflow.RESULT_SUCCESS && err == nil {
+		arriveForkJoin(s.Id, param.SourceId, param.TargetId)
+	}
+	return res, err
+}
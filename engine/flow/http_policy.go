@@ -0,0 +1,155 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/zone-7/chatflow_engine/engine/meta"
+)
+
+// 默认超时时间，秒
+const http_policy_timeout = 30
+
+// 默认最多读取的返回内容
+const http_policy_max_body_size = 10 * 1024 * 1024
+
+// 域名是否匹配，支持 *.example.com
+func matchHttpHost(patterns []string, host string) bool {
+	host = strings.ToLower(host)
+	for _, p := range patterns {
+		p = strings.ToLower(strings.Trim(p, " "))
+		if len(p) == 0 {
+			continue
+		}
+		if p == host {
+			return true
+		}
+		if strings.HasPrefix(p, "*.") && strings.HasSuffix(host, p[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// 检查请求地址是否允许访问
+func checkHttpPolicy(policy meta.HttpPolicy, u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("只支持 http 和 https 地址: " + u.String())
+	}
+	host := u.Hostname()
+	if matchHttpHost(policy.DenyHosts, host) {
+		return errors.New("禁止访问的地址: " + host)
+	}
+	if len(policy.AllowHosts) > 0 && !matchHttpHost(policy.AllowHosts, host) {
+		return errors.New("不允许访问的地址: " + host)
+	}
+	return nil
+}
+
+// 是否是本机或内网地址
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// 检查域名解析后的地址，不能有内网地址
+func checkPrivateHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if isPrivateIP(ip) {
+			return errors.New("禁止访问内网地址: " + host)
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if isPrivateIP(addr.IP) {
+			return errors.New("禁止访问内网地址: " + host)
+		}
+	}
+	return nil
+}
+
+// 按照请求限制创建客户端，timeout_second 为0时使用默认超时时间
+// 禁止内网地址时在建立连接时检查，域名解析后的地址也不能是内网地址
+func newPolicyHttpClient(policy meta.HttpPolicy, timeout_second int, proxy string) (*http.Client, error) {
+	if timeout_second <= 0 {
+		timeout_second = policy.Timeout
+	}
+	if timeout_second <= 0 {
+		timeout_second = http_policy_timeout
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if policy.DenyPrivate {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip != nil && isPrivateIP(ip) {
+				return errors.New("禁止访问内网地址: " + host)
+			}
+			return nil
+		}
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	if len(proxy) > 0 {
+		proxy_url, err := url.Parse(proxy)
+		if err != nil {
+			return nil, errors.New("代理地址格式错误: " + proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxy_url)
+	}
+	if policy.DenyPrivate {
+		//使用代理时连接的是代理服务器，需要在发送前检查请求地址
+		proxy_func := transport.Proxy
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			proxy_url, err := proxy_func(req)
+			if err != nil || proxy_url == nil {
+				return proxy_url, err
+			}
+			err = checkPrivateHost(req.Context(), req.URL.Hostname())
+			if err != nil {
+				return nil, err
+			}
+			return proxy_url, nil
+		}
+	}
+
+	client := &http.Client{
+		Timeout:   time.Duration(timeout_second) * time.Second,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("重定向次数太多")
+			}
+			return checkHttpPolicy(policy, req.URL)
+		},
+	}
+	return client, nil
+}
+
+// 最多读取的返回内容
+func getHttpMaxBodySize(policy meta.HttpPolicy) int64 {
+	if policy.MaxBodySize > 0 {
+		return policy.MaxBodySize
+	}
+	return http_policy_max_body_size
+}
@@ -0,0 +1,84 @@
+package flow
+
+import (
+	"errors"
+	"html/template"
+	"strings"
+
+	"github.com/zone-7/andflow_go/andflow"
+	"github.com/zone-7/chatflow_engine/engine/manager"
+	"github.com/zone-7/chatflow_engine/engine/meta"
+)
+
+// 渲染提示词版本，变量没有传入时使用默认值，都没有时返回错误
+func renderPromptVersion(name string, pv *meta.PromptVersion, vars map[string]interface{}, funcs template.FuncMap) (string, error) {
+	ps := make(map[string]interface{})
+	for k, v := range vars {
+		ps[k] = v
+	}
+
+	missing := make([]string, 0)
+	for _, v := range pv.Variables {
+		if val, ok := ps[v.Name]; ok && val != nil {
+			continue
+		}
+		if len(v.DefaultValue) > 0 {
+			ps[v.Name] = v.DefaultValue
+			continue
+		}
+		missing = append(missing, v.Name)
+	}
+	if len(missing) > 0 {
+		return "", errors.New("提示词[" + name + "]变量没有值: " + strings.Join(missing, ","))
+	}
+
+	return replaceTemplateFuncs(pv.Content, "prompt_"+name, ps, funcs)
+}
+
+// 试渲染提示词，用于编辑时预览，可以使用开发环境的环境变量，不解析密钥
+func RenderPrompt(opt meta.Option, ref string, vars map[string]interface{}) (string, error) {
+	promptManager := manager.NewPromptManager(opt)
+	prompt, pv, err := promptManager.ResolvePrompt(ref)
+	if err != nil {
+		return "", err
+	}
+
+	ps := make(map[string]interface{})
+	for k, v := range vars {
+		ps[k] = v
+	}
+	if _, ok := ps["env"]; !ok {
+		envManager := manager.NewEnvManager(opt)
+		envs, err := envManager.GetEnvMap(meta.FLOW_SPACE_DEVELOP)
+		if err == nil {
+			ps["env"] = envs
+		}
+	}
+
+	return renderPromptVersion(prompt.Name, pv, ps, nil)
+}
+
+// 节点参数 xxx_prompt_ref 引用的提示词渲染后替换参数 xxx
+func (r *BaseRunner) resolvePromptRefs(s *andflow.Session, params map[string]string, ps map[string]interface{}, funcs template.FuncMap) error {
+	chatSession := r.getChatSession(s)
+	if chatSession == nil {
+		return nil
+	}
+
+	promptManager := manager.NewPromptManager(chatSession.Opt)
+	for k, v := range params {
+		if !manager.IsPromptRefParam(k) || len(strings.Trim(v, " ")) == 0 {
+			continue
+		}
+		prompt, pv, err := promptManager.ResolvePrompt(v)
+		if err != nil {
+			return err
+		}
+		content, err := renderPromptVersion(prompt.Name, pv, ps, funcs)
+		if err != nil {
+			return err
+		}
+		params[strings.TrimSuffix(k, manager.PROMPT_REF_PARAM_SUFFIX)] = content
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package flow
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/zone-7/andflow_go/andflow"
+)
+
+// 并发安全的运行时操作，多个节点同时执行时读写运行时状态都需要加锁
+// 状态变化回调在释放锁之后执行，回调中可以读取运行时
+type safeRuntimeOperation struct {
+	*andflow.CommonRuntimeOperation
+	lock         *sync.RWMutex
+	cmd          atomic.Int32
+	resume_ids   []string //只恢复指定的挂起节点，为空时恢复所有
+	OnChangeFunc func(event string, runtime *andflow.RuntimeModel)
+}
+
+// 创建运行时操作，lock 与对话会话共用，会话读取运行时的时候也要加锁
+func newSafeRuntimeOperation(runtime *andflow.RuntimeModel, lock *sync.RWMutex) *safeRuntimeOperation {
+	op := &safeRuntimeOperation{CommonRuntimeOperation: &andflow.CommonRuntimeOperation{}, lock: lock}
+	op.CommonRuntimeOperation.Init(runtime)
+	return op
+}
+
+// 加锁修改，修改完成后触发回调
+func (o *safeRuntimeOperation) write(event string, f func()) {
+	o.lock.Lock()
+	f()
+	o.lock.Unlock()
+
+	if len(event) > 0 && o.OnChangeFunc != nil {
+		o.OnChangeFunc(event, o.GetRuntime())
+	}
+}
+
+func (o *safeRuntimeOperation) SetCmd(c int) {
+	o.cmd.Store(int32(c))
+}
+func (o *safeRuntimeOperation) GetCmd() int {
+	return int(o.cmd.Load())
+}
+
+func (o *safeRuntimeOperation) AddLog(tp, tag, name, title, content string) {
+	o.write("", func() { o.CommonRuntimeOperation.AddLog(tp, tag, name, title, content) })
+}
+
+func (o *safeRuntimeOperation) SetRequestId(id string) {
+	o.write("", func() { o.CommonRuntimeOperation.SetRequestId(id) })
+}
+func (o *safeRuntimeOperation) GetRequestId() string {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	return o.CommonRuntimeOperation.GetRequestId()
+}
+
+func (o *safeRuntimeOperation) SetBegin() {
+	o.write(andflow.EVENT_BEGIN, o.CommonRuntimeOperation.SetBegin)
+}
+func (o *safeRuntimeOperation) SetEnd() {
+	o.write(andflow.EVENT_END, o.CommonRuntimeOperation.SetEnd)
+}
+
+func (o *safeRuntimeOperation) SetMessage(message string) {
+	o.write(andflow.EVENT_MESSAGE, func() { o.CommonRuntimeOperation.SetMessage(message) })
+}
+func (o *safeRuntimeOperation) GetMessage() string {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	return o.CommonRuntimeOperation.GetMessage()
+}
+
+func (o *safeRuntimeOperation) SetError(iserror int) {
+	o.write(andflow.EVENT_ISERROR, func() { o.CommonRuntimeOperation.SetError(iserror) })
+}
+func (o *safeRuntimeOperation) GetError() int {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	return o.CommonRuntimeOperation.GetError()
+}
+
+func (o *safeRuntimeOperation) SetState(state int) {
+	o.write(andflow.EVENT_FLOWSTATE, func() { o.CommonRuntimeOperation.SetState(state) })
+}
+func (o *safeRuntimeOperation) GetState() int {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	return o.CommonRuntimeOperation.GetState()
+}
+
+// 只恢复指定的挂起节点，其它挂起节点和连线保持挂起，例如等待到期事件只交给对应的等待节点
+func (o *safeRuntimeOperation) SetResumeActions(ids []string) {
+	o.resume_ids = ids
+}
+
+func (o *safeRuntimeOperation) GetRunningActions() []*andflow.ActionParam {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	list := o.CommonRuntimeOperation.GetRunningActions()
+	if list == nil {
+		return nil
+	}
+	if len(o.resume_ids) > 0 {
+		resumes := make([]*andflow.ActionParam, 0)
+		for _, param := range list {
+			for _, id := range o.resume_ids {
+				if param.ActionId == id {
+					resumes = append(resumes, param)
+					break
+				}
+			}
+		}
+		return resumes
+	}
+	return append([]*andflow.ActionParam{}, list...)
+}
+func (o *safeRuntimeOperation) GetRunningLinks() []*andflow.LinkParam {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	if len(o.resume_ids) > 0 {
+		return nil
+	}
+	list := o.CommonRuntimeOperation.GetRunningLinks()
+	if list == nil {
+		return nil
+	}
+	return append([]*andflow.LinkParam{}, list...)
+}
+
+func (o *safeRuntimeOperation) AddRunningAction(param *andflow.ActionParam) {
+	o.write(andflow.EVENT_ACTION_RUNNING_ADD, func() { o.CommonRuntimeOperation.AddRunningAction(param) })
+}
+func (o *safeRuntimeOperation) DelRunningAction(param *andflow.ActionParam) {
+	o.write(andflow.EVENT_ACTION_RUNNING_DEL, func() { o.CommonRuntimeOperation.DelRunningAction(param) })
+}
+func (o *safeRuntimeOperation) AddRunningLink(param *andflow.LinkParam) {
+	o.write(andflow.EVENT_LINK_RUNNING_ADD, func() { o.CommonRuntimeOperation.AddRunningLink(param) })
+}
+func (o *safeRuntimeOperation) DelRunningLink(param *andflow.LinkParam) {
+	o.write(andflow.EVENT_LINK_RUNNING_DEL, func() { o.CommonRuntimeOperation.DelRunningLink(param) })
+}
+
+func (o *safeRuntimeOperation) AddActionState(state *andflow.ActionStateModel) {
+	o.write(andflow.EVENT_ACTION_STATE_ADD, func() { o.CommonRuntimeOperation.AddActionState(state) })
+}
+func (o *safeRuntimeOperation) AddLinkState(state *andflow.LinkStateModel) {
+	o.write(andflow.EVENT_LINK_STATE_ADD, func() { o.CommonRuntimeOperation.AddLinkState(state) })
+}
+
+func (o *safeRuntimeOperation) GetLastActionState(actionId string) *andflow.ActionStateModel {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	return o.CommonRuntimeOperation.GetLastActionState(actionId)
+}
+func (o *safeRuntimeOperation) GetLastLinkState(sourceId string, targetId string) *andflow.LinkStateModel {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	return o.CommonRuntimeOperation.GetLastLinkState(sourceId, targetId)
+}
+
+func (o *safeRuntimeOperation) SetParam(key string, val interface{}) {
+	o.write(andflow.EVENT_PARAM_SET, func() { o.CommonRuntimeOperation.SetParam(key, val) })
+}
+func (o *safeRuntimeOperation) GetParam(key string) interface{} {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	return o.CommonRuntimeOperation.GetParam(key)
+}
+func (o *safeRuntimeOperation) GetParamMap() map[string]interface{} {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	return o.CommonRuntimeOperation.GetParamMap()
+}
+
+func (o *safeRuntimeOperation) SetActionData(actionId string, name string, val interface{}) {
+	o.write(andflow.EVENT_ACTION_DATA_SET, func() { o.CommonRuntimeOperation.SetActionData(actionId, name, val) })
+}
+func (o *safeRuntimeOperation) GetActionData(actionId string, name string) interface{} {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	return o.CommonRuntimeOperation.GetActionData(actionId, name)
+}
+func (o *safeRuntimeOperation) GetActionDataMap(actionId string) map[string]interface{} {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	return o.CommonRuntimeOperation.GetActionDataMap(actionId)
+}
+
+func (o *safeRuntimeOperation) SetActionIcon(actionId string, icon string) {
+	o.write(andflow.EVENT_ACTION_ICON_SET, func() { o.CommonRuntimeOperation.SetActionIcon(actionId, icon) })
+}
+func (o *safeRuntimeOperation) SetActionState(actionId string, state int) {
+	o.write(andflow.EVENT_ACTION_STATE_SET, func() { o.CommonRuntimeOperation.SetActionState(actionId, state) })
+}
+func (o *safeRuntimeOperation) SetActionError(actionId string, isError int) {
+	o.write(andflow.EVENT_ACTION_ERROR_SET, func() { o.CommonRuntimeOperation.SetActionError(actionId, isError) })
+}
+
+func (o *safeRuntimeOperation) SetLinkState(sourceId, targetId string, state int) {
+	o.write(andflow.EVENT_LINK_STATE_SET, func() { o.CommonRuntimeOperation.SetLinkState(sourceId, targetId, state) })
+}
+func (o *safeRuntimeOperation) SetLinkError(sourceId, targetId string, isError int) {
+	o.write(andflow.EVENT_LINK_ERROR_SET, func() { o.CommonRuntimeOperation.SetLinkError(sourceId, targetId, isError) })
+}
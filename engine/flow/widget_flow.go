@@ -0,0 +1,82 @@
+package flow
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/zone-7/chatflow_engine/engine/manager"
+	"github.com/zone-7/chatflow_engine/engine/meta"
+)
+
+// 调用工具流程，同步执行，返回流程输出的消息内容和流程参数
+func invokeWidgetFlow(chatSession *ChatSession, request_id string, flow_code string, content string, flow_params map[string]string) (string, map[string]interface{}, error) {
+	return invokeWidgetFlowAbortable(chatSession, request_id, flow_code, content, flow_params, nil)
+}
+
+// abort 关闭时停止工具流程，流程在当前节点结束后退出
+func invokeWidgetFlowAbortable(chatSession *ChatSession, request_id string, flow_code string, content string, flow_params map[string]string, abort <-chan struct{}) (string, map[string]interface{}, error) {
+	if len(flow_code) == 0 {
+		return "", nil, errors.New("工具流程编码不能为空")
+	}
+	if len(content) == 0 {
+		content = flow_code
+	}
+
+	flow_space := meta.FLOW_SPACE_PRODUCT
+	if chatSession.Info != nil && len(chatSession.Info.FlowSpace) > 0 {
+		flow_space = chatSession.Info.FlowSpace
+	}
+
+	opt := chatSession.Opt
+
+	chatFlowManager := manager.NewChatFlowManager(opt)
+	chatflow, err := chatFlowManager.LoadChatFlow(flow_space, flow_code)
+	if err != nil {
+		return "", nil, err
+	}
+	if chatflow == nil {
+		return "", nil, errors.New("工具流程不存在: " + flow_code)
+	}
+	if chatflow.FlowType != meta.FLOW_TYPE_WIDGET {
+		return "", nil, errors.New("不是工具流程: " + flow_code)
+	}
+
+	uid, _ := uuid.NewV4()
+
+	msg := meta.ChatFlowMessage{}
+	msg.Content = content
+	msg.FlowCode = flow_code
+	msg.FlowSpace = flow_space
+	msg.Params = flow_params
+	msg.RequestId = request_id
+	msg.SessionId = strings.ReplaceAll(uid.String(), "-", "")
+	if chatSession.Info != nil {
+		msg.UserId = chatSession.Info.UserId
+	}
+
+	output := ""
+	subChatSession, err := OpenChatSession(opt, msg, []string{meta.CHAT_MESSAGE_TYPE_MESSAGE}, func(message meta.ChatFlowMessage) {
+		output += message.Content
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	defer CloseChatSession(subChatSession.Info.Id)
+
+	if abort != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-abort:
+				subChatSession.Suspand()
+			case <-done:
+			}
+		}()
+	}
+
+	subChatSession.Chat(msg)
+
+	return output, subChatSession.Runtime.GetParamMap(), nil
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/fs"
 	"os"
 	"path"
 	"sort"
@@ -15,6 +14,7 @@ import (
 	"github.com/gofrs/uuid"
 	"github.com/zone-7/andflow_go/andflow"
 	"github.com/zone-7/chatflow_engine/engine/meta"
+	"github.com/zone-7/chatflow_engine/engine/utils"
 )
 
 var model_file_name_flow = "model.json"
@@ -41,7 +41,7 @@ func (c *ChatFlowManager) GetDir(space string, code string) string {
 		dir = path.Join(GetFlowDevelopPath(c.Opt), code)
 	}
 
-	os.MkdirAll(dir, os.ModePerm)
+	os.MkdirAll(dir, secure_dir_perm)
 
 	return dir
 }
@@ -76,7 +76,7 @@ func (c *ChatFlowManager) ChatFlowCount(flow_space string) int {
 
 		file := path.Join(dir, f.Name(), model_file_name_flow)
 
-		_, err := os.ReadFile(file)
+		_, err := readSecureFile(c.Opt, file)
 		if err != nil {
 			continue
 		}
@@ -95,7 +95,7 @@ func (c *ChatFlowManager) LoadChatFlowInfo(flow_space, code string) (*meta.ChatF
 
 	jsonFile := path.Join(dir, model_file_name_flow)
 
-	data, err := os.ReadFile(jsonFile)
+	data, err := readSecureFile(c.Opt, jsonFile)
 	if err != nil {
 		return nil, err
 	}
@@ -120,7 +120,7 @@ func (c *ChatFlowManager) LoadChatFlow(flow_space, code string) (*meta.ChatFlow,
 
 	jsonFile := path.Join(dir, model_file_name_flow)
 
-	data, err := os.ReadFile(jsonFile)
+	data, err := readSecureFile(c.Opt, jsonFile)
 	if err != nil {
 		return nil, errors.New("对话流程不存在")
 	}
@@ -153,7 +153,7 @@ func (c *ChatFlowManager) QueryChatFlowInfo(flow_space string, flow_type string,
 
 		file := path.Join(dir, f.Name(), model_file_name_flow)
 
-		data, err := os.ReadFile(file)
+		data, err := readSecureFile(c.Opt, file)
 		if err != nil {
 			continue
 		}
@@ -231,7 +231,7 @@ func (c *ChatFlowManager) LoadChatFlowImage(flow_space string, code string) ([]b
 
 	file := path.Join(dir, "snap.jpeg")
 
-	data, err := os.ReadFile(file)
+	data, err := readSecureFile(c.Opt, file)
 	if err != nil {
 		return nil, err
 
@@ -257,7 +257,7 @@ func (c *ChatFlowManager) SetChatFlowPublished(flow_space string, flow_code stri
 
 	jsonFile := path.Join(dir, model_file_name_flow)
 
-	err = os.WriteFile(jsonFile, data, fs.ModePerm)
+	err = writeSecureFile(c.Opt, jsonFile, data)
 	if err != nil {
 		return err
 	}
@@ -305,7 +305,7 @@ func (c *ChatFlowManager) SaveChatFlow(flow_space string, chatflow *meta.ChatFlo
 
 	jsonFile := path.Join(dir, model_file_name_flow)
 
-	err = os.WriteFile(jsonFile, data, fs.ModePerm)
+	err = writeSecureFile(c.Opt, jsonFile, data)
 	if err != nil {
 		return err
 	}
@@ -340,7 +340,7 @@ func (c *ChatFlowManager) SaveChatFlowImage(flow_space string, code string, imag
 
 	imageFile := path.Join(dir, "snap.jpeg")
 
-	os.WriteFile(imageFile, imageData, fs.ModePerm)
+	writeSecureFile(c.Opt, imageFile, imageData)
 
 }
 
@@ -392,21 +392,101 @@ func (c *ChatFlowManager) CloneChatFlow(flow_space string, code string, newname
 	return chatflow, err
 }
 
-// 发布
+// 发布，节点引用的提示词固定为当前版本
 func (c *ChatFlowManager) PublishToProduct(code string) (*meta.ChatFlow, error) {
+	promptManager := NewPromptManager(c.Opt)
+
+	//先检查引用的提示词，不存在时不发布
+	develop, err := c.LoadChatFlow(meta.FLOW_SPACE_DEVELOP, code)
+	if err != nil {
+		return nil, err
+	}
+	err = promptManager.PinChatFlowPrompts(develop)
+	if err != nil {
+		return nil, err
+	}
+	err = ValidateChatFlowExpressions(develop)
+	if err != nil {
+		return nil, err
+	}
+
 	chatflow, err := c.CopyChatFlow(meta.FLOW_SPACE_DEVELOP, code, meta.FLOW_SPACE_PRODUCT, code, "")
+	if err != nil {
+		return chatflow, err
+	}
+
+	err = promptManager.PinChatFlowPrompts(chatflow)
+	if err == nil {
+		err = c.SaveChatFlow(meta.FLOW_SPACE_PRODUCT, chatflow)
+	}
 	if err == nil {
 		c.SetChatFlowPublished(meta.FLOW_SPACE_DEVELOP, code)
 	}
 	return chatflow, err
 }
 
-// 公开为模板
+// 条件分支默认连线的名称
+const SWITCH_LINK_DEFAULT = "default"
+
+// 是否是条件分支的默认连线，名称为 default 或者没有填写条件
+func IsSwitchDefaultLink(link *andflow.LinkModel) bool {
+	return strings.ToLower(strings.Trim(link.Name, " ")) == SWITCH_LINK_DEFAULT || len(strings.TrimSpace(link.Keywords)) == 0
+}
+
+// 检查条件分支连线上的表达式，格式错误时不能发布
+func ValidateChatFlowExpressions(chatflow *meta.ChatFlow) error {
+	if chatflow == nil || chatflow.FlowModel == nil {
+		return nil
+	}
+
+	for _, action := range chatflow.FlowModel.Actions {
+		if action == nil || action.Name != "switch" {
+			continue
+		}
+		for _, link := range chatflow.FlowModel.GetLinkBySourceId(action.Id) {
+			if IsSwitchDefaultLink(link) {
+				continue
+			}
+			_, err := utils.ParseExpression(strings.TrimSpace(link.Keywords))
+			if err != nil {
+				name := link.Name
+				if target := chatflow.FlowModel.GetAction(link.TargetId); len(name) == 0 && target != nil {
+					name = target.Title
+				}
+				return fmt.Errorf("节点[%s]到[%s]的条件错误: %v", action.Title, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// 公开为模板，模板中不保留直接填写的凭据
 func (c *ChatFlowManager) PublishToTemplate(code string) (*meta.ChatFlow, error) {
 	chatflow, err := c.CopyChatFlow(meta.FLOW_SPACE_DEVELOP, code, meta.FLOW_SPACE_TEMPLATE, code, "")
+	if err != nil {
+		return chatflow, err
+	}
+
+	StripChatFlowCredentials(chatflow)
+	err = c.SaveChatFlow(meta.FLOW_SPACE_TEMPLATE, chatflow)
 	return chatflow, err
 }
 
+// 导出流程，直接填写的凭据会被清除，只保留密钥引用
+func (c *ChatFlowManager) ExportChatFlow(flow_space string, code string) ([]byte, error) {
+	chatflow, err := c.LoadChatFlow(flow_space, code)
+	if err != nil {
+		return nil, err
+	}
+	if chatflow == nil {
+		return nil, errors.New("流程不存在")
+	}
+
+	StripChatFlowCredentials(chatflow)
+
+	return json.MarshalIndent(chatflow, "", "\t")
+}
+
 // 从模板创建
 func (c *ChatFlowManager) CreateFromTemplate(code string, newname string) (*meta.ChatFlow, error) {
 	uid, _ := uuid.NewV4()
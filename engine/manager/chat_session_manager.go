@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"sort"
+	"strings"
 
 	"github.com/zone-7/andflow_go/andflow"
 	"github.com/zone-7/chatflow_engine/engine/meta"
@@ -82,7 +83,7 @@ func (s *ChatSessionInfoManager) LoadUserChatFlowParams(user_id string, flow_cod
 
 	file := path.Join(dir, "params.json")
 
-	data, err := os.ReadFile(file)
+	data, err := readSecureFile(s.Opt, file)
 
 	if err != nil {
 		return params, err
@@ -116,7 +117,7 @@ func (s *ChatSessionInfoManager) StoreUserChatFlowParams(userparam meta.UserChat
 
 	dir := path.Join(s.GetParamDir(), userparam.UserId, userparam.FlowCode)
 
-	err = os.MkdirAll(dir, os.ModePerm)
+	err = os.MkdirAll(dir, secure_dir_perm)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return err
@@ -124,7 +125,7 @@ func (s *ChatSessionInfoManager) StoreUserChatFlowParams(userparam meta.UserChat
 
 	file := path.Join(dir, "params.json")
 
-	err = os.WriteFile(file, data, os.ModePerm)
+	err = writeSecureFile(s.Opt, file, data)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return err
@@ -133,6 +134,75 @@ func (s *ChatSessionInfoManager) StoreUserChatFlowParams(userparam meta.UserChat
 	return nil
 }
 
+// 加载用户在所有流程中保存的参数
+func (s *ChatSessionInfoManager) LoadUserParams(user_id string) ([]*meta.UserChatFlowParams, error) {
+	list := make([]*meta.UserChatFlowParams, 0)
+	if len(user_id) == 0 {
+		return list, errors.New("UserId empty")
+	}
+
+	dirEntrys, err := os.ReadDir(path.Join(s.GetParamDir(), user_id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return list, nil
+		}
+		return list, err
+	}
+
+	for _, dirEntry := range dirEntrys {
+		if !dirEntry.IsDir() {
+			continue
+		}
+		params, err := s.LoadUserChatFlowParams(user_id, dirEntry.Name())
+		if err != nil || len(params) == 0 {
+			continue
+		}
+		list = append(list, &meta.UserChatFlowParams{UserId: user_id, FlowCode: dirEntry.Name(), Params: params})
+	}
+
+	return list, nil
+}
+
+// 删除用户保存的某个参数
+func (s *ChatSessionInfoManager) RemoveUserChatFlowParam(user_id string, flow_code string, name string) error {
+	params, err := s.LoadUserChatFlowParams(user_id, flow_code)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	list := make([]*meta.ChatFlowParam, 0)
+	for _, p := range params {
+		if p.Name != name {
+			list = append(list, p)
+		}
+	}
+	if len(list) == len(params) {
+		return nil
+	}
+	if len(list) == 0 {
+		return s.RemoveUserChatFlowParams(user_id, flow_code)
+	}
+
+	return s.StoreUserChatFlowParams(meta.UserChatFlowParams{UserId: user_id, FlowCode: flow_code, Params: list})
+}
+
+// 删除用户在流程中保存的所有参数，flow_code为空时删除用户的所有参数
+func (s *ChatSessionInfoManager) RemoveUserChatFlowParams(user_id string, flow_code string) error {
+	if len(user_id) == 0 {
+		return errors.New("UserId empty")
+	}
+	if strings.Contains(user_id, "..") || strings.Contains(flow_code, "..") {
+		return errors.New("参数路径不正确")
+	}
+	dir := path.Join(s.GetParamDir(), user_id, flow_code)
+
+	err := os.RemoveAll(dir)
+	return err
+}
+
 func (s *ChatSessionInfoManager) RemoveSession(user_id string, flow_code string, session_id string) error {
 	dir := path.Join(s.GetSessionDir(), user_id, flow_code, session_id)
 
@@ -160,7 +230,7 @@ func (s *ChatSessionInfoManager) LoadSessionInfos(user_id string, flow_code stri
 	for _, f := range fs {
 		file := path.Join(dir, f.Name(), "info.json")
 
-		data, err := os.ReadFile(file)
+		data, err := readSecureFile(s.Opt, file)
 		if err != nil {
 			continue
 		}
@@ -187,7 +257,7 @@ func (s *ChatSessionInfoManager) LoadSessionInfo(user_id string, flow_code strin
 
 	dir := path.Join(s.GetSessionDir(), user_id, flow_code, session_id)
 	file := path.Join(dir, "info.json")
-	data, err := os.ReadFile(file)
+	data, err := readSecureFile(s.Opt, file)
 	if err != nil {
 		return nil, err
 	}
@@ -218,7 +288,7 @@ func (s *ChatSessionInfoManager) StoreSessionInfo(info *meta.ChatSessionInfo) er
 
 	dir := path.Join(s.GetSessionDir(), user_id, flow_code, session_id)
 
-	err = os.MkdirAll(dir, os.ModePerm)
+	err = os.MkdirAll(dir, secure_dir_perm)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return err
@@ -226,7 +296,7 @@ func (s *ChatSessionInfoManager) StoreSessionInfo(info *meta.ChatSessionInfo) er
 
 	file := path.Join(dir, "info.json")
 
-	err = os.WriteFile(file, data, os.ModePerm)
+	err = writeSecureFile(s.Opt, file, data)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return err
@@ -241,7 +311,7 @@ func (s *ChatSessionInfoManager) LoadSessionMessages(user_id string, flow_code s
 
 	dir := path.Join(s.GetSessionDir(), user_id, flow_code, session_id)
 	file := path.Join(dir, "messages.json")
-	data, err := os.ReadFile(file)
+	data, err := readSecureFile(s.Opt, file)
 	if err != nil {
 		return msgs, err
 	}
@@ -290,7 +360,7 @@ func (s *ChatSessionInfoManager) StoreSessionMessages(info *meta.ChatSessionInfo
 	}
 
 	dir := path.Join(s.GetSessionDir(), user_id, flow_code, session_id)
-	err = os.MkdirAll(dir, os.ModePerm)
+	err = os.MkdirAll(dir, secure_dir_perm)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return err
@@ -298,7 +368,7 @@ func (s *ChatSessionInfoManager) StoreSessionMessages(info *meta.ChatSessionInfo
 
 	file := path.Join(dir, "messages.json")
 
-	err = os.WriteFile(file, data, os.ModePerm)
+	err = writeSecureFile(s.Opt, file, data)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return err
@@ -310,10 +380,10 @@ func (s *ChatSessionInfoManager) StoreSessionMessages(info *meta.ChatSessionInfo
 func (s *ChatSessionInfoManager) LoadSessionRuntime(user_id string, flow_code string, session_id string) (*andflow.RuntimeModel, error) {
 	dir := path.Join(s.GetSessionDir(), user_id, flow_code, session_id)
 
-	os.MkdirAll(dir, os.ModePerm)
+	os.MkdirAll(dir, secure_dir_perm)
 
 	file := path.Join(dir, "runtime.json")
-	data, err := os.ReadFile(file)
+	data, err := readSecureFile(s.Opt, file)
 	if err != nil {
 		return nil, err
 	}
@@ -343,7 +413,7 @@ func (s *ChatSessionInfoManager) StoreSessionRuntime(info *meta.ChatSessionInfo,
 	}
 
 	dir := path.Join(s.GetSessionDir(), user_id, flow_code, session_id)
-	err = os.MkdirAll(dir, os.ModePerm)
+	err = os.MkdirAll(dir, secure_dir_perm)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return err
@@ -351,7 +421,7 @@ func (s *ChatSessionInfoManager) StoreSessionRuntime(info *meta.ChatSessionInfo,
 
 	file := path.Join(dir, "runtime.json")
 
-	err = os.WriteFile(file, data, os.ModePerm)
+	err = writeSecureFile(s.Opt, file, data)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return err
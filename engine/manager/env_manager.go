@@ -0,0 +1,196 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zone-7/chatflow_engine/engine/meta"
+)
+
+var env_lock sync.Mutex
+
+// 环境变量缓存，按文件路径保存，文件修改时间或大小变化后重新读取
+var env_cache = make(map[string]*envCacheItem)
+
+type envCacheItem struct {
+	ModTime time.Time
+	Size    int64
+	Envs    map[string]string
+}
+
+// 环境变量名称
+var env_name_reg = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// 只包含环境变量引用的值，例如 {{env.OPENAI_URL}}
+var env_reference_reg = regexp.MustCompile(`^\s*(\{\{\s*env\.[A-Za-z_][A-Za-z0-9_]*\s*\}\}\s*)+$`)
+
+type EnvManager struct {
+	Opt meta.Option
+}
+
+func NewEnvManager(opt meta.Option) EnvManager {
+	return EnvManager{Opt: opt}
+}
+
+func (m *EnvManager) GetEnvFile(flow_space string) string {
+	return path.Join(GetEnvPath(m.Opt), flow_space+".json")
+}
+
+func (m *EnvManager) checkSpace(flow_space string) error {
+	switch flow_space {
+	case meta.FLOW_SPACE_DEVELOP, meta.FLOW_SPACE_TEMPLATE, meta.FLOW_SPACE_PRODUCT:
+		return nil
+	}
+	return errors.New("流程空间不存在: " + flow_space)
+}
+
+func (m *EnvManager) loadEnvs(flow_space string) ([]*meta.Env, error) {
+	envs := make([]*meta.Env, 0)
+
+	err := m.checkSpace(flow_space)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := readSecureFile(m.Opt, m.GetEnvFile(flow_space))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return envs, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &envs)
+	if err != nil {
+		return nil, err
+	}
+	return envs, nil
+}
+
+func (m *EnvManager) storeEnvs(flow_space string, envs []*meta.Env) error {
+	err := m.checkSpace(flow_space)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(envs, "", "\t")
+	if err != nil {
+		return err
+	}
+	file := m.GetEnvFile(flow_space)
+	delete(env_cache, file)
+	return writeSecureFile(m.Opt, file, data)
+}
+
+// 环境变量列表
+func (m *EnvManager) ListEnvs(flow_space string) ([]*meta.Env, error) {
+	env_lock.Lock()
+	defer env_lock.Unlock()
+
+	envs, err := m.loadEnvs(flow_space)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(envs, func(i, j int) bool {
+		return envs[i].Name < envs[j].Name
+	})
+	return envs, nil
+}
+
+// 环境变量，用于模版替换
+func (m *EnvManager) GetEnvMap(flow_space string) (map[string]string, error) {
+	env_lock.Lock()
+	defer env_lock.Unlock()
+
+	res := make(map[string]string)
+
+	//文件没有变化时使用缓存，避免每次模版替换都读取和解密
+	file := m.GetEnvFile(flow_space)
+	info, stat_err := os.Stat(file)
+	if stat_err == nil {
+		if item, ok := env_cache[file]; ok && item.ModTime.Equal(info.ModTime()) && item.Size == info.Size() {
+			for k, v := range item.Envs {
+				res[k] = v
+			}
+			return res, nil
+		}
+	}
+
+	envs, err := m.loadEnvs(flow_space)
+	if err != nil {
+		return res, err
+	}
+	cached := make(map[string]string)
+	for _, env := range envs {
+		res[env.Name] = env.Value
+		cached[env.Name] = env.Value
+	}
+	if stat_err == nil {
+		env_cache[file] = &envCacheItem{ModTime: info.ModTime(), Size: info.Size(), Envs: cached}
+	}
+	return res, nil
+}
+
+// 新增或修改环境变量
+func (m *EnvManager) SetEnv(flow_space string, name string, value string, description string) error {
+	name = strings.Trim(name, " ")
+	if !env_name_reg.MatchString(name) {
+		return errors.New("环境变量名称只能包含字母、数字和下划线: " + name)
+	}
+
+	env_lock.Lock()
+	defer env_lock.Unlock()
+
+	envs, err := m.loadEnvs(flow_space)
+	if err != nil {
+		return err
+	}
+
+	var env *meta.Env
+	for _, e := range envs {
+		if e.Name == name {
+			env = e
+			break
+		}
+	}
+	if env == nil {
+		env = &meta.Env{Name: name}
+		envs = append(envs, env)
+	}
+	env.Value = value
+	env.Description = description
+	env.UpdateTime = time.Now().UnixNano() / 1e6
+
+	return m.storeEnvs(flow_space, envs)
+}
+
+// 删除环境变量
+func (m *EnvManager) RemoveEnv(flow_space string, name string) error {
+	env_lock.Lock()
+	defer env_lock.Unlock()
+
+	envs, err := m.loadEnvs(flow_space)
+	if err != nil {
+		return err
+	}
+
+	list := make([]*meta.Env, 0)
+	for _, e := range envs {
+		if e.Name != name {
+			list = append(list, e)
+		}
+	}
+	return m.storeEnvs(flow_space, list)
+}
+
+// 是否是环境变量引用，引用可以安全导出
+func IsEnvReference(value string) bool {
+	return env_reference_reg.MatchString(value)
+}
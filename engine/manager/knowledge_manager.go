@@ -92,7 +92,7 @@ func (k *KnowledgeManager) GetKnowledgeDir() string {
 func (k *KnowledgeManager) GetKnowledgeCount() int {
 	dir := k.GetKnowledgeDir()
 
-	err := os.MkdirAll(dir, os.ModePerm)
+	err := os.MkdirAll(dir, secure_dir_perm)
 	if err != nil {
 		return 0
 	}
@@ -108,7 +108,7 @@ func (k *KnowledgeManager) KnowledgeInfoQuery(title string) ([]*meta.KnowledgeIn
 
 	dir := k.GetKnowledgeDir()
 
-	err := os.MkdirAll(dir, os.ModePerm)
+	err := os.MkdirAll(dir, secure_dir_perm)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return nil, err
@@ -127,7 +127,7 @@ func (k *KnowledgeManager) KnowledgeInfoQuery(title string) ([]*meta.KnowledgeIn
 		}
 
 		infopath := path.Join(dir, item.Name(), "info.yaml")
-		data, err := os.ReadFile(infopath)
+		data, err := readSecureFile(k.Opt, infopath)
 		if err != nil {
 			continue
 		}
@@ -162,7 +162,7 @@ func (k *KnowledgeManager) KnowledgeInfoQuery(title string) ([]*meta.KnowledgeIn
 func (k *KnowledgeManager) KnowledgeInfoList() ([]*meta.KnowledgeInfo, error) {
 	dir := k.GetKnowledgeDir()
 
-	err := os.MkdirAll(dir, os.ModePerm)
+	err := os.MkdirAll(dir, secure_dir_perm)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return nil, err
@@ -181,7 +181,7 @@ func (k *KnowledgeManager) KnowledgeInfoList() ([]*meta.KnowledgeInfo, error) {
 		}
 
 		infopath := path.Join(dir, item.Name(), "info.yaml")
-		data, err := os.ReadFile(infopath)
+		data, err := readSecureFile(k.Opt, infopath)
 		if err != nil {
 			continue
 		}
@@ -208,13 +208,13 @@ func (k *KnowledgeManager) KnowledgeInfoList() ([]*meta.KnowledgeInfo, error) {
 func (k *KnowledgeManager) GetKnowledgeInfo(knowledge_id string) (*meta.KnowledgeInfo, error) {
 	dir := k.GetKnowledgeDir()
 
-	err := os.MkdirAll(dir, os.ModePerm)
+	err := os.MkdirAll(dir, secure_dir_perm)
 	if err != nil {
 		return nil, err
 	}
 
 	infopath := path.Join(dir, knowledge_id, "info.yaml")
-	data, err := os.ReadFile(infopath)
+	data, err := readSecureFile(k.Opt, infopath)
 	if err != nil {
 		return nil, err
 	}
@@ -242,7 +242,7 @@ func (k *KnowledgeManager) SetKnowledgeInfo(knowledgeInfo *meta.KnowledgeInfo) e
 	// }
 
 	dir := path.Join(k.GetKnowledgeDir(), knowledgeInfo.Id)
-	err := os.MkdirAll(dir, os.ModePerm)
+	err := os.MkdirAll(dir, secure_dir_perm)
 	if err != nil {
 		return err
 	}
@@ -253,7 +253,7 @@ func (k *KnowledgeManager) SetKnowledgeInfo(knowledgeInfo *meta.KnowledgeInfo) e
 	}
 
 	infopath := path.Join(dir, "info.yaml")
-	err = os.WriteFile(infopath, data, os.ModePerm)
+	err = writeSecureFile(k.Opt, infopath, data)
 	if err != nil {
 		return err
 	}
@@ -297,7 +297,7 @@ func (k *KnowledgeManager) GetKnowledgePayloads(knowledge_id string) ([]meta.Pay
 func (k *KnowledgeManager) GetKnowledgeVectors(knowledge_id string) ([]meta.Vector, error) {
 	dir := path.Join(k.GetKnowledgeDir(), knowledge_id, "vectors.yaml")
 
-	data, err := os.ReadFile(dir)
+	data, err := readSecureFile(k.Opt, dir)
 	if err != nil {
 		return nil, err
 	}
@@ -389,13 +389,13 @@ func (k *KnowledgeManager) GenerateVectors(knowledge_id string) error {
 
 	//保存到文件
 	dir := path.Join(k.GetKnowledgeDir(), knowledge_id)
-	err = os.MkdirAll(dir, os.ModePerm)
+	err = os.MkdirAll(dir, secure_dir_perm)
 	if err != nil {
 		return err
 	}
 
 	filepath := path.Join(dir, "vectors.yaml")
-	err = os.WriteFile(filepath, data, os.ModePerm)
+	err = writeSecureFile(k.Opt, filepath, data)
 	if err != nil {
 		return err
 	}
@@ -601,7 +601,7 @@ func (k *KnowledgeManager) GetAllFileCount() int {
 func (k *KnowledgeManager) GetFileCount(knowledge_id string) int {
 	dir := path.Join(k.GetKnowledgeDir(), knowledge_id)
 
-	err := os.MkdirAll(dir, os.ModePerm)
+	err := os.MkdirAll(dir, secure_dir_perm)
 	if err != nil {
 		return 0
 	}
@@ -619,7 +619,7 @@ func (k *KnowledgeManager) GetFileCount(knowledge_id string) int {
 func (k *KnowledgeManager) GetFileInfos(knowledge_id string) ([]*meta.FileInfo, error) {
 	dir := path.Join(k.GetKnowledgeDir(), knowledge_id)
 
-	err := os.MkdirAll(dir, os.ModePerm)
+	err := os.MkdirAll(dir, secure_dir_perm)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return nil, err
@@ -657,14 +657,14 @@ func (k *KnowledgeManager) SetFileInfo(info *meta.FileInfo) error {
 		return err
 	}
 	dir := path.Join(k.GetKnowledgeDir(), knowledge_id, file_name)
-	err = os.MkdirAll(dir, os.ModePerm)
+	err = os.MkdirAll(dir, secure_dir_perm)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return err
 	}
 	yamlfile := path.Join(dir, "info.yaml")
 
-	err = os.WriteFile(yamlfile, data, os.ModePerm)
+	err = writeSecureFile(k.Opt, yamlfile, data)
 
 	return err
 }
@@ -673,7 +673,7 @@ func (k *KnowledgeManager) SetFileInfo(info *meta.FileInfo) error {
 func (k *KnowledgeManager) GetFileInfo(knowledge_id string, file_name string) (*meta.FileInfo, error) {
 
 	yamlfile := path.Join(k.GetKnowledgeDir(), knowledge_id, file_name, "info.yaml")
-	data, err := os.ReadFile(yamlfile)
+	data, err := readSecureFile(k.Opt, yamlfile)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return nil, err
@@ -700,7 +700,7 @@ func (k *KnowledgeManager) GetFilePath(knowledge_id string, name string, ext str
 func (k *KnowledgeManager) FileUpload(knowledge_id string, name string, ext string, reader io.Reader) error {
 
 	dir := path.Join(k.GetKnowledgeDir(), knowledge_id, name)
-	err := os.MkdirAll(dir, os.ModePerm)
+	err := os.MkdirAll(dir, secure_dir_perm)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return err
@@ -717,7 +717,7 @@ func (k *KnowledgeManager) FileUpload(knowledge_id string, name string, ext stri
 
 	word_count := len(strings.Split(string(b), ""))
 
-	err = os.WriteFile(filepath, b, os.ModePerm)
+	err = writeSecureFile(k.Opt, filepath, b)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return err
@@ -768,7 +768,7 @@ func (k *KnowledgeManager) FileDownload(knowledge_id string, name string, ext st
 
 	filepath := path.Join(k.GetKnowledgeDir(), knowledge_id, name, "source"+ext)
 
-	b, err := os.ReadFile(filepath)
+	b, err := readSecureFile(k.Opt, filepath)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return err
@@ -849,7 +849,7 @@ func (k *KnowledgeManager) GenerateFilePayloads(knowledge_id string, file_name s
 	}
 
 	dir := path.Join(k.GetKnowledgeDir(), knowledge_id, file_name)
-	err = os.MkdirAll(dir, os.ModePerm)
+	err = os.MkdirAll(dir, secure_dir_perm)
 	if err != nil {
 		return 0, err
 	}
@@ -886,7 +886,7 @@ func (k *KnowledgeManager) GenerateFilePayloads(knowledge_id string, file_name s
 	}
 
 	filepath := path.Join(dir, "payload.yaml")
-	err = os.WriteFile(filepath, data, os.ModePerm)
+	err = writeSecureFile(k.Opt, filepath, data)
 	if err != nil {
 		return 0, err
 	}
@@ -912,6 +912,13 @@ func (k *KnowledgeManager) SplitFile(fileInfo *meta.FileInfo) ([]string, error)
 
 	filepath := k.GetFilePath(fileInfo.KnowledgeId, fileInfo.FileName, fileInfo.FileExt)
 
+	// 加密的源文件先解密到临时文件
+	filepath, cleanup, err := readSecureFileToTemp(k.Opt, filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
 	// 读取文件内容
 	words, err := utils.ReadFile(filepath)
 
@@ -953,7 +960,7 @@ func (k *KnowledgeManager) SplitFile(fileInfo *meta.FileInfo) ([]string, error)
 func (k *KnowledgeManager) GetFilePayloads(knowledge_id string, file_name string) ([]meta.Payload, error) {
 	dir := path.Join(k.GetKnowledgeDir(), knowledge_id, file_name, "payload.yaml")
 
-	data, err := os.ReadFile(dir)
+	data, err := readSecureFile(k.Opt, dir)
 	if err != nil {
 		return nil, err
 	}
@@ -66,3 +66,45 @@ func GetParamPath(opt meta.Option) string {
 	p := path.Join(opt.WorkspacePath, "param")
 	return p
 }
+
+// 会话保留策略及清理记录
+func GetRetentionPath(opt meta.Option) string {
+	p := path.Join(opt.WorkspacePath, "retention")
+	return p
+}
+
+// 解密文件的临时目录
+func GetTempPath(opt meta.Option) string {
+	p := path.Join(opt.WorkspacePath, "tmp")
+	return p
+}
+
+// 流程输出文件（文件写入、Excel导出），上传文件和邮件附件只能读取该目录
+func GetOutputPath(opt meta.Option) string {
+	p := path.Join(opt.WorkspacePath, "output")
+	return p
+}
+
+// 等待中的会话调度
+func GetWaitPath(opt meta.Option) string {
+	p := path.Join(opt.WorkspacePath, "wait")
+	return p
+}
+
+// 密钥存储
+func GetSecretPath(opt meta.Option) string {
+	p := path.Join(opt.WorkspacePath, "secret")
+	return p
+}
+
+// 环境变量，每个流程空间一个文件
+func GetEnvPath(opt meta.Option) string {
+	p := path.Join(opt.WorkspacePath, "env")
+	return p
+}
+
+// 提示词库
+func GetPromptPath(opt meta.Option) string {
+	p := path.Join(opt.WorkspacePath, "prompt")
+	return p
+}
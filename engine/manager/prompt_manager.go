@@ -0,0 +1,293 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zone-7/chatflow_engine/engine/meta"
+)
+
+var prompt_lock sync.Mutex
+
+// 提示词名称
+var prompt_name_reg = regexp.MustCompile(`^[A-Za-z0-9_\-]+$`)
+
+// 模版中的变量，例如 {{name}}、{{ .name }}
+var prompt_variable_reg = regexp.MustCompile(`\{\{\s*\.?([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// 引用提示词的节点参数后缀，例如 req_cosplay_prompt_ref 的值替换 req_cosplay
+const PROMPT_REF_PARAM_SUFFIX = "_prompt_ref"
+
+type PromptManager struct {
+	Opt meta.Option
+}
+
+func NewPromptManager(opt meta.Option) PromptManager {
+	return PromptManager{Opt: opt}
+}
+
+func (m *PromptManager) GetPromptFile(name string) string {
+	return path.Join(GetPromptPath(m.Opt), name+".json")
+}
+
+func (m *PromptManager) checkName(name string) error {
+	if !prompt_name_reg.MatchString(name) {
+		return errors.New("提示词名称只能包含字母、数字、下划线和中划线: " + name)
+	}
+	return nil
+}
+
+func (m *PromptManager) loadPrompt(name string) (*meta.Prompt, error) {
+	err := m.checkName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := readSecureFile(m.Opt, m.GetPromptFile(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.New("提示词不存在: " + name)
+		}
+		return nil, err
+	}
+
+	var prompt meta.Prompt
+	err = json.Unmarshal(data, &prompt)
+	if err != nil {
+		return nil, err
+	}
+	return &prompt, nil
+}
+
+func (m *PromptManager) storePrompt(prompt *meta.Prompt) error {
+	data, err := json.MarshalIndent(prompt, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeSecureFile(m.Opt, m.GetPromptFile(prompt.Name), data)
+}
+
+// 提示词列表
+func (m *PromptManager) ListPrompts() ([]*meta.Prompt, error) {
+	prompt_lock.Lock()
+	defer prompt_lock.Unlock()
+
+	list := make([]*meta.Prompt, 0)
+
+	fs, err := os.ReadDir(GetPromptPath(m.Opt))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return list, nil
+		}
+		return nil, err
+	}
+	for _, f := range fs {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		prompt, err := m.loadPrompt(strings.TrimSuffix(f.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		list = append(list, prompt)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+// 加载提示词及所有版本
+func (m *PromptManager) LoadPrompt(name string) (*meta.Prompt, error) {
+	prompt_lock.Lock()
+	defer prompt_lock.Unlock()
+
+	return m.loadPrompt(name)
+}
+
+// 新增或修改提示词的标题和说明，不修改版本
+func (m *PromptManager) SavePrompt(name string, title string, description string) (*meta.Prompt, error) {
+	name = strings.Trim(name, " ")
+	err := m.checkName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	prompt_lock.Lock()
+	defer prompt_lock.Unlock()
+
+	now := time.Now().UnixNano() / 1e6
+
+	var prompt *meta.Prompt
+	if _, err := os.Stat(m.GetPromptFile(name)); os.IsNotExist(err) {
+		prompt = &meta.Prompt{Name: name, Versions: make([]*meta.PromptVersion, 0), CreateTime: now}
+	} else {
+		prompt, err = m.loadPrompt(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	prompt.Title = title
+	prompt.Description = description
+	prompt.UpdateTime = now
+
+	err = m.storePrompt(prompt)
+	if err != nil {
+		return nil, err
+	}
+	return prompt, nil
+}
+
+// 发布新版本，版本号递增，已有版本不会修改
+// 没有定义变量时从模版中识别
+func (m *PromptManager) PublishPromptVersion(name string, content string, variables []*meta.PromptVariable, description string) (*meta.PromptVersion, error) {
+	prompt_lock.Lock()
+	defer prompt_lock.Unlock()
+
+	prompt, err := m.loadPrompt(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(strings.Trim(content, " \n")) == 0 {
+		return nil, errors.New("提示词内容不能为空")
+	}
+
+	if len(variables) == 0 {
+		variables = GetPromptVariables(content)
+	}
+
+	now := time.Now().UnixNano() / 1e6
+
+	version := &meta.PromptVersion{Version: 1, Content: content, Variables: variables, Description: description, CreateTime: now}
+	if latest := prompt.Latest(); latest != nil {
+		version.Version = latest.Version + 1
+	}
+
+	prompt.Versions = append(prompt.Versions, version)
+	prompt.UpdateTime = now
+
+	err = m.storePrompt(prompt)
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// 删除提示词
+func (m *PromptManager) RemovePrompt(name string) error {
+	err := m.checkName(name)
+	if err != nil {
+		return err
+	}
+
+	prompt_lock.Lock()
+	defer prompt_lock.Unlock()
+
+	err = os.Remove(m.GetPromptFile(name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// 根据引用获取提示词版本，引用格式 name、name@latest 或 name@3，没有版本号时使用最新版本
+func (m *PromptManager) ResolvePrompt(ref string) (*meta.Prompt, *meta.PromptVersion, error) {
+	name, version := ParsePromptRef(ref)
+
+	prompt, err := m.LoadPrompt(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var pv *meta.PromptVersion
+	if len(version) == 0 || version == meta.PROMPT_VERSION_LATEST {
+		pv = prompt.Latest()
+	} else {
+		v, err := strconv.Atoi(version)
+		if err != nil {
+			return nil, nil, errors.New("提示词版本格式错误: " + ref)
+		}
+		pv = prompt.GetVersion(v)
+	}
+	if pv == nil {
+		return nil, nil, errors.New("提示词版本不存在: " + ref)
+	}
+	return prompt, pv, nil
+}
+
+// 固定引用的版本，没有版本号的引用固定为当前最新版本，latest 保持不变
+func (m *PromptManager) PinPromptRef(ref string) (string, error) {
+	_, pv, err := m.ResolvePrompt(ref)
+	if err != nil {
+		return ref, err
+	}
+
+	name, version := ParsePromptRef(ref)
+	if len(version) > 0 {
+		return ref, nil
+	}
+	return name + "@" + strconv.Itoa(pv.Version), nil
+}
+
+// 发布流程时固定节点引用的提示词版本，之后发布的新版本不影响已经发布的流程
+func (m *PromptManager) PinChatFlowPrompts(chatflow *meta.ChatFlow) error {
+	if chatflow == nil || chatflow.FlowModel == nil {
+		return nil
+	}
+
+	for _, action := range chatflow.FlowModel.Actions {
+		if action == nil || action.Params == nil {
+			continue
+		}
+		for k, v := range action.Params {
+			if !IsPromptRefParam(k) || len(strings.Trim(v, " ")) == 0 {
+				continue
+			}
+			ref, err := m.PinPromptRef(strings.Trim(v, " "))
+			if err != nil {
+				return errors.New("节点[" + action.Title + "]" + err.Error())
+			}
+			action.Params[k] = ref
+		}
+	}
+	return nil
+}
+
+// 解析提示词引用，返回名称和版本
+func ParsePromptRef(ref string) (string, string) {
+	ref = strings.Trim(ref, " ")
+	idx := strings.LastIndex(ref, "@")
+	if idx < 0 {
+		return ref, ""
+	}
+	return strings.Trim(ref[:idx], " "), strings.Trim(ref[idx+1:], " ")
+}
+
+// 是否是引用提示词的节点参数
+func IsPromptRefParam(name string) bool {
+	return strings.HasSuffix(name, PROMPT_REF_PARAM_SUFFIX)
+}
+
+// 识别模版中的变量
+func GetPromptVariables(content string) []*meta.PromptVariable {
+	variables := make([]*meta.PromptVariable, 0)
+	exists := make(map[string]bool)
+	for _, item := range prompt_variable_reg.FindAllStringSubmatch(content, -1) {
+		name := item[1]
+		if exists[name] {
+			continue
+		}
+		exists[name] = true
+		variables = append(variables, &meta.PromptVariable{Name: name})
+	}
+	return variables
+}
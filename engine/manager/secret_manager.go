@@ -0,0 +1,268 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zone-7/chatflow_engine/engine/meta"
+)
+
+var secret_file_name = "secrets.json"
+
+var secret_lock sync.Mutex
+
+// 只包含密钥引用的值，例如 {{secret "openai_key"}}
+var secret_reference_reg = regexp.MustCompile(`^\s*(\{\{\s*secret\s+"[^"]*"\s*\}\}\s*)+$`)
+
+// 属于凭据的节点参数名
+var credential_param_reg = regexp.MustCompile(`(?i)(api_key|secret_key|password|token|datasource)$`)
+
+// 属于凭据的请求头
+var credential_header_reg = regexp.MustCompile(`(?i)(authorization|api[-_]?key|token|cookie|secret)`)
+
+type SecretManager struct {
+	Opt meta.Option
+}
+
+func NewSecretManager(opt meta.Option) SecretManager {
+	return SecretManager{Opt: opt}
+}
+
+func (m *SecretManager) GetSecretFile() string {
+	return path.Join(GetSecretPath(m.Opt), secret_file_name)
+}
+
+func (m *SecretManager) loadSecrets() ([]*meta.Secret, error) {
+	secrets := make([]*meta.Secret, 0)
+
+	data, err := readSecureFile(m.Opt, m.GetSecretFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return secrets, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &secrets)
+	if err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+func (m *SecretManager) storeSecrets(secrets []*meta.Secret) error {
+	data, err := json.MarshalIndent(secrets, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeSecureFile(m.Opt, m.GetSecretFile(), data)
+}
+
+// 密钥列表，不包含密钥值
+func (m *SecretManager) ListSecrets() ([]*meta.Secret, error) {
+	secret_lock.Lock()
+	defer secret_lock.Unlock()
+
+	secrets, err := m.loadSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*meta.Secret, 0)
+	for _, secret := range secrets {
+		list = append(list, &meta.Secret{Name: secret.Name, Description: secret.Description, CreateTime: secret.CreateTime, UpdateTime: secret.UpdateTime})
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+// 获取密钥值
+func (m *SecretManager) GetSecret(name string) (string, error) {
+	secret_lock.Lock()
+	defer secret_lock.Unlock()
+
+	secrets, err := m.loadSecrets()
+	if err != nil {
+		return "", err
+	}
+	for _, secret := range secrets {
+		if secret.Name == name {
+			return secret.Value, nil
+		}
+	}
+	return "", errors.New("密钥不存在: " + name)
+}
+
+// 新增或修改密钥
+func (m *SecretManager) SetSecret(name string, value string, description string) error {
+	name = strings.Trim(name, " ")
+	if len(name) == 0 {
+		return errors.New("密钥名称不能为空")
+	}
+
+	secret_lock.Lock()
+	defer secret_lock.Unlock()
+
+	secrets, err := m.loadSecrets()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixNano() / 1e6
+
+	var secret *meta.Secret
+	for _, s := range secrets {
+		if s.Name == name {
+			secret = s
+			break
+		}
+	}
+	if secret == nil {
+		secret = &meta.Secret{Name: name, CreateTime: now}
+		secrets = append(secrets, secret)
+	}
+	secret.Value = value
+	secret.Description = description
+	secret.UpdateTime = now
+
+	return m.storeSecrets(secrets)
+}
+
+// 删除密钥
+func (m *SecretManager) RemoveSecret(name string) error {
+	secret_lock.Lock()
+	defer secret_lock.Unlock()
+
+	secrets, err := m.loadSecrets()
+	if err != nil {
+		return err
+	}
+
+	list := make([]*meta.Secret, 0)
+	for _, s := range secrets {
+		if s.Name != name {
+			list = append(list, s)
+		}
+	}
+	return m.storeSecrets(list)
+}
+
+// 是否是密钥引用，引用可以安全导出
+func IsSecretReference(value string) bool {
+	return secret_reference_reg.MatchString(value)
+}
+
+// 是否是凭据参数
+func IsCredentialParam(name string) bool {
+	return credential_param_reg.MatchString(name)
+}
+
+// 是否是凭据请求头
+func IsCredentialHeader(name string) bool {
+	return credential_header_reg.MatchString(name)
+}
+
+// 是否是密钥或环境变量引用
+func isCredentialReference(value string) bool {
+	return IsSecretReference(value) || IsEnvReference(value)
+}
+
+// 清除流程中直接填写的凭据，只保留密钥和环境变量引用，用于导出和公开为模板
+func StripChatFlowCredentials(chatflow *meta.ChatFlow) {
+	if chatflow == nil {
+		return
+	}
+
+	for _, p := range chatflow.Params {
+		if p.InputType == "password" || IsCredentialParam(p.Name) {
+			if !isCredentialReference(p.Value) {
+				p.Value = ""
+			}
+			if !isCredentialReference(p.DebugValue) {
+				p.DebugValue = ""
+			}
+		}
+	}
+
+	if chatflow.FlowModel == nil {
+		return
+	}
+
+	for _, action := range chatflow.FlowModel.Actions {
+		if action == nil || action.Params == nil {
+			continue
+		}
+		for k, v := range action.Params {
+			if len(v) == 0 || isCredentialReference(v) {
+				continue
+			}
+
+			if IsCredentialParam(k) {
+				action.Params[k] = ""
+				continue
+			}
+
+			//智能体工具中的数据库地址、请求头等凭据
+			if k == "tools" {
+				tools := make([]map[string]interface{}, 0)
+				if json.Unmarshal([]byte(v), &tools) != nil {
+					continue
+				}
+				for _, tool := range tools {
+					stripToolCredentials(tool)
+				}
+				data, err := json.Marshal(tools)
+				if err == nil {
+					action.Params[k] = string(data)
+				}
+				continue
+			}
+
+			//请求头中的凭据
+			if k == "headers" {
+				headers := make(map[string]string)
+				if json.Unmarshal([]byte(v), &headers) != nil {
+					continue
+				}
+				for hk, hv := range headers {
+					if credential_header_reg.MatchString(hk) && !isCredentialReference(hv) {
+						headers[hk] = ""
+					}
+				}
+				data, err := json.Marshal(headers)
+				if err == nil {
+					action.Params[k] = string(data)
+				}
+			}
+		}
+	}
+}
+
+// 清除工具定义中直接填写的凭据
+func stripToolCredentials(tool map[string]interface{}) {
+	for k, v := range tool {
+		str, ok := v.(string)
+		if ok && len(str) > 0 && IsCredentialParam(k) && !isCredentialReference(str) {
+			tool[k] = ""
+		}
+	}
+	headers, ok := tool["headers"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for hk, hv := range headers {
+		str, ok := hv.(string)
+		if ok && credential_header_reg.MatchString(hk) && !isCredentialReference(str) {
+			headers[hk] = ""
+		}
+	}
+}
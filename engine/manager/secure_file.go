@@ -0,0 +1,560 @@
+package manager
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/zone-7/chatflow_engine/engine/meta"
+)
+
+// 工作空间文件和目录权限
+const (
+	secure_file_perm fs.FileMode = 0600
+	secure_dir_perm  fs.FileMode = 0700
+)
+
+// 加密文件头
+var secure_file_magic = []byte("CFENC1\n")
+
+// 主密钥提供者，主密钥用于加密每个文件的数据密钥
+type KeyProvider interface {
+	// 当前用于加密的主密钥ID和主密钥
+	ActiveKey() (string, []byte, error)
+	// 根据ID获取主密钥，用于解密
+	GetKey(id string) ([]byte, error)
+}
+
+// 支持密钥轮换的主密钥提供者
+type KeyRotator interface {
+	RotateKey() (string, error)
+}
+
+var key_providers = make(map[string]func(opt meta.Option) (KeyProvider, error))
+
+func init() {
+	RegistKeyProvider(meta.ENCRYPT_KEYFILE, func(opt meta.Option) (KeyProvider, error) {
+		if len(opt.EncryptKeyFile) == 0 {
+			return nil, errors.New("加密密钥文件未配置")
+		}
+		return GetKeyfileProvider(opt.EncryptKeyFile, opt.WorkspacePath), nil
+	})
+}
+
+// 注册主密钥提供者
+func RegistKeyProvider(name string, creator func(opt meta.Option) (KeyProvider, error)) {
+	key_providers[name] = creator
+}
+
+// 根据配置创建主密钥提供者，不加密时返回nil
+func CreateKeyProvider(opt meta.Option) (KeyProvider, error) {
+	if opt.Encrypt == meta.ENCRYPT_NONE {
+		return nil, nil
+	}
+	creator := key_providers[opt.Encrypt]
+	if creator == nil {
+		return nil, errors.New("加密方式不存在: " + opt.Encrypt)
+	}
+	return creator(opt)
+}
+
+// 加密数据信封
+type secureEnvelope struct {
+	KeyId      string `json:"kid"`
+	WrapNonce  string `json:"wrap_nonce"`
+	WrappedKey string `json:"wrapped_key"`
+	Nonce      string `json:"nonce"`
+	Data       string `json:"data"`
+}
+
+// 是否是加密数据
+func isSecureData(data []byte) bool {
+	return bytes.HasPrefix(data, secure_file_magic)
+}
+
+func gcmSeal(key []byte, plain []byte, additional []byte) ([]byte, []byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, err
+	}
+	return nonce, gcm.Seal(nil, nonce, plain, additional), nil
+}
+
+func gcmOpen(key []byte, nonce []byte, sealed []byte, additional []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, nonce, sealed, additional)
+}
+
+// 信封加密：随机数据密钥加密内容，主密钥加密数据密钥
+func encryptData(provider KeyProvider, plain []byte) ([]byte, error) {
+	kid, kek, err := provider.ActiveKey()
+	if err != nil {
+		return nil, err
+	}
+
+	dek := make([]byte, 32)
+	if _, err = io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, err
+	}
+
+	wrapNonce, wrappedKey, err := gcmSeal(kek, dek, []byte(kid))
+	if err != nil {
+		return nil, err
+	}
+	nonce, sealed, err := gcmSeal(dek, plain, []byte(kid))
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := secureEnvelope{}
+	envelope.KeyId = kid
+	envelope.WrapNonce = base64.StdEncoding.EncodeToString(wrapNonce)
+	envelope.WrappedKey = base64.StdEncoding.EncodeToString(wrappedKey)
+	envelope.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	envelope.Data = base64.StdEncoding.EncodeToString(sealed)
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]byte{}, secure_file_magic...), data...), nil
+}
+
+// 信封解密
+func decryptData(provider KeyProvider, data []byte) ([]byte, error) {
+	if provider == nil {
+		return nil, errors.New("加密文件无法读取，加密方式未配置")
+	}
+
+	var envelope secureEnvelope
+	err := json.Unmarshal(data[len(secure_file_magic):], &envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	kek, err := provider.GetKey(envelope.KeyId)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapNonce, err := base64.StdEncoding.DecodeString(envelope.WrapNonce)
+	if err != nil {
+		return nil, err
+	}
+	wrappedKey, err := base64.StdEncoding.DecodeString(envelope.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(envelope.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(envelope.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	dek, err := gcmOpen(kek, wrapNonce, wrappedKey, []byte(envelope.KeyId))
+	if err != nil {
+		return nil, err
+	}
+
+	return gcmOpen(dek, nonce, sealed, []byte(envelope.KeyId))
+}
+
+// 写入工作空间文件，配置了加密就加密后写入
+func writeSecureFile(opt meta.Option, file string, data []byte) error {
+	err := os.MkdirAll(path.Dir(file), secure_dir_perm)
+	if err != nil {
+		return err
+	}
+
+	provider, err := CreateKeyProvider(opt)
+	if err != nil {
+		return err
+	}
+
+	if provider != nil {
+		data, err = encryptData(provider, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeFileAtomic(file, data, secure_file_perm)
+}
+
+// 先写入同目录下的临时文件再替换，写入过程中异常退出不会破坏原文件
+func writeFileAtomic(file string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(path.Dir(file), "."+path.Base(file)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp_name := tmp.Name()
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if close_err := tmp.Close(); err == nil {
+		err = close_err
+	}
+	if err == nil {
+		err = os.Chmod(tmp_name, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp_name, file)
+	}
+	if err != nil {
+		os.Remove(tmp_name)
+		return err
+	}
+	return nil
+}
+
+// 读取工作空间文件，加密文件自动解密，未加密的文件原样返回
+func readSecureFile(opt meta.Option, file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSecureData(data) {
+		return data, nil
+	}
+
+	provider, err := CreateKeyProvider(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptData(provider, data)
+}
+
+// 加密文件需要按路径读取时，解密到临时文件，返回临时文件路径和清理函数
+func readSecureFileToTemp(opt meta.Option, file string) (string, func(), error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", func() {}, err
+	}
+	if !isSecureData(data) {
+		return file, func() {}, nil
+	}
+
+	data, err = readSecureFile(opt, file)
+	if err != nil {
+		return "", func() {}, err
+	}
+
+	//临时文件放在工作空间中只有当前用户可以访问的目录，不放在系统共用的临时目录
+	tmp_dir := GetTempPath(opt)
+	err = os.MkdirAll(tmp_dir, secure_dir_perm)
+	if err != nil {
+		return "", func() {}, err
+	}
+	tmp, err := os.CreateTemp(tmp_dir, "chatflow_*"+path.Ext(file))
+	if err != nil {
+		return "", func() {}, err
+	}
+	defer tmp.Close()
+
+	cleanup := func() {
+		os.Remove(tmp.Name())
+	}
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+
+	return tmp.Name(), cleanup, nil
+}
+
+// 用当前密钥重新写入工作空间所有文件，未加密的文件会被加密，同时收紧文件权限
+func EncryptWorkspace(opt meta.Option) (int, error) {
+	count := 0
+	if len(opt.WorkspacePath) == 0 {
+		return count, errors.New("工作空间路径未配置")
+	}
+
+	keyfile := ""
+	if len(opt.EncryptKeyFile) > 0 {
+		keyfile, _ = filepath.Abs(opt.EncryptKeyFile)
+	}
+	tmp_dir, _ := filepath.Abs(GetTempPath(opt))
+
+	err := filepath.WalkDir(opt.WorkspacePath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			//解密的临时文件只在使用期间存在，不重新加密
+			if abs, _ := filepath.Abs(p); abs == tmp_dir {
+				return fs.SkipDir
+			}
+			return os.Chmod(p, secure_dir_perm)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if abs, _ := filepath.Abs(p); len(keyfile) > 0 && abs == keyfile {
+			return nil
+		}
+
+		data, err := readSecureFile(opt, p)
+		if err != nil {
+			return err
+		}
+		err = writeSecureFile(opt, p, data)
+		if err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+
+	return count, err
+}
+
+// 轮换主密钥，并用新密钥重新加密工作空间
+func RotateWorkspaceKey(opt meta.Option) (string, error) {
+	provider, err := CreateKeyProvider(opt)
+	if err != nil {
+		return "", err
+	}
+	if provider == nil {
+		return "", errors.New("加密方式未配置")
+	}
+
+	rotator, ok := provider.(KeyRotator)
+	if !ok {
+		return "", errors.New("加密方式不支持密钥轮换")
+	}
+
+	kid, err := rotator.RotateKey()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = EncryptWorkspace(opt)
+	return kid, err
+}
+
+// 本地密钥文件
+type keyfileKey struct {
+	Id         string `json:"id"`
+	Key        string `json:"key"`         //base64
+	CreateTime int64  `json:"create_time"` //毫秒
+}
+
+type keyfileData struct {
+	Active string        `json:"active"`
+	Keys   []*keyfileKey `json:"keys"`
+}
+
+// 本地密钥文件提供者，工作空间还没有加密文件时自动生成，旧密钥保留用于解密
+type KeyfileProvider struct {
+	File      string
+	Workspace string //工作空间路径，用于检查是否已经有加密文件
+
+	lock sync.Mutex
+	data *keyfileData
+}
+
+var keyfile_providers = make(map[string]*KeyfileProvider)
+var keyfile_providers_lock sync.Mutex
+
+func GetKeyfileProvider(file string, workspace string) *KeyfileProvider {
+	keyfile_providers_lock.Lock()
+	defer keyfile_providers_lock.Unlock()
+
+	p := keyfile_providers[file]
+	if p == nil {
+		p = &KeyfileProvider{File: file, Workspace: workspace}
+		keyfile_providers[file] = p
+	}
+	return p
+}
+
+// 工作空间中是否已经有加密文件，只读取文件头
+func hasSecureFiles(workspace string) (bool, error) {
+	if len(workspace) == 0 {
+		return false, nil
+	}
+	found := false
+	err := filepath.WalkDir(workspace, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if found {
+			return fs.SkipAll
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return nil
+		}
+		head := make([]byte, len(secure_file_magic))
+		n, _ := io.ReadFull(f, head)
+		f.Close()
+		if isSecureData(head[:n]) {
+			found = true
+			return fs.SkipAll
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (p *KeyfileProvider) load() (*keyfileData, error) {
+	if p.data != nil {
+		return p.data, nil
+	}
+
+	data, err := os.ReadFile(p.File)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		//密钥文件路径错误或者文件丢失时不能生成新密钥，否则已有的加密文件无法读取
+		exists, err := hasSecureFiles(p.Workspace)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, errors.New("密钥文件不存在，工作空间中已有加密文件: " + p.File)
+		}
+		_, kd, err := p.addKey(&keyfileData{Keys: make([]*keyfileKey, 0)})
+		if err != nil {
+			return nil, err
+		}
+		p.data = kd
+		return kd, nil
+	}
+
+	var kd keyfileData
+	err = json.Unmarshal(data, &kd)
+	if err != nil {
+		return nil, err
+	}
+	p.data = &kd
+	return p.data, nil
+}
+
+// 生成新密钥，保存成功后才返回新的密钥数据，原来的数据不修改
+func (p *KeyfileProvider) addKey(kd *keyfileData) (string, *keyfileData, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", nil, err
+	}
+
+	uid, _ := uuid.NewV4()
+	kid := strings.ReplaceAll(uid.String(), "-", "")
+
+	nkd := &keyfileData{Active: kid, Keys: append([]*keyfileKey{}, kd.Keys...)}
+	nkd.Keys = append(nkd.Keys, &keyfileKey{Id: kid, Key: base64.StdEncoding.EncodeToString(key), CreateTime: time.Now().UnixNano() / 1e6})
+
+	data, err := json.MarshalIndent(nkd, "", "\t")
+	if err != nil {
+		return "", nil, err
+	}
+
+	err = os.MkdirAll(path.Dir(p.File), secure_dir_perm)
+	if err != nil {
+		return "", nil, err
+	}
+	err = writeFileAtomic(p.File, data, secure_file_perm)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return kid, nkd, nil
+}
+
+func (p *KeyfileProvider) ActiveKey() (string, []byte, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	kd, err := p.load()
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, k := range kd.Keys {
+		if k.Id == kd.Active {
+			key, err := base64.StdEncoding.DecodeString(k.Key)
+			return k.Id, key, err
+		}
+	}
+	return "", nil, errors.New("当前密钥不存在")
+}
+
+func (p *KeyfileProvider) GetKey(id string) ([]byte, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	kd, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range kd.Keys {
+		if k.Id == id {
+			return base64.StdEncoding.DecodeString(k.Key)
+		}
+	}
+	return nil, errors.New("密钥不存在: " + id)
+}
+
+// 生成新密钥并设置为当前密钥
+func (p *KeyfileProvider) RotateKey() (string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	kd, err := p.load()
+	if err != nil {
+		return "", err
+	}
+
+	kid, nkd, err := p.addKey(kd)
+	if err != nil {
+		return "", err
+	}
+	p.data = nkd
+	return kid, nil
+}
@@ -0,0 +1,399 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zone-7/chatflow_engine/engine/meta"
+)
+
+var retention_file_name_rules = "rules.json"
+var retention_file_name_audit = "audit.log"
+
+// 正在运行的清理任务，按工作空间区分
+var retention_janitors = make(map[string]chan bool)
+var retention_janitors_lock sync.Mutex
+
+type SessionRetentionManager struct {
+	Opt meta.Option
+
+	// 返回true表示跳过该会话，例如正在内存中使用的会话
+	SkipSession func(user_id string, flow_code string, session_id string) bool
+}
+
+func NewSessionRetentionManager(opt meta.Option) SessionRetentionManager {
+	return SessionRetentionManager{Opt: opt}
+}
+
+func (m *SessionRetentionManager) GetRetentionDir() string {
+	return GetRetentionPath(m.Opt)
+}
+
+// 加载保留策略
+func (m *SessionRetentionManager) LoadRetentionRules() ([]*meta.SessionRetentionRule, error) {
+	rules := make([]*meta.SessionRetentionRule, 0)
+
+	file := path.Join(m.GetRetentionDir(), retention_file_name_rules)
+	data, err := readSecureFile(m.Opt, file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return rules, nil
+		}
+		return rules, err
+	}
+
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// 保存保留策略
+func (m *SessionRetentionManager) StoreRetentionRules(rules []*meta.SessionRetentionRule) error {
+	if rules == nil {
+		return errors.New("rules empty")
+	}
+
+	data, err := json.MarshalIndent(rules, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	dir := m.GetRetentionDir()
+	err = os.MkdirAll(dir, secure_dir_perm)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return err
+	}
+
+	file := path.Join(dir, retention_file_name_rules)
+	err = writeSecureFile(m.Opt, file, data)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// 获取流程对应的策略，没有单独配置就使用默认策略
+func (m *SessionRetentionManager) GetRetentionRule(rules []*meta.SessionRetentionRule, flow_code string) *meta.SessionRetentionRule {
+	var defaultRule *meta.SessionRetentionRule
+	for _, rule := range rules {
+		if rule == nil {
+			continue
+		}
+		if rule.FlowCode == flow_code {
+			return rule
+		}
+		if rule.FlowCode == "" || rule.FlowCode == "*" {
+			defaultRule = rule
+		}
+	}
+	return defaultRule
+}
+
+// 按照保留策略清理会话，dry_run为true时只生成报告
+func (m *SessionRetentionManager) PurgeSessions(dry_run bool) (*meta.SessionPurgeReport, error) {
+	report := &meta.SessionPurgeReport{DryRun: dry_run, Items: make([]*meta.SessionPurgeItem, 0)}
+	report.BeginTime = time.Now().UnixNano() / 1e6
+
+	rules, err := m.LoadRetentionRules()
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		report.EndTime = time.Now().UnixNano() / 1e6
+		return report, nil
+	}
+
+	dir := GetSessionPath(m.Opt)
+	userDirEntrys, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			report.EndTime = time.Now().UnixNano() / 1e6
+			return report, nil
+		}
+		return nil, err
+	}
+
+	now := time.Now().UnixNano() / 1e6
+
+	for _, userDirEntry := range userDirEntrys {
+		if !userDirEntry.IsDir() {
+			continue
+		}
+		user_id := userDirEntry.Name()
+		userDir := path.Join(dir, user_id)
+
+		userFlowDirEntrys, err := os.ReadDir(userDir)
+		if err != nil {
+			continue
+		}
+
+		for _, userFlowDirEntry := range userFlowDirEntrys {
+			if !userFlowDirEntry.IsDir() {
+				continue
+			}
+			flow_code := userFlowDirEntry.Name()
+
+			rule := m.GetRetentionRule(rules, flow_code)
+			if rule == nil || (rule.MaxAge <= 0 && rule.MaxSessionsPerUser <= 0) {
+				continue
+			}
+
+			items := m.getPurgeItems(rule, user_id, flow_code, now)
+			report.Items = append(report.Items, items...)
+		}
+	}
+
+	if !dry_run {
+		for _, item := range report.Items {
+			err := m.purgeSession(item)
+			if err != nil {
+				item.Error = err.Error()
+			}
+		}
+	}
+
+	report.EndTime = time.Now().UnixNano() / 1e6
+
+	if !dry_run && len(report.Items) > 0 {
+		err = m.appendAudit(report)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// 计算用户某个流程下需要清理的会话
+func (m *SessionRetentionManager) getPurgeItems(rule *meta.SessionRetentionRule, user_id string, flow_code string, now int64) []*meta.SessionPurgeItem {
+	items := make([]*meta.SessionPurgeItem, 0)
+
+	flowDir := path.Join(GetSessionPath(m.Opt), user_id, flow_code)
+	sessionDirEntrys, err := os.ReadDir(flowDir)
+	if err != nil {
+		return items
+	}
+
+	type sessionActive struct {
+		id         string
+		activeTime int64
+		hasData    bool
+	}
+
+	sessions := make([]*sessionActive, 0)
+	for _, sessionDirEntry := range sessionDirEntrys {
+		if !sessionDirEntry.IsDir() {
+			continue
+		}
+		session_id := sessionDirEntry.Name()
+		if m.SkipSession != nil && m.SkipSession(user_id, flow_code, session_id) {
+			continue
+		}
+
+		activeTime, hasData := m.getSessionActiveTime(path.Join(flowDir, session_id))
+		sessions = append(sessions, &sessionActive{id: session_id, activeTime: activeTime, hasData: hasData})
+	}
+
+	//最近活动的会话排在前面
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].activeTime > sessions[j].activeTime
+	})
+
+	action := meta.SESSION_PURGE_ACTION_REMOVE
+	if rule.KeepInfo {
+		action = meta.SESSION_PURGE_ACTION_DROP_RUNTIME
+	}
+
+	for i, sa := range sessions {
+		reason := ""
+		if rule.MaxAge > 0 && now-sa.activeTime > rule.MaxAge {
+			reason = meta.SESSION_PURGE_REASON_MAX_AGE
+		} else if rule.MaxSessionsPerUser > 0 && i >= rule.MaxSessionsPerUser {
+			reason = meta.SESSION_PURGE_REASON_MAX_SESSIONS
+		}
+		if len(reason) == 0 {
+			continue
+		}
+
+		//已经只剩会话信息的不再重复清理
+		if action == meta.SESSION_PURGE_ACTION_DROP_RUNTIME && !sa.hasData {
+			continue
+		}
+
+		items = append(items, &meta.SessionPurgeItem{UserId: user_id, FlowCode: flow_code, SessionId: sa.id, Action: action, Reason: reason, ActiveTime: sa.activeTime})
+	}
+
+	return items
+}
+
+// 会话最后活动时间取会话文件的最后修改时间（毫秒），以及是否还有运行状态或消息数据
+func (m *SessionRetentionManager) getSessionActiveTime(sessionDir string) (int64, bool) {
+	var activeTime int64
+	hasData := false
+
+	entrys, err := os.ReadDir(sessionDir)
+	if err != nil {
+		return activeTime, hasData
+	}
+
+	for _, entry := range entrys {
+		if entry.Name() != "info.json" {
+			hasData = true
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		t := fi.ModTime().UnixNano() / 1e6
+		if t > activeTime {
+			activeTime = t
+		}
+	}
+
+	return activeTime, hasData
+}
+
+// 执行清理
+func (m *SessionRetentionManager) purgeSession(item *meta.SessionPurgeItem) error {
+	sessionDir := path.Join(GetSessionPath(m.Opt), item.UserId, item.FlowCode, item.SessionId)
+
+	if item.Action == meta.SESSION_PURGE_ACTION_REMOVE {
+		return os.RemoveAll(sessionDir)
+	}
+
+	entrys, err := os.ReadDir(sessionDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entrys {
+		if entry.Name() == "info.json" {
+			continue
+		}
+		err = os.RemoveAll(path.Join(sessionDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 记录清理审计日志，每次清理一行
+func (m *SessionRetentionManager) appendAudit(report *meta.SessionPurgeReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	dir := m.GetRetentionDir()
+	err = os.MkdirAll(dir, secure_dir_perm)
+	if err != nil {
+		return err
+	}
+
+	//加密文件不能直接追加，读取后整体重写
+	file := path.Join(dir, retention_file_name_audit)
+	old, err := readSecureFile(m.Opt, file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return writeSecureFile(m.Opt, file, append(append(old, data...), '\n'))
+}
+
+// 加载清理审计记录，最新的排在前面，limit为0表示全部
+func (m *SessionRetentionManager) LoadRetentionAudits(limit int) ([]*meta.SessionPurgeReport, error) {
+	reports := make([]*meta.SessionPurgeReport, 0)
+
+	data, err := readSecureFile(m.Opt, path.Join(m.GetRetentionDir(), retention_file_name_audit))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return reports, nil
+		}
+		return reports, err
+	}
+
+	lines := strings.Split(string(data), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if limit > 0 && len(reports) >= limit {
+			break
+		}
+		line := strings.Trim(lines[i], " \r")
+		if len(line) == 0 {
+			continue
+		}
+		var report meta.SessionPurgeReport
+		err = json.Unmarshal([]byte(line), &report)
+		if err != nil {
+			continue
+		}
+		reports = append(reports, &report)
+	}
+
+	return reports, nil
+}
+
+// 启动后台清理任务，按间隔执行会话清理
+func StartRetentionJanitor(opt meta.Option, interval time.Duration, skip func(user_id string, flow_code string, session_id string) bool) {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	retention_janitors_lock.Lock()
+	defer retention_janitors_lock.Unlock()
+
+	if _, ok := retention_janitors[opt.WorkspacePath]; ok {
+		return
+	}
+
+	stop := make(chan bool)
+	retention_janitors[opt.WorkspacePath] = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				m := NewSessionRetentionManager(opt)
+				m.SkipSession = skip
+				report, err := m.PurgeSessions(false)
+				if err != nil {
+					fmt.Printf("session purge: %v\n", err)
+					continue
+				}
+				if len(report.Items) > 0 {
+					fmt.Println("session purge: ", len(report.Items))
+				}
+			}
+		}
+	}()
+}
+
+// 停止后台清理任务
+func StopRetentionJanitor(opt meta.Option) {
+	retention_janitors_lock.Lock()
+	defer retention_janitors_lock.Unlock()
+
+	stop, ok := retention_janitors[opt.WorkspacePath]
+	if !ok {
+		return
+	}
+	close(stop)
+	delete(retention_janitors, opt.WorkspacePath)
+}
@@ -0,0 +1,362 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zone-7/chatflow_engine/engine/meta"
+)
+
+// 会话没有处理到期事件时，间隔多久重新触发（毫秒）
+var wait_refire_interval int64 = 60000
+
+// 最多触发次数，超过后删除调度，避免会话已经删除或者重置后一直触发
+var wait_max_fire_count = 3
+
+// 正在运行的等待调度，按工作空间区分
+var wait_schedulers = make(map[string]chan bool)
+var wait_schedulers_lock sync.Mutex
+
+// 同一个调度文件的读写
+var wait_file_lock sync.Mutex
+
+// 等待调度索引，按恢复时间排序，按工作空间区分
+// 第一次使用时从文件加载，之后保存和删除调度时同步更新，调度任务不需要反复读取和解密调度文件
+type waitScheduleIndex struct {
+	lock      sync.Mutex
+	loaded    bool
+	schedules []*meta.WaitSchedule
+}
+
+var wait_indexes = make(map[string]*waitScheduleIndex)
+var wait_indexes_lock sync.Mutex
+
+func getWaitScheduleIndex(workspace string) *waitScheduleIndex {
+	wait_indexes_lock.Lock()
+	defer wait_indexes_lock.Unlock()
+
+	index, ok := wait_indexes[workspace]
+	if !ok {
+		index = &waitScheduleIndex{schedules: make([]*meta.WaitSchedule, 0)}
+		wait_indexes[workspace] = index
+	}
+	return index
+}
+
+// 按恢复时间插入，相同ID替换，索引还没有加载时不处理
+func (index *waitScheduleIndex) putLocked(schedule *meta.WaitSchedule) {
+	if !index.loaded {
+		return
+	}
+	index.removeLocked(schedule.Id)
+	item := *schedule
+	i := sort.Search(len(index.schedules), func(i int) bool {
+		return index.schedules[i].ResumeTime > item.ResumeTime
+	})
+	index.schedules = append(index.schedules, nil)
+	copy(index.schedules[i+1:], index.schedules[i:])
+	index.schedules[i] = &item
+}
+
+func (index *waitScheduleIndex) removeLocked(id string) {
+	for i, item := range index.schedules {
+		if item.Id == id {
+			index.schedules = append(index.schedules[:i], index.schedules[i+1:]...)
+			return
+		}
+	}
+}
+
+type WaitScheduleManager struct {
+	Opt meta.Option
+}
+
+func NewWaitScheduleManager(opt meta.Option) WaitScheduleManager {
+	return WaitScheduleManager{Opt: opt}
+}
+
+func (m *WaitScheduleManager) GetWaitDir() string {
+	return GetWaitPath(m.Opt)
+}
+
+// 等待调度ID，每个会话的每个节点最多一个
+func GetWaitScheduleId(session_id string, action_id string) string {
+	return session_id + "_" + action_id
+}
+
+func (m *WaitScheduleManager) getWaitFile(id string) (string, error) {
+	if len(id) == 0 || strings.ContainsAny(id, "/\\") || strings.Contains(id, "..") {
+		return "", errors.New("等待调度ID格式错误: " + id)
+	}
+	return path.Join(m.GetWaitDir(), id+".json"), nil
+}
+
+// 保存等待调度，相同ID覆盖
+func (m *WaitScheduleManager) StoreWaitSchedule(schedule *meta.WaitSchedule) error {
+	if schedule == nil {
+		return errors.New("schedule empty")
+	}
+	file, err := m.getWaitFile(schedule.Id)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(schedule, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(m.GetWaitDir(), secure_dir_perm)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return err
+	}
+
+	//文件和索引一起修改
+	index := getWaitScheduleIndex(m.Opt.WorkspacePath)
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	wait_file_lock.Lock()
+	err = writeSecureFile(m.Opt, file, data)
+	wait_file_lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	index.putLocked(schedule)
+	return nil
+}
+
+// 加载等待调度，不存在时返回nil
+func (m *WaitScheduleManager) LoadWaitSchedule(id string) (*meta.WaitSchedule, error) {
+	file, err := m.getWaitFile(id)
+	if err != nil {
+		return nil, err
+	}
+
+	wait_file_lock.Lock()
+	data, err := readSecureFile(m.Opt, file)
+	wait_file_lock.Unlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var schedule meta.WaitSchedule
+	err = json.Unmarshal(data, &schedule)
+	if err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// 删除等待调度，不存在时不报错
+func (m *WaitScheduleManager) RemoveWaitSchedule(id string) error {
+	file, err := m.getWaitFile(id)
+	if err != nil {
+		return err
+	}
+
+	index := getWaitScheduleIndex(m.Opt.WorkspacePath)
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	wait_file_lock.Lock()
+	err = os.Remove(file)
+	wait_file_lock.Unlock()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	index.removeLocked(id)
+	return nil
+}
+
+// 加载所有等待调度，按恢复时间排序
+func (m *WaitScheduleManager) LoadWaitSchedules() ([]*meta.WaitSchedule, error) {
+	schedules := make([]*meta.WaitSchedule, 0)
+
+	entries, err := os.ReadDir(m.GetWaitDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return schedules, nil
+		}
+		return schedules, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), ".json")
+		schedule, err := m.LoadWaitSchedule(id)
+		if err != nil {
+			fmt.Printf("wait schedule %s: %v\n", id, err)
+			continue
+		}
+		if schedule == nil {
+			continue
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].ResumeTime < schedules[j].ResumeTime
+	})
+
+	return schedules, nil
+}
+
+// 从索引中获取恢复时间不晚于 before 的等待调度，按恢复时间排序，索引没有加载时从文件加载
+func (m *WaitScheduleManager) getIndexedWaitSchedules(before int64) ([]*meta.WaitSchedule, error) {
+	index := getWaitScheduleIndex(m.Opt.WorkspacePath)
+
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	if !index.loaded {
+		schedules, err := m.LoadWaitSchedules()
+		if err != nil {
+			return nil, err
+		}
+		index.schedules = schedules
+		index.loaded = true
+	}
+
+	list := make([]*meta.WaitSchedule, 0)
+	for _, item := range index.schedules {
+		if item.ResumeTime > before {
+			break
+		}
+		schedule := *item
+		list = append(list, &schedule)
+	}
+	return list, nil
+}
+
+// 删除会话的所有等待调度，会话重置或者删除时使用
+func (m *WaitScheduleManager) RemoveSessionWaitSchedules(session_id string) error {
+	schedules, err := m.getIndexedWaitSchedules(math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	for _, schedule := range schedules {
+		if schedule.SessionId != session_id {
+			continue
+		}
+		err = m.RemoveWaitSchedule(schedule.Id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 到期需要触发的等待调度，已经触发过的间隔一段时间再重新触发
+func (m *WaitScheduleManager) LoadDueWaitSchedules(now int64) ([]*meta.WaitSchedule, error) {
+	schedules, err := m.getIndexedWaitSchedules(now)
+	if err != nil {
+		return nil, err
+	}
+
+	due := make([]*meta.WaitSchedule, 0)
+	for _, schedule := range schedules {
+		if schedule.FireTime > 0 && now-schedule.FireTime < wait_refire_interval {
+			continue
+		}
+		due = append(due, schedule)
+	}
+	return due, nil
+}
+
+// 触发到期的等待调度，fire 返回false表示会话暂时不能处理，下次再触发
+func (m *WaitScheduleManager) FireWaitSchedules(fire func(schedule *meta.WaitSchedule) bool) error {
+	now := time.Now().UnixNano() / 1e6
+
+	schedules, err := m.LoadDueWaitSchedules(now)
+	if err != nil {
+		return err
+	}
+
+	for _, schedule := range schedules {
+		if schedule.FireCount >= wait_max_fire_count {
+			fmt.Println("wait schedule expired: ", schedule.Id)
+			m.RemoveWaitSchedule(schedule.Id)
+			continue
+		}
+		//先记录触发，由等待节点处理后删除，没有处理时按间隔重新触发
+		fired := *schedule
+		fired.FireTime = now
+		fired.FireCount++
+		err = m.StoreWaitSchedule(&fired)
+		if err != nil {
+			fmt.Printf("wait schedule %s: %v\n", schedule.Id, err)
+			continue
+		}
+
+		if !fire(&fired) {
+			//会话暂时不能处理，恢复触发记录
+			m.StoreWaitSchedule(schedule)
+		}
+	}
+	return nil
+}
+
+// 启动等待调度，按间隔检查到期的等待并触发
+func StartWaitScheduler(opt meta.Option, interval time.Duration, fire func(schedule *meta.WaitSchedule) bool) {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	wait_schedulers_lock.Lock()
+	defer wait_schedulers_lock.Unlock()
+
+	if _, ok := wait_schedulers[opt.WorkspacePath]; ok {
+		return
+	}
+
+	stop := make(chan bool)
+	wait_schedulers[opt.WorkspacePath] = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				m := NewWaitScheduleManager(opt)
+				err := m.FireWaitSchedules(fire)
+				if err != nil {
+					fmt.Printf("wait schedule: %v\n", err)
+				}
+			}
+		}
+	}()
+}
+
+// 停止等待调度
+func StopWaitScheduler(opt meta.Option) {
+	wait_schedulers_lock.Lock()
+	defer wait_schedulers_lock.Unlock()
+
+	stop, ok := wait_schedulers[opt.WorkspacePath]
+	if !ok {
+		return
+	}
+	close(stop)
+	delete(wait_schedulers, opt.WorkspacePath)
+}
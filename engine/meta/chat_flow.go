@@ -27,13 +27,15 @@ const (
 
 // 消息类型
 const (
-	CHAT_MESSAGE_TYPE_MESSAGE  = "message"
-	CHAT_MESSAGE_TYPE_RUNTIME  = "runtime"
-	CHAT_MESSAGE_TYPE_WAITING  = "waiting"
-	CHAT_MESSAGE_TYPE_COMPLETE = "complete"
-	CHAT_MESSAGE_TYPE_SESSION  = "session"
-	CHAT_MESSAGE_TYPE_SYSTEM   = "system"
-	CHAT_MESSAGE_TYPE_ERROR    = "error"
+	CHAT_MESSAGE_TYPE_MESSAGE        = "message"
+	CHAT_MESSAGE_TYPE_RUNTIME        = "runtime"
+	CHAT_MESSAGE_TYPE_RUNTIME_DETAIL = "runtime_detail" //设计空间给设计者的完整运行状态，不做脱敏
+	CHAT_MESSAGE_TYPE_WAITING        = "waiting"
+	CHAT_MESSAGE_TYPE_COMPLETE       = "complete"
+	CHAT_MESSAGE_TYPE_SESSION        = "session"
+	CHAT_MESSAGE_TYPE_SYSTEM         = "system"
+	CHAT_MESSAGE_TYPE_ERROR          = "error"
+	CHAT_MESSAGE_TYPE_EVENT          = "event" //定时等待到期等内部事件，不记录到消息历史
 
 	CHAT_MESSAGE_ROLE_USER      = "user"
 	CHAT_MESSAGE_ROLE_ASSISTANT = "assistant"
@@ -52,6 +54,13 @@ type ChatFlowFilter struct {
 	IgnoreCase  string `json:"ignore_case"`  //是否忽略大小写
 }
 
+// 运行状态脱敏配置
+type ChatFlowRedact struct {
+	Patterns  []string `json:"patterns"`  //需要脱敏的参数名正则表达式
+	Allowlist []string `json:"allowlist"` //客户端可见的参数名，为空表示除脱敏参数外都可见
+	ShowLogs  bool     `json:"show_logs"` //客户端是否可见运行日志
+}
+
 // 流程参数
 type ChatFlowParam struct {
 	Name        string `json:"name"`        //名称
@@ -100,6 +109,8 @@ type ChatFlow struct {
 	ChatFlowInfo
 	Params    []*ChatFlowParam   `json:"params"`  //参数
 	Filters   []*ChatFlowFilter  `json:"filters"` //过滤器
+	Redact    *ChatFlowRedact    `json:"redact"`  //运行状态脱敏
+	Secrets   []string           `json:"secrets"` //流程可以使用的密钥名称
 	FlowModel *andflow.FlowModel `json:"flow_model"`
 }
 
@@ -9,4 +9,14 @@ type ChatSessionInfo struct {
 	FlowSpace  string `json:"flow_space"`
 	Title      string `json:"title"`
 	CreateTime int64  `json:"create_time"` //毫秒
+
+	Memory *ChatSessionMemory `json:"memory,omitempty"` //会话记忆
+}
+
+// 会话记忆，没有携带给模型的更早消息总结为摘要
+type ChatSessionMemory struct {
+	Summary    string `json:"summary"`     //摘要
+	Until      int64  `json:"until"`       //已经总结到的消息发送时间，毫秒
+	Count      int    `json:"count"`       //已经总结的消息条数
+	UpdateTime int64  `json:"update_time"` //毫秒
 }
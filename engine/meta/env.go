@@ -0,0 +1,9 @@
+package meta
+
+// 环境变量，每个流程空间一套，节点参数中通过 {{env.NAME}} 引用
+type Env struct {
+	Name        string `json:"name"`
+	Value       string `json:"value"`
+	Description string `json:"description"`
+	UpdateTime  int64  `json:"update_time"` //毫秒
+}
@@ -1,5 +1,24 @@
 package meta
 
+const (
+	ENCRYPT_NONE    = ""        //不加密
+	ENCRYPT_KEYFILE = "keyfile" //本地密钥文件
+)
+
 type Option struct {
-	WorkspacePath string `json:"workspace_path" yaml:"workspace_path"`
+	WorkspacePath  string `json:"workspace_path" yaml:"workspace_path"`
+	Encrypt        string `json:"encrypt" yaml:"encrypt"`                   //工作空间文件加密方式，为空不加密
+	EncryptKeyFile string `json:"encrypt_key_file" yaml:"encrypt_key_file"` //本地密钥文件路径
+	TimeZone       string `json:"time_zone" yaml:"time_zone"`               //会话时区，例如 Asia/Shanghai，为空使用服务器时区
+
+	HttpPolicy HttpPolicy `json:"http_policy" yaml:"http_policy"` //流程中发起HTTP请求的限制
+}
+
+// 流程中发起HTTP请求的限制，网络请求节点和脚本共用
+type HttpPolicy struct {
+	AllowHosts  []string `json:"allow_hosts" yaml:"allow_hosts"`     //允许访问的域名，支持 *.example.com，为空不限制
+	DenyHosts   []string `json:"deny_hosts" yaml:"deny_hosts"`       //禁止访问的域名
+	DenyPrivate bool     `json:"deny_private" yaml:"deny_private"`   //禁止访问本机和内网地址
+	Timeout     int      `json:"timeout" yaml:"timeout"`             //默认超时时间，秒
+	MaxBodySize int64    `json:"max_body_size" yaml:"max_body_size"` //最多读取的返回内容，字节
 }
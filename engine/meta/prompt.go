@@ -0,0 +1,51 @@
+package meta
+
+// 引用最新版本
+const PROMPT_VERSION_LATEST = "latest"
+
+// 提示词变量
+type PromptVariable struct {
+	Name         string `json:"name"`
+	Label        string `json:"label"`
+	DefaultValue string `json:"default_value"`
+}
+
+// 提示词版本，保存后不再修改
+type PromptVersion struct {
+	Version     int               `json:"version"`
+	Content     string            `json:"content"` //模版，变量使用 {{name}}
+	Variables   []*PromptVariable `json:"variables"`
+	Description string            `json:"description"` //版本说明
+	CreateTime  int64             `json:"create_time"` //毫秒
+}
+
+// 提示词，节点中通过 name@version 引用
+type Prompt struct {
+	Name        string           `json:"name"`
+	Title       string           `json:"title"`
+	Description string           `json:"description"`
+	Versions    []*PromptVersion `json:"versions"`
+	CreateTime  int64            `json:"create_time"` //毫秒
+	UpdateTime  int64            `json:"update_time"` //毫秒
+}
+
+// 最新版本
+func (p *Prompt) Latest() *PromptVersion {
+	var latest *PromptVersion
+	for _, v := range p.Versions {
+		if latest == nil || v.Version > latest.Version {
+			latest = v
+		}
+	}
+	return latest
+}
+
+// 指定版本
+func (p *Prompt) GetVersion(version int) *PromptVersion {
+	for _, v := range p.Versions {
+		if v.Version == version {
+			return v
+		}
+	}
+	return nil
+}
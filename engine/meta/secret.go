@@ -0,0 +1,10 @@
+package meta
+
+// 密钥，值只在执行时通过 {{secret "name"}} 解析
+type Secret struct {
+	Name        string `json:"name"`
+	Value       string `json:"value,omitempty"`
+	Description string `json:"description"`
+	CreateTime  int64  `json:"create_time"` //毫秒
+	UpdateTime  int64  `json:"update_time"` //毫秒
+}
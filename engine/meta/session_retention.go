@@ -0,0 +1,36 @@
+package meta
+
+const (
+	SESSION_PURGE_ACTION_REMOVE       = "remove"       //删除整个会话
+	SESSION_PURGE_ACTION_DROP_RUNTIME = "drop_runtime" //只保留会话信息，删除运行状态和消息
+
+	SESSION_PURGE_REASON_MAX_AGE      = "max_age"      //超过最长保留时间
+	SESSION_PURGE_REASON_MAX_SESSIONS = "max_sessions" //超过用户最多会话数
+)
+
+// 会话保留策略
+type SessionRetentionRule struct {
+	FlowCode           string `json:"flow_code" yaml:"flow_code"`                         //流程编码，空或*表示默认策略
+	MaxAge             int64  `json:"max_age" yaml:"max_age"`                             //最长保留时间（毫秒），0表示不限制
+	MaxSessionsPerUser int    `json:"max_sessions_per_user" yaml:"max_sessions_per_user"` //每个用户最多保留的会话数，0表示不限制
+	KeepInfo           bool   `json:"keep_info" yaml:"keep_info"`                         //清理时只保留会话信息，删除运行状态和消息
+}
+
+// 被清理的会话
+type SessionPurgeItem struct {
+	UserId     string `json:"user_id"`
+	FlowCode   string `json:"flow_code"`
+	SessionId  string `json:"session_id"`
+	Action     string `json:"action"`      //清理方式
+	Reason     string `json:"reason"`      //清理原因
+	ActiveTime int64  `json:"active_time"` //最后活动时间（毫秒）
+	Error      string `json:"error"`       //清理异常
+}
+
+// 清理报告
+type SessionPurgeReport struct {
+	DryRun    bool                `json:"dry_run"`    //是否只生成报告，不执行删除
+	BeginTime int64               `json:"begin_time"` //毫秒
+	EndTime   int64               `json:"end_time"`   //毫秒
+	Items     []*SessionPurgeItem `json:"items"`
+}
@@ -0,0 +1,23 @@
+package meta
+
+const (
+	WAIT_EVENT_TIMEOUT = "wait_timeout" //等待到期事件，作为事件消息的内容
+
+	WAIT_END_TIMEOUT = "timeout" //等待到期后继续
+	WAIT_END_REPLY   = "reply"   //用户回复后提前继续
+	WAIT_END_CANCEL  = "cancel"  //用户回复后取消等待
+)
+
+// 等待中的会话，到恢复时间后由调度任务继续执行
+type WaitSchedule struct {
+	Id         string `json:"id"`          //会话ID_节点ID
+	UserId     string `json:"user_id"`     //用户ID
+	FlowCode   string `json:"flow_code"`   //流程编码
+	FlowSpace  string `json:"flow_space"`  //流程空间
+	SessionId  string `json:"session_id"`  //会话ID
+	ActionId   string `json:"action_id"`   //等待节点ID
+	ResumeTime int64  `json:"resume_time"` //恢复执行时间（毫秒）
+	CreateTime int64  `json:"create_time"` //创建时间（毫秒）
+	FireTime   int64  `json:"fire_time"`   //最近一次触发时间（毫秒），0表示还没有触发
+	FireCount  int    `json:"fire_count"`  //已经触发的次数，会话没有处理时会重新触发
+}
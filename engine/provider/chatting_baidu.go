@@ -9,8 +9,7 @@ import (
 )
 
 func init() {
-	c := Chatting_baidu{}
-	chattings = append(chattings, c.GetDict().Name)
+	RegistChatting(func() Chatting { return &Chatting_baidu{} })
 }
 
 type Chatting_baidu struct {
@@ -52,6 +51,53 @@ func (c *Chatting_baidu) GetDict() Dict {
 	return dict
 }
 
+// 默认参数，api_key 和 secret_key 不能为空
+func (c *Chatting_baidu) Prepare(params map[string]string) error {
+	if len(params["api_key"]) == 0 {
+		return errors.New("参数 api_key 不能为空")
+	}
+	if len(params["secret_key"]) == 0 {
+		return errors.New("参数 secret_key 不能为空")
+	}
+	if len(params["service"]) == 0 {
+		params["service"] = "completions"
+	}
+	return nil
+}
+
+// 文心一言不支持系统消息，系统消息合并到第一条用户消息；
+// 用户和助手的消息必须交替出现，并且以用户消息结束，缺少的消息用“？”补齐
+func ernieMessages(messages []ChatMessage) []baidu.ErnieMessage {
+	system := ""
+	list := make([]baidu.ErnieMessage, 0)
+	for _, m := range messages {
+		if m.Role == MESSAGE_ROLE_SYSTEM {
+			if len(m.Content) > 0 {
+				system += m.Content + "\n"
+			}
+			continue
+		}
+		role := MESSAGE_ROLE_USER
+		if m.Role == MESSAGE_ROLE_ASSISTANT {
+			role = MESSAGE_ROLE_ASSISTANT
+		}
+		if len(list)%2 == 0 && role == MESSAGE_ROLE_ASSISTANT {
+			list = append(list, baidu.ErnieMessage{Role: MESSAGE_ROLE_USER, Content: "？"})
+		}
+		if len(list)%2 != 0 && role == MESSAGE_ROLE_USER {
+			list = append(list, baidu.ErnieMessage{Role: MESSAGE_ROLE_ASSISTANT, Content: "？"})
+		}
+		list = append(list, baidu.ErnieMessage{Role: role, Content: m.Content})
+	}
+	if len(list)%2 == 0 {
+		list = append(list, baidu.ErnieMessage{Role: MESSAGE_ROLE_USER, Content: "？"})
+	}
+	if len(system) > 0 {
+		list[0].Content = system + list[0].Content
+	}
+	return list
+}
+
 func (c *Chatting_baidu) Chat(params map[string]string, messages []ChatMessage, callback func(msg []ChatMessage, is_done bool) error, is_suspend func() bool) error {
 	var err error
 
@@ -123,11 +169,7 @@ func (c *Chatting_baidu) Chat(params map[string]string, messages []ChatMessage,
 	}
 
 	request := baidu.ErnieRequest{}
-	request.Messages = make([]baidu.ErnieMessage, 0)
-
-	for _, m := range messages {
-		request.Messages = append(request.Messages, baidu.ErnieMessage{Role: m.Role, Content: m.Content})
-	}
+	request.Messages = ernieMessages(messages)
 
 	request.Stream = c.Stream
 
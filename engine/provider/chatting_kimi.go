@@ -3,15 +3,14 @@ package provider
 import (
 	"errors"
 	"log"
+	"strings"
 
 	"github.com/zone-7/chatflow_engine/engine/provider/openai"
 	"github.com/zone-7/chatflow_engine/engine/utils"
 )
 
 func init() {
-	c := Chatting_kimi{}
-
-	chattings = append(chattings, c.GetDict().Name)
+	RegistChatting(func() Chatting { return &Chatting_kimi{} })
 }
 
 type Chatting_kimi struct {
@@ -25,6 +24,8 @@ type Chatting_kimi struct {
 	N           int     `json:"n" yaml:"n"`
 	User        string  `json:"user" yaml:"user"`
 	Timeout     int64   `json:"timeout" yaml:"timeout"`
+
+	ResponseFormat string `json:"response_format" yaml:"response_format"` //json_object 使用JSON模式
 }
 
 func (c *Chatting_kimi) GetDict() Dict {
@@ -33,6 +34,32 @@ func (c *Chatting_kimi) GetDict() Dict {
 	return dict
 }
 
+// 默认参数，地址不能为空
+func (c *Chatting_kimi) Prepare(params map[string]string) error {
+	if len(params["url"]) == 0 {
+		return errors.New("参数 URL 地址不能为空")
+	}
+	if !strings.Contains(params["url"], "/chat/completions") {
+		params["url"] = params["url"] + "/chat/completions"
+	}
+	if len(params["model"]) == 0 {
+		params["model"] = "moonshot-v1-8k"
+	}
+	if len(params["max_tokens"]) == 0 {
+		params["max_tokens"] = "1024"
+	}
+	if len(params["n"]) == 0 {
+		params["n"] = "1"
+	}
+	if len(params["top_p"]) == 0 {
+		params["top_p"] = "1"
+	}
+	if len(params["temperature"]) == 0 {
+		params["temperature"] = "0.5"
+	}
+	return nil
+}
+
 func (c *Chatting_kimi) Chat(params map[string]string, messages []ChatMessage, callback func(msg []ChatMessage, is_done bool) error, is_suspend func() bool) error {
 	var err error
 
@@ -79,6 +106,10 @@ func (c *Chatting_kimi) Chat(params map[string]string, messages []ChatMessage, c
 			c.Timeout, _ = utils.StringToInt64(v)
 		}
 
+		if k == "response_format" {
+			c.ResponseFormat = v
+		}
+
 	}
 
 	request := openai.ChatRequest{}
@@ -95,6 +126,9 @@ func (c *Chatting_kimi) Chat(params map[string]string, messages []ChatMessage, c
 	request.Temperature = c.Temperature
 	request.N = c.N
 	request.User = c.User
+	if len(c.ResponseFormat) > 0 {
+		request.ResponseFormat = &openai.ChatResponseFormat{Type: c.ResponseFormat}
+	}
 
 	header := make(map[string]string)
 	header["Authorization"] = "Bearer " + c.ApiKey
@@ -140,3 +174,9 @@ func (c *Chatting_kimi) Chat(params map[string]string, messages []ChatMessage, c
 
 	return err
 }
+
+// 工具调用对话，接口与openai兼容
+func (c *Chatting_kimi) ChatTools(params map[string]string, messages []ChatMessage, tools []ChatTool, tool_choice string) (*ChatMessage, error) {
+	chatting := Chatting_openai(*c)
+	return chatting.ChatTools(params, messages, tools, tool_choice)
+}
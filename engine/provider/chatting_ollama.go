@@ -1,13 +1,15 @@
 package provider
 
 import (
+	"errors"
+	"strings"
+
 	"github.com/zone-7/chatflow_engine/engine/provider/ollama"
 	"github.com/zone-7/chatflow_engine/engine/utils"
 )
 
 func init() {
-	c := Chatting_ollama{}
-	chattings = append(chattings, c.GetDict().Name)
+	RegistChatting(func() Chatting { return &Chatting_ollama{} })
 }
 
 type Chatting_ollama struct {
@@ -19,6 +21,7 @@ type Chatting_ollama struct {
 	TopP        int     `json:"top_p" yaml:"top_p"`
 	Timeout     int64   `json:"timeout" yaml:"timeout"`
 	KeepAlive   string  `json:"keep_alive" yaml:"keep_alive"`
+	Format      string  `json:"format" yaml:"format"`
 }
 
 func (c *Chatting_ollama) GetDict() Dict {
@@ -27,6 +30,17 @@ func (c *Chatting_ollama) GetDict() Dict {
 	return dict
 }
 
+// 默认参数，地址不能为空
+func (c *Chatting_ollama) Prepare(params map[string]string) error {
+	if len(params["url"]) == 0 {
+		return errors.New("参数 URL 地址不能为空")
+	}
+	if !strings.Contains(params["url"], "/api/chat") {
+		params["url"] = params["url"] + "/api/chat"
+	}
+	return nil
+}
+
 func (c *Chatting_ollama) Chat(params map[string]string, messages []ChatMessage, callback func(msg []ChatMessage, is_done bool) error, is_suspend func() bool) error {
 	var err error
 
@@ -48,6 +62,9 @@ func (c *Chatting_ollama) Chat(params map[string]string, messages []ChatMessage,
 		if k == "keep_alive" {
 			c.KeepAlive = v
 		}
+		if k == "format" {
+			c.Format = v
+		}
 
 		if k == "temperature" {
 			c.Temperature, _ = utils.StringToFloat64(v)
@@ -76,6 +93,7 @@ func (c *Chatting_ollama) Chat(params map[string]string, messages []ChatMessage,
 	request.Model = c.Model
 	request.Stream = c.Stream
 	request.KeepAlive = c.KeepAlive
+	request.Format = c.Format
 	request.Options.Seed = c.Seed
 	request.Options.Temperature = c.Temperature
 	request.Options.TopP = c.TopP
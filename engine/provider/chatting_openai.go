@@ -3,14 +3,14 @@ package provider
 import (
 	"errors"
 	"log"
+	"strings"
 
 	"github.com/zone-7/chatflow_engine/engine/provider/openai"
 	"github.com/zone-7/chatflow_engine/engine/utils"
 )
 
 func init() {
-	c := Chatting_openai{}
-	chattings = append(chattings, c.GetDict().Name)
+	RegistChatting(func() Chatting { return &Chatting_openai{} })
 }
 
 type Chatting_openai struct {
@@ -24,6 +24,8 @@ type Chatting_openai struct {
 	N           int     `json:"n" yaml:"n"`
 	User        string  `json:"user" yaml:"user"`
 	Timeout     int64   `json:"timeout" yaml:"timeout"`
+
+	ResponseFormat string `json:"response_format" yaml:"response_format"` //json_object 使用JSON模式
 }
 
 func (c *Chatting_openai) GetDict() Dict {
@@ -32,9 +34,33 @@ func (c *Chatting_openai) GetDict() Dict {
 	return dict
 }
 
-func (c *Chatting_openai) Chat(params map[string]string, messages []ChatMessage, callback func(msg []ChatMessage, is_done bool) error, is_suspend func() bool) error {
-	var err error
+// 默认参数，地址不能为空
+func (c *Chatting_openai) Prepare(params map[string]string) error {
+	if len(params["url"]) == 0 {
+		return errors.New("参数 URL 地址不能为空")
+	}
+	if !strings.Contains(params["url"], "/chat/completions") {
+		params["url"] = params["url"] + "/chat/completions"
+	}
+	if len(params["model"]) == 0 {
+		params["model"] = "gpt-4"
+	}
+	if len(params["max_tokens"]) == 0 {
+		params["max_tokens"] = "1024"
+	}
+	if len(params["n"]) == 0 {
+		params["n"] = "1"
+	}
+	if len(params["top_p"]) == 0 {
+		params["top_p"] = "1"
+	}
+	if len(params["temperature"]) == 0 {
+		params["temperature"] = "0.5"
+	}
+	return nil
+}
 
+func (c *Chatting_openai) setParams(params map[string]string) {
 	for k, v := range params {
 
 		if k == "url" {
@@ -77,7 +103,17 @@ func (c *Chatting_openai) Chat(params map[string]string, messages []ChatMessage,
 			c.Timeout, _ = utils.StringToInt64(v)
 		}
 
+		if k == "response_format" {
+			c.ResponseFormat = v
+		}
+
 	}
+}
+
+func (c *Chatting_openai) Chat(params map[string]string, messages []ChatMessage, callback func(msg []ChatMessage, is_done bool) error, is_suspend func() bool) error {
+	var err error
+
+	c.setParams(params)
 
 	request := openai.ChatRequest{}
 	request.Messages = make([]openai.ChatMessage, 0)
@@ -93,6 +129,9 @@ func (c *Chatting_openai) Chat(params map[string]string, messages []ChatMessage,
 	request.Temperature = c.Temperature
 	request.N = c.N
 	request.User = c.User
+	if len(c.ResponseFormat) > 0 {
+		request.ResponseFormat = &openai.ChatResponseFormat{Type: c.ResponseFormat}
+	}
 
 	header := make(map[string]string)
 	header["Authorization"] = "Bearer " + c.ApiKey
@@ -138,3 +177,80 @@ func (c *Chatting_openai) Chat(params map[string]string, messages []ChatMessage,
 
 	return err
 }
+
+// 工具调用对话，不使用流式输出
+func (c *Chatting_openai) ChatTools(params map[string]string, messages []ChatMessage, tools []ChatTool, tool_choice string) (*ChatMessage, error) {
+	c.setParams(params)
+
+	request := openai.ChatRequest{}
+	request.Messages = make([]openai.ChatMessage, 0)
+
+	for _, m := range messages {
+		msg := openai.ChatMessage{Role: m.Role, Content: m.Content, Images: m.Images, Partial: m.Partial, ToolCallId: m.ToolCallId}
+		for _, call := range m.ToolCalls {
+			tc := openai.ChatToolCall{Id: call.Id, Type: "function"}
+			tc.Function.Name = call.Name
+			tc.Function.Arguments = call.Arguments
+			msg.ToolCalls = append(msg.ToolCalls, tc)
+		}
+		request.Messages = append(request.Messages, msg)
+	}
+
+	for _, t := range tools {
+		request.Tools = append(request.Tools, openai.ChatTool{Type: "function", Function: openai.ChatToolFunction{Name: t.Name, Description: t.Description, Parameters: t.Parameters}})
+	}
+
+	switch tool_choice {
+	case "":
+	case "auto", "none", "required":
+		request.ToolChoice = tool_choice
+	default:
+		//指定工具名称
+		request.ToolChoice = map[string]interface{}{"type": "function", "function": map[string]string{"name": tool_choice}}
+	}
+
+	request.Model = c.Model
+	request.Stream = false
+	request.MaxTokens = c.MaxTokens
+	request.TopP = c.TopP
+	request.Temperature = c.Temperature
+	request.N = c.N
+	request.User = c.User
+	if len(c.ResponseFormat) > 0 {
+		request.ResponseFormat = &openai.ChatResponseFormat{Type: c.ResponseFormat}
+	}
+
+	header := make(map[string]string)
+	header["Authorization"] = "Bearer " + c.ApiKey
+	header["Content-Type"] = "application/json"
+
+	var res *ChatMessage
+
+	err := openai.Chat(c.Url, request, header, c.Timeout, func(gptRes openai.ChatResponse, finish bool) error {
+		if gptRes.Error != nil && len(gptRes.Error.Message) > 0 {
+			log.Println(errors.New(gptRes.Error.Message))
+			return errors.New(gptRes.Error.Message)
+		}
+		if len(gptRes.Choices) == 0 || gptRes.Choices[0].Message == nil {
+			if len(gptRes.Message) > 0 {
+				return errors.New(gptRes.Message)
+			}
+			return errors.New("模型没有返回内容")
+		}
+
+		m := gptRes.Choices[0].Message
+		msg := ChatMessage{Role: MESSAGE_ROLE_ASSISTANT, Content: m.Content}
+		for _, call := range m.ToolCalls {
+			msg.ToolCalls = append(msg.ToolCalls, ChatToolCall{Id: call.Id, Name: call.Function.Name, Arguments: call.Function.Arguments})
+		}
+		res = &msg
+
+		return nil
+	}, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
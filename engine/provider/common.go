@@ -4,9 +4,11 @@ const (
 	MESSAGE_ROLE_USER      = "user"
 	MESSAGE_ROLE_ASSISTANT = "assistant"
 	MESSAGE_ROLE_SYSTEM    = "system"
+	MESSAGE_ROLE_TOOL      = "tool"
 )
 
 var chattings = []string{}
+var chattingCreators = map[string]func() Chatting{}
 var embeddings = []string{}
 var vectordbs = []string{}
 
@@ -49,10 +51,26 @@ func GetEmbeddingDicts() []Dict {
 
 // chatting
 type ChatMessage struct {
-	Role    string   `json:"role"`
-	Content string   `json:"content"`
-	Images  []string `json:"images"`
-	Partial bool     `json:"partial"`
+	Role       string         `json:"role"`
+	Content    string         `json:"content"`
+	Images     []string       `json:"images"`
+	Partial    bool           `json:"partial"`
+	ToolCalls  []ChatToolCall `json:"tool_calls"`   //模型要求调用的工具
+	ToolCallId string         `json:"tool_call_id"` //工具返回结果对应的调用
+}
+
+// 工具定义，参数为JSON Schema
+type ChatTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Parameters  any    `json:"parameters"`
+}
+
+// 工具调用
+type ChatToolCall struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` //JSON
 }
 
 type Chatting interface {
@@ -60,6 +78,12 @@ type Chatting interface {
 	Chat(params map[string]string, messages []ChatMessage, callback func(msg []ChatMessage, is_done bool) error, is_suspend func() bool) error
 }
 
+// 支持工具调用的对话，非流式返回一条消息，消息中包含工具调用或者最终回答
+type ToolChatting interface {
+	Chatting
+	ChatTools(params map[string]string, messages []ChatMessage, tools []ChatTool, tool_choice string) (*ChatMessage, error)
+}
+
 func GetChattingDicts() []Dict {
 	dicts := []Dict{}
 	for _, name := range chattings {
@@ -97,22 +121,46 @@ func GetVectorDBDicts() []Dict {
 	return dicts
 }
 
+// 注册模型对话，注册后可以在流程中通过名称使用
+func RegistChatting(creator func() Chatting) {
+	name := creator().GetDict().Name
+	if _, ok := chattingCreators[name]; !ok {
+		chattings = append(chattings, name)
+	}
+	chattingCreators[name] = creator
+}
+
 func CreateChatting(name string) Chatting {
-	var chatting Chatting
-	if name == "ollama" {
-		chatting = &Chatting_ollama{}
+	creator, ok := chattingCreators[name]
+	if !ok {
+		return nil
 	}
-	if name == "openai" {
-		chatting = &Chatting_openai{}
+	return creator()
+}
+
+// 设置请求参数的默认值并校验，模型对话可以选择实现
+type ChattingPreparer interface {
+	Prepare(params map[string]string) error
+}
+
+// 补全请求参数，没有实现 ChattingPreparer 的模型对话不处理
+func PrepareChatParams(chatting Chatting, params map[string]string) error {
+	if p, ok := chatting.(ChattingPreparer); ok {
+		return p.Prepare(params)
 	}
-	if name == "baidu" {
-		chatting = &Chatting_baidu{}
+	return nil
+}
+
+// 支持工具调用的对话，不支持返回nil
+func CreateToolChatting(name string) ToolChatting {
+	chatting := CreateChatting(name)
+	if chatting == nil {
+		return nil
 	}
-	if name == "kimi" {
-		chatting = &Chatting_kimi{}
+	if tc, ok := chatting.(ToolChatting); ok {
+		return tc
 	}
-
-	return chatting
+	return nil
 }
 
 func CreateEmbedding(name string) Embedding {
@@ -35,6 +35,7 @@ type ChatRequest struct {
 	} `json:"options"`
 	KeepAlive string `json:"keep_alive"`
 	Stream    bool   `json:"stream"`
+	Format    string `json:"format,omitempty"` //json 使用JSON模式
 }
 
 type ChatResponse struct {
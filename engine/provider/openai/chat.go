@@ -34,10 +34,35 @@ type ChatContent struct {
 }
 
 type ChatMessage struct {
-	Role    string   `json:"role"`
-	Content string   `json:"content"`
-	Images  []string `json:"images"`
-	Partial bool     `json:"partial"`
+	Role       string         `json:"role"`
+	Content    string         `json:"content"`
+	Images     []string       `json:"images"`
+	Partial    bool           `json:"partial"`
+	ToolCalls  []ChatToolCall `json:"tool_calls,omitempty"`
+	ToolCallId string         `json:"tool_call_id,omitempty"`
+}
+
+// 工具定义
+type ChatTool struct {
+	Type     string           `json:"type"` //function
+	Function ChatToolFunction `json:"function"`
+}
+
+type ChatToolFunction struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Parameters  interface{} `json:"parameters"` //JSON Schema
+}
+
+// 模型要求调用的工具
+type ChatToolCall struct {
+	Index    int    `json:"index"`
+	Id       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
 }
 
 type ChatRequest struct {
@@ -50,6 +75,15 @@ type ChatRequest struct {
 	N           int     `json:"n"`
 	Stream      bool    `json:"stream"`
 	User        string  `json:"user"`
+
+	Tools      []ChatTool  `json:"tools,omitempty"`
+	ToolChoice interface{} `json:"tool_choice,omitempty"` //auto、none、required 或指定工具
+
+	ResponseFormat *ChatResponseFormat `json:"response_format,omitempty"` //JSON模式
+}
+
+type ChatResponseFormat struct {
+	Type string `json:"type"` //text、json_object
 }
 type ChatError struct {
 	Message string      `json:"message"`
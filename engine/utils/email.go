@@ -0,0 +1,319 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 连接加密方式
+const (
+	EMAIL_SECURITY_STARTTLS = "starttls" //明文连接后升级为TLS
+	EMAIL_SECURITY_TLS      = "tls"      //直接使用TLS连接
+	EMAIL_SECURITY_NONE     = "none"     //不加密
+)
+
+// 附件总大小限制
+const email_max_attachment_size = 25 * 1024 * 1024
+
+// SMTP 服务器
+type EmailServer struct {
+	Host     string
+	Port     int //为0时按加密方式使用默认端口
+	Security string
+	Username string
+	Password string
+	Timeout  time.Duration
+}
+
+// 邮件内容
+type EmailMessage struct {
+	From          *mail.Address
+	To            []*mail.Address
+	Cc            []*mail.Address
+	Bcc           []*mail.Address //只用于投递，不写入邮件头
+	ReplyTo       []*mail.Address
+	Subject       string
+	Body          string
+	Html          bool
+	Attachments   []string //附件文件路径，相对路径相对于附件目录
+	AttachmentDir string   //附件目录，附件只能是该目录中的文件
+	MessageId     string   //为空时生成
+}
+
+// 解析邮件地址列表，逗号或者分号分隔，支持 名称 <地址> 格式
+func ParseEmailAddresses(text string) ([]*mail.Address, error) {
+	text = strings.Trim(strings.ReplaceAll(text, ";", ","), " ,\n")
+	if len(text) == 0 {
+		return []*mail.Address{}, nil
+	}
+	list, err := mail.ParseAddressList(text)
+	if err != nil {
+		return nil, errors.New("邮件地址格式错误: " + text)
+	}
+	return list, nil
+}
+
+// 所有收件人地址，用于投递
+func (m *EmailMessage) Recipients() []string {
+	res := make([]string, 0)
+	for _, list := range [][]*mail.Address{m.To, m.Cc, m.Bcc} {
+		for _, a := range list {
+			if StringsIndex(res, a.Address) < 0 {
+				res = append(res, a.Address)
+			}
+		}
+	}
+	return res
+}
+
+// 生成邮件原文
+func BuildEmail(m *EmailMessage) ([]byte, error) {
+	if m.From == nil {
+		return nil, errors.New("发件人不能为空")
+	}
+	if len(m.Recipients()) == 0 {
+		return nil, errors.New("收件人不能为空")
+	}
+
+	if len(m.MessageId) == 0 {
+		m.MessageId = newEmailMessageId(m.From.Address)
+	}
+
+	var buf bytes.Buffer
+	header := func(k string, v string) {
+		buf.WriteString(k + ": " + v + "\r\n")
+	}
+	header("From", m.From.String())
+	if len(m.To) > 0 {
+		header("To", joinEmailAddresses(m.To))
+	} else {
+		header("To", "undisclosed-recipients:;")
+	}
+	if len(m.Cc) > 0 {
+		header("Cc", joinEmailAddresses(m.Cc))
+	}
+	if len(m.ReplyTo) > 0 {
+		header("Reply-To", joinEmailAddresses(m.ReplyTo))
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", stripEmailHeader(m.Subject)))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", m.MessageId)
+	header("MIME-Version", "1.0")
+
+	content_type := "text/plain; charset=utf-8"
+	if m.Html {
+		content_type = "text/html; charset=utf-8"
+	}
+
+	//没有附件
+	if len(m.Attachments) == 0 {
+		header("Content-Type", content_type)
+		header("Content-Transfer-Encoding", "base64")
+		buf.WriteString("\r\n")
+		writeEmailBase64(&buf, []byte(m.Body))
+		return buf.Bytes(), nil
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {content_type},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	writeEmailBase64(part, []byte(m.Body))
+
+	var total int64
+	for _, item := range m.Attachments {
+		file, err := ResolvePathInDir(m.AttachmentDir, item)
+		if err != nil {
+			return nil, errors.New("附件路径错误: " + err.Error())
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, errors.New("读取附件失败: " + err.Error())
+		}
+		total += int64(len(data))
+		if total > email_max_attachment_size {
+			return nil, fmt.Errorf("附件总大小超过限制 %dMB", email_max_attachment_size/1024/1024)
+		}
+
+		name := strings.ReplaceAll(stripEmailHeader(filepath.Base(file)), "\"", "")
+		name = mime.QEncoding.Encode("utf-8", name)
+		file_type := mime.TypeByExtension(filepath.Ext(file))
+		if len(file_type) == 0 {
+			file_type = "application/octet-stream"
+		}
+
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {file_type + "; name=\"" + name + "\""},
+			"Content-Disposition":       {"attachment; filename=\"" + name + "\""},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeEmailBase64(part, data)
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	header("Content-Type", "multipart/mixed; boundary=\""+writer.Boundary()+"\"")
+	buf.WriteString("\r\n")
+	buf.Write(body.Bytes())
+
+	return buf.Bytes(), nil
+}
+
+// 通过 SMTP 服务器发送邮件原文
+func SendEmail(server EmailServer, m *EmailMessage, raw []byte) error {
+	if len(server.Host) == 0 {
+		return errors.New("邮件服务器不能为空")
+	}
+	security := server.Security
+	if len(security) == 0 {
+		security = EMAIL_SECURITY_STARTTLS
+	}
+	port := server.Port
+	if port == 0 {
+		switch security {
+		case EMAIL_SECURITY_TLS:
+			port = 465
+		case EMAIL_SECURITY_STARTTLS:
+			port = 587
+		default:
+			port = 25
+		}
+	}
+	timeout := server.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	address := net.JoinHostPort(server.Host, strconv.Itoa(port))
+	tls_config := &tls.Config{ServerName: server.Host}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	switch security {
+	case EMAIL_SECURITY_TLS:
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tls_config)
+	case EMAIL_SECURITY_STARTTLS, EMAIL_SECURITY_NONE:
+		conn, err = dialer.Dial("tcp", address)
+	default:
+		return errors.New("不支持的加密方式: " + security)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	c, err := smtp.NewClient(conn, server.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if security == EMAIL_SECURITY_STARTTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("邮件服务器不支持 STARTTLS")
+		}
+		err = c.StartTLS(tls_config)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(server.Username) > 0 {
+		//PLAIN 认证只在加密连接或者本机服务器上使用
+		err = c.Auth(smtp.PlainAuth("", server.Username, server.Password, server.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Mail(m.From.Address)
+	if err != nil {
+		return err
+	}
+	for _, to := range m.Recipients() {
+		err = c.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(raw)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+func joinEmailAddresses(list []*mail.Address) string {
+	res := make([]string, 0)
+	for _, a := range list {
+		res = append(res, a.String())
+	}
+	return strings.Join(res, ", ")
+}
+
+// 邮件头中不能有换行
+func stripEmailHeader(s string) string {
+	s = strings.ReplaceAll(s, "\r", "")
+	s = strings.ReplaceAll(s, "\n", " ")
+	return s
+}
+
+func newEmailMessageId(from string) string {
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 && i < len(from)-1 {
+		domain = from[i+1:]
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(b), domain)
+}
+
+// base64 编码，每行76个字符
+func writeEmailBase64(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
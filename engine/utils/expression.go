@@ -0,0 +1,1052 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 表达式最大长度
+const expression_max_length = 4096
+
+// 表达式最大嵌套深度
+const expression_max_depth = 64
+
+// 条件表达式，只能读取参数和调用内置函数，不能执行脚本
+// 运算符：|| && ! (也可以写成 or and not)、== != < <= > >= in、+ - * / %
+// 值：数字、'字符串'、"字符串"、true、false、null、[数组]，参数名称可以使用 a.b、a[0]、a['b']
+// 函数：len、contains、starts_with、ends_with、lower、upper、trim、number、string、empty、matches、abs、min、max
+// 比较和计算要求类型一致，字符串和数字不会自动转换，需要使用 number() 或者 string()
+type Expression struct {
+	Text string
+	root exprNode
+}
+
+// 解析表达式，格式错误、使用了不支持的函数时返回错误
+func ParseExpression(text string) (*Expression, error) {
+	if len(text) > expression_max_length {
+		return nil, fmt.Errorf("表达式超过%d个字符", expression_max_length)
+	}
+	if len(strings.TrimSpace(text)) == 0 {
+		return nil, errors.New("表达式不能为空")
+	}
+
+	tokens, err := lexExpression(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != expr_token_eof {
+		return nil, fmt.Errorf("表达式位置%d无法识别: %s", t.pos, t.text)
+	}
+	return &Expression{Text: text, root: root}, nil
+}
+
+// 计算表达式
+func (e *Expression) Eval(params map[string]interface{}) (interface{}, error) {
+	return e.root.eval(params)
+}
+
+// 计算条件表达式，结果必须是布尔值
+func (e *Expression) EvalBool(params map[string]interface{}) (bool, error) {
+	v, err := e.Eval(params)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("表达式结果不是布尔值: %s", exprTypeName(v))
+	}
+	return b, nil
+}
+
+// 解析并计算条件表达式
+func EvalBoolExpression(text string, params map[string]interface{}) (bool, error) {
+	e, err := ParseExpression(text)
+	if err != nil {
+		return false, err
+	}
+	return e.EvalBool(params)
+}
+
+// ---------- 词法 ----------
+
+const (
+	expr_token_eof = iota
+	expr_token_number
+	expr_token_string
+	expr_token_ident
+	expr_token_op
+)
+
+type exprToken struct {
+	kind int
+	text string
+	num  float64
+	pos  int
+}
+
+var expr_operators = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", "[", "]", ".", ","}
+
+func lexExpression(text string) ([]exprToken, error) {
+	tokens := make([]exprToken, 0)
+	i := 0
+	for i < len(text) {
+		c := rune(text[i])
+		if c >= utf8.RuneSelf {
+			c, _ = utf8.DecodeRuneInString(text[i:])
+		}
+
+		switch {
+		case unicode.IsSpace(c):
+			i += utf8.RuneLen(c)
+
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(text) && (text[i] >= '0' && text[i] <= '9' || text[i] == '.' || text[i] == 'e' || text[i] == 'E' ||
+				(text[i] == '-' || text[i] == '+') && (text[i-1] == 'e' || text[i-1] == 'E')) {
+				i++
+			}
+			n, err := strconv.ParseFloat(text[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("表达式位置%d数字格式错误: %s", start, text[start:i])
+			}
+			tokens = append(tokens, exprToken{kind: expr_token_number, text: text[start:i], num: n, pos: start})
+
+		case c == '\'' || c == '"':
+			start := i
+			quote := text[i]
+			i++
+			var sb strings.Builder
+			closed := false
+			for i < len(text) {
+				if text[i] == '\\' && i+1 < len(text) {
+					switch text[i+1] {
+					case 'n':
+						sb.WriteByte('\n')
+					case 't':
+						sb.WriteByte('\t')
+					default:
+						sb.WriteByte(text[i+1])
+					}
+					i += 2
+					continue
+				}
+				if text[i] == quote {
+					closed = true
+					i++
+					break
+				}
+				sb.WriteByte(text[i])
+				i++
+			}
+			if !closed {
+				return nil, fmt.Errorf("表达式位置%d字符串没有结束", start)
+			}
+			tokens = append(tokens, exprToken{kind: expr_token_string, text: sb.String(), pos: start})
+
+		case c == '_' || c == '$' || unicode.IsLetter(c):
+			start := i
+			for i < len(text) {
+				r, size := utf8.DecodeRuneInString(text[i:])
+				if r != '_' && r != '$' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				i += size
+			}
+			tokens = append(tokens, exprToken{kind: expr_token_ident, text: text[start:i], pos: start})
+
+		default:
+			matched := false
+			for _, op := range expr_operators {
+				if strings.HasPrefix(text[i:], op) {
+					tokens = append(tokens, exprToken{kind: expr_token_op, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("表达式位置%d无法识别的字符: %c", i, c)
+			}
+		}
+	}
+	tokens = append(tokens, exprToken{kind: expr_token_eof, pos: len(text)})
+	return tokens, nil
+}
+
+// ---------- 语法 ----------
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+	depth  int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.pos]
+	if t.kind != expr_token_eof {
+		p.pos++
+	}
+	return t
+}
+
+// 当前是否是指定的运算符或关键字
+func (p *exprParser) is(texts ...string) bool {
+	t := p.peek()
+	if t.kind != expr_token_op && t.kind != expr_token_ident {
+		return false
+	}
+	for _, text := range texts {
+		if t.text == text {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *exprParser) expect(text string) error {
+	t := p.next()
+	if t.kind != expr_token_op || t.text != text {
+		if t.kind == expr_token_eof {
+			return fmt.Errorf("表达式缺少 %s", text)
+		}
+		return fmt.Errorf("表达式位置%d应该是 %s", t.pos, text)
+	}
+	return nil
+}
+
+func (p *exprParser) enter() error {
+	p.depth++
+	if p.depth > expression_max_depth {
+		return fmt.Errorf("表达式嵌套超过%d层", expression_max_depth)
+	}
+	return nil
+}
+
+func (p *exprParser) leave() {
+	p.depth--
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("||", "or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprLogical{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("&&", "and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprLogical{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if p.is("!", "not") {
+		p.next()
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &exprUnary{op: "!", operand: operand}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *exprParser) parseCompare() (exprNode, error) {
+	left, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	if p.is("==", "!=", "<", "<=", ">", ">=", "in") {
+		op := p.next().text
+		right, err := p.parseAdd()
+		if err != nil {
+			return nil, err
+		}
+		return &exprBinary{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAdd() (exprNode, error) {
+	left, err := p.parseMul()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("+", "-") {
+		op := p.next().text
+		right, err := p.parseMul()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprBinary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseMul() (exprNode, error) {
+	left, err := p.parseNeg()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("*", "/", "%") {
+		op := p.next().text
+		right, err := p.parseNeg()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprBinary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNeg() (exprNode, error) {
+	if p.is("-") {
+		p.next()
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+
+		operand, err := p.parseNeg()
+		if err != nil {
+			return nil, err
+		}
+		return &exprUnary{op: "-", operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *exprParser) parsePostfix() (exprNode, error) {
+	node, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.is("."):
+			p.next()
+			t := p.next()
+			if t.kind != expr_token_ident {
+				return nil, fmt.Errorf("表达式位置%d应该是属性名称", t.pos)
+			}
+			node = &exprIndex{target: node, key: &exprLiteral{value: t.text}}
+		case p.is("["):
+			p.next()
+			key, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			node = &exprIndex{target: node, key: key}
+		default:
+			return node, nil
+		}
+	}
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case expr_token_number:
+		return &exprLiteral{value: t.num}, nil
+	case expr_token_string:
+		return &exprLiteral{value: t.text}, nil
+	case expr_token_ident:
+		switch t.text {
+		case "true":
+			return &exprLiteral{value: true}, nil
+		case "false":
+			return &exprLiteral{value: false}, nil
+		case "null", "nil":
+			return &exprLiteral{value: nil}, nil
+		}
+		if p.is("(") {
+			p.next()
+			fn, ok := expr_functions[t.text]
+			if !ok {
+				return nil, fmt.Errorf("表达式位置%d不支持的函数: %s", t.pos, t.text)
+			}
+			args, err := p.parseList(")")
+			if err != nil {
+				return nil, err
+			}
+			if len(args) < fn.min_args || (fn.max_args >= 0 && len(args) > fn.max_args) {
+				return nil, fmt.Errorf("表达式位置%d函数 %s 参数数量错误", t.pos, t.text)
+			}
+			return &exprCall{name: t.text, fn: fn.call, args: args}, nil
+		}
+		return &exprIdent{name: t.text}, nil
+	case expr_token_op:
+		if t.text == "(" {
+			if err := p.enter(); err != nil {
+				return nil, err
+			}
+			defer p.leave()
+
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return node, nil
+		}
+		if t.text == "[" {
+			items, err := p.parseList("]")
+			if err != nil {
+				return nil, err
+			}
+			return &exprList{items: items}, nil
+		}
+	case expr_token_eof:
+		return nil, errors.New("表达式不完整")
+	}
+	return nil, fmt.Errorf("表达式位置%d无法识别: %s", t.pos, t.text)
+}
+
+// 解析逗号分隔的列表，直到结束符号
+func (p *exprParser) parseList(end string) ([]exprNode, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	items := make([]exprNode, 0)
+	if p.is(end) {
+		p.next()
+		return items, nil
+	}
+	for {
+		item, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if p.is(",") {
+			p.next()
+			continue
+		}
+		if err := p.expect(end); err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+}
+
+// ---------- 计算 ----------
+
+type exprNode interface {
+	eval(params map[string]interface{}) (interface{}, error)
+}
+
+type exprLiteral struct {
+	value interface{}
+}
+
+func (n *exprLiteral) eval(params map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+type exprIdent struct {
+	name string
+}
+
+// 参数不存在时为 null
+func (n *exprIdent) eval(params map[string]interface{}) (interface{}, error) {
+	if params == nil {
+		return nil, nil
+	}
+	return params[n.name], nil
+}
+
+type exprList struct {
+	items []exprNode
+}
+
+func (n *exprList) eval(params map[string]interface{}) (interface{}, error) {
+	list := make([]interface{}, 0, len(n.items))
+	for _, item := range n.items {
+		v, err := item.eval(params)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+type exprIndex struct {
+	target exprNode
+	key    exprNode
+}
+
+// 属性或下标不存在时为 null
+func (n *exprIndex) eval(params map[string]interface{}) (interface{}, error) {
+	target, err := n.target.eval(params)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.key.eval(params)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		return nil, nil
+	}
+
+	//JSON字符串按对象或数组访问
+	if text, ok := target.(string); ok {
+		var obj interface{}
+		if json.Unmarshal([]byte(text), &obj) != nil {
+			return nil, fmt.Errorf("字符串不能按属性访问")
+		}
+		target = obj
+	}
+
+	v := reflect.ValueOf(target)
+	switch v.Kind() {
+	case reflect.Map:
+		k, ok := key.(string)
+		if !ok {
+			if f, is_num := exprToNumber(key); is_num {
+				k = strconv.FormatFloat(f, 'f', -1, 64)
+			} else {
+				return nil, fmt.Errorf("属性名称类型错误: %s", exprTypeName(key))
+			}
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, nil
+		}
+		item := v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key()))
+		if !item.IsValid() {
+			return nil, nil
+		}
+		return item.Interface(), nil
+	case reflect.Slice, reflect.Array:
+		f, ok := exprToNumber(key)
+		if !ok || f != math.Trunc(f) {
+			return nil, fmt.Errorf("数组下标类型错误: %s", exprTypeName(key))
+		}
+		i := int(f)
+		if i < 0 {
+			i += v.Len()
+		}
+		if i < 0 || i >= v.Len() {
+			return nil, nil
+		}
+		return v.Index(i).Interface(), nil
+	}
+	return nil, fmt.Errorf("%s 不能按属性访问", exprTypeName(target))
+}
+
+type exprUnary struct {
+	op      string
+	operand exprNode
+}
+
+func (n *exprUnary) eval(params map[string]interface{}) (interface{}, error) {
+	v, err := n.operand.eval(params)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("! 需要布尔值: %s", exprTypeName(v))
+		}
+		return !b, nil
+	}
+	f, ok := exprToNumber(v)
+	if !ok {
+		return nil, fmt.Errorf("- 需要数字: %s", exprTypeName(v))
+	}
+	return -f, nil
+}
+
+type exprLogical struct {
+	op    string
+	left  exprNode
+	right exprNode
+}
+
+// 逻辑运算短路计算，两边都必须是布尔值
+func (n *exprLogical) eval(params map[string]interface{}) (interface{}, error) {
+	lv, err := n.left.eval(params)
+	if err != nil {
+		return nil, err
+	}
+	l, ok := lv.(bool)
+	if !ok {
+		return nil, fmt.Errorf("%s 需要布尔值: %s", n.op, exprTypeName(lv))
+	}
+	if n.op == "&&" && !l {
+		return false, nil
+	}
+	if n.op == "||" && l {
+		return true, nil
+	}
+	rv, err := n.right.eval(params)
+	if err != nil {
+		return nil, err
+	}
+	r, ok := rv.(bool)
+	if !ok {
+		return nil, fmt.Errorf("%s 需要布尔值: %s", n.op, exprTypeName(rv))
+	}
+	return r, nil
+}
+
+type exprBinary struct {
+	op    string
+	left  exprNode
+	right exprNode
+}
+
+func (n *exprBinary) eval(params map[string]interface{}) (interface{}, error) {
+	l, err := n.left.eval(params)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(params)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return exprEqual(l, r), nil
+	case "!=":
+		return !exprEqual(l, r), nil
+	case "in":
+		return exprContains(r, l)
+	case "<", "<=", ">", ">=":
+		c, err := exprCompare(l, r, n.op)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	}
+
+	if n.op == "+" {
+		ls, lok := l.(string)
+		rs, rok := r.(string)
+		if lok && rok {
+			return ls + rs, nil
+		}
+	}
+
+	lf, lok := exprToNumber(l)
+	rf, rok := exprToNumber(r)
+	if !lok || !rok {
+		return nil, fmt.Errorf("%s 类型不匹配: %s %s %s", n.op, exprTypeName(l), n.op, exprTypeName(r))
+	}
+	switch n.op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, errors.New("除数不能为0")
+		}
+		return lf / rf, nil
+	default:
+		if rf == 0 {
+			return nil, errors.New("除数不能为0")
+		}
+		return math.Mod(lf, rf), nil
+	}
+}
+
+type exprCall struct {
+	name string
+	fn   func(args []interface{}) (interface{}, error)
+	args []exprNode
+}
+
+func (n *exprCall) eval(params map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, 0, len(n.args))
+	for _, a := range n.args {
+		v, err := a.eval(params)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	v, err := n.fn(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", n.name, err)
+	}
+	return v, nil
+}
+
+// ---------- 类型 ----------
+
+// 数字统一转换为 float64，字符串不转换
+func exprToNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func exprTypeName(v interface{}) string {
+	if v == nil {
+		return "null"
+	}
+	if _, ok := exprToNumber(v); ok {
+		return "number"
+	}
+	switch v.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	}
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map:
+		return "object"
+	}
+	return reflect.TypeOf(v).String()
+}
+
+func exprEqual(l, r interface{}) bool {
+	if l == nil || r == nil {
+		if l == nil && r == nil {
+			return true
+		}
+		return false
+	}
+	lf, lok := exprToNumber(l)
+	rf, rok := exprToNumber(r)
+	if lok && rok {
+		return lf == rf
+	}
+	if lok != rok {
+		return false
+	}
+	return reflect.DeepEqual(l, r)
+}
+
+// 比较大小，只能比较数字和数字、字符串和字符串
+func exprCompare(l, r interface{}, op string) (int, error) {
+	lf, lok := exprToNumber(l)
+	rf, rok := exprToNumber(r)
+	if lok && rok {
+		switch {
+		case lf < rf:
+			return -1, nil
+		case lf > rf:
+			return 1, nil
+		}
+		return 0, nil
+	}
+	ls, lok := l.(string)
+	rs, rok := r.(string)
+	if lok && rok {
+		return strings.Compare(ls, rs), nil
+	}
+	return 0, fmt.Errorf("%s 类型不匹配: %s %s %s", op, exprTypeName(l), op, exprTypeName(r))
+}
+
+// 包含判断：字符串包含子串、数组包含元素、对象包含属性
+func exprContains(container interface{}, item interface{}) (bool, error) {
+	if container == nil {
+		return false, nil
+	}
+	if s, ok := container.(string); ok {
+		sub, ok := item.(string)
+		if !ok {
+			return false, fmt.Errorf("字符串只能包含字符串: %s", exprTypeName(item))
+		}
+		return strings.Contains(s, sub), nil
+	}
+	v := reflect.ValueOf(container)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if exprEqual(v.Index(i).Interface(), item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case reflect.Map:
+		k, ok := item.(string)
+		if !ok || v.Type().Key().Kind() != reflect.String {
+			return false, nil
+		}
+		return v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key())).IsValid(), nil
+	}
+	return false, fmt.Errorf("%s 不能判断包含", exprTypeName(container))
+}
+
+// 长度：字符串按字符计算，数组和对象按元素计算，null 为0
+func exprLen(v interface{}) (int, error) {
+	if v == nil {
+		return 0, nil
+	}
+	if s, ok := v.(string); ok {
+		return utf8.RuneCountInString(s), nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len(), nil
+	}
+	return 0, fmt.Errorf("%s 没有长度", exprTypeName(v))
+}
+
+// ---------- 函数 ----------
+
+type exprFunction struct {
+	min_args int
+	max_args int //-1 不限制
+	call     func(args []interface{}) (interface{}, error)
+}
+
+func exprStringArg(args []interface{}, i int) (string, error) {
+	s, ok := args[i].(string)
+	if !ok {
+		return "", fmt.Errorf("第%d个参数需要字符串: %s", i+1, exprTypeName(args[i]))
+	}
+	return s, nil
+}
+
+func exprNumberArgs(args []interface{}) ([]float64, error) {
+	nums := make([]float64, 0, len(args))
+	for i, a := range args {
+		f, ok := exprToNumber(a)
+		if !ok {
+			return nil, fmt.Errorf("第%d个参数需要数字: %s", i+1, exprTypeName(a))
+		}
+		nums = append(nums, f)
+	}
+	return nums, nil
+}
+
+var expr_functions map[string]exprFunction
+
+func init() {
+	expr_functions = map[string]exprFunction{
+		"len": {1, 1, func(args []interface{}) (interface{}, error) {
+			n, err := exprLen(args[0])
+			return float64(n), err
+		}},
+		"empty": {1, 1, func(args []interface{}) (interface{}, error) {
+			if s, ok := args[0].(string); ok {
+				return len(strings.TrimSpace(s)) == 0, nil
+			}
+			n, err := exprLen(args[0])
+			if err != nil {
+				return args[0] == nil, nil
+			}
+			return n == 0, nil
+		}},
+		"contains": {2, 2, func(args []interface{}) (interface{}, error) {
+			return exprContains(args[0], args[1])
+		}},
+		"starts_with": {2, 2, func(args []interface{}) (interface{}, error) {
+			s, err := exprStringArg(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			prefix, err := exprStringArg(args, 1)
+			if err != nil {
+				return nil, err
+			}
+			return strings.HasPrefix(s, prefix), nil
+		}},
+		"ends_with": {2, 2, func(args []interface{}) (interface{}, error) {
+			s, err := exprStringArg(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			suffix, err := exprStringArg(args, 1)
+			if err != nil {
+				return nil, err
+			}
+			return strings.HasSuffix(s, suffix), nil
+		}},
+		"lower": {1, 1, func(args []interface{}) (interface{}, error) {
+			s, err := exprStringArg(args, 0)
+			return strings.ToLower(s), err
+		}},
+		"upper": {1, 1, func(args []interface{}) (interface{}, error) {
+			s, err := exprStringArg(args, 0)
+			return strings.ToUpper(s), err
+		}},
+		"trim": {1, 1, func(args []interface{}) (interface{}, error) {
+			s, err := exprStringArg(args, 0)
+			return strings.TrimSpace(s), err
+		}},
+		"matches": {2, 2, func(args []interface{}) (interface{}, error) {
+			s, err := exprStringArg(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			pattern, err := exprStringArg(args, 1)
+			if err != nil {
+				return nil, err
+			}
+			reg, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, err
+			}
+			return reg.MatchString(s), nil
+		}},
+		"number": {1, 1, func(args []interface{}) (interface{}, error) {
+			if f, ok := exprToNumber(args[0]); ok {
+				return f, nil
+			}
+			switch v := args[0].(type) {
+			case string:
+				f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+				if err != nil {
+					return nil, fmt.Errorf("不是数字: %s", v)
+				}
+				return f, nil
+			case bool:
+				if v {
+					return float64(1), nil
+				}
+				return float64(0), nil
+			}
+			return nil, fmt.Errorf("%s 不能转换为数字", exprTypeName(args[0]))
+		}},
+		"string": {1, 1, func(args []interface{}) (interface{}, error) {
+			switch v := args[0].(type) {
+			case nil:
+				return "", nil
+			case string:
+				return v, nil
+			case bool:
+				return strconv.FormatBool(v), nil
+			}
+			if f, ok := exprToNumber(args[0]); ok {
+				return strconv.FormatFloat(f, 'f', -1, 64), nil
+			}
+			data, err := json.Marshal(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return string(data), nil
+		}},
+		"abs": {1, 1, func(args []interface{}) (interface{}, error) {
+			nums, err := exprNumberArgs(args)
+			if err != nil {
+				return nil, err
+			}
+			return math.Abs(nums[0]), nil
+		}},
+		"min": {1, -1, func(args []interface{}) (interface{}, error) {
+			nums, err := exprNumberArgs(args)
+			if err != nil {
+				return nil, err
+			}
+			m := nums[0]
+			for _, f := range nums[1:] {
+				m = math.Min(m, f)
+			}
+			return m, nil
+		}},
+		"max": {1, -1, func(args []interface{}) (interface{}, error) {
+			nums, err := exprNumberArgs(args)
+			if err != nil {
+				return nil, err
+			}
+			m := nums[0]
+			for _, f := range nums[1:] {
+				m = math.Max(m, f)
+			}
+			return m, nil
+		}},
+	}
+}
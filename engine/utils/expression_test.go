@@ -0,0 +1,245 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var expression_params = map[string]interface{}{
+	"n":     3,
+	"f":     2.5,
+	"name":  "Alice",
+	"empty": "",
+	"flag":  true,
+	"tags":  []interface{}{"vip", "new"},
+	"nums":  []interface{}{1, 2, 3},
+	"user": map[string]interface{}{
+		"age":   30,
+		"roles": []interface{}{"admin"},
+		"1":     "one",
+	},
+	"json": `{"a":{"b":[10,20]}}`,
+}
+
+func TestExpressionEval(t *testing.T) {
+	cases := []struct {
+		text string
+		want interface{}
+	}{
+		//优先级
+		{"1 + 2 * 3", 7.0},
+		{"(1 + 2) * 3", 9.0},
+		{"10 - 4 - 3", 3.0},
+		{"12 / 3 / 2", 2.0},
+		{"7 % 4 + 1", 4.0},
+		{"-2 * -3", 6.0},
+		{"--2", 2.0},
+		{"1 + 2 == 3", true},
+		{"1 + 2 * 3 > 6 && 2 < 1 || true", true},
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		{"!false && false", false},
+		{"!(false && false)", true},
+		{"not flag or n > 2", true},
+		{"n > 1 and n < 5", true},
+		{"1e3 + 0.5", 1000.5},
+
+		//字符串
+		{"'a' + \"b\"", "ab"},
+		{"'it\\'s'", "it's"},
+		{"'a' < 'b'", true},
+		{"name == 'Alice'", true},
+
+		//in
+		{"'vip' in tags", true},
+		{"'old' in tags", false},
+		{"2 in nums", true},
+		{"'li' in name", true},
+		{"'age' in user", true},
+		{"'x' in user", false},
+		{"1 in [1, 2]", true},
+		{"'a' in missing", false},
+		{"1 + 1 in [2]", true},
+
+		//参数
+		{"user.age", 30},
+		{"user['age'] + 1", 31.0},
+		{"user.roles[0]", "admin"},
+		{"user[1]", "one"},
+		{"nums[-1]", 3},
+		{"nums[5]", nil},
+		{"missing", nil},
+		{"missing.a.b", nil},
+		{"json.a.b[1]", 20.0},
+		{"n == 3.0", true},
+		{"n + f", 5.5},
+
+		//相等不做类型转换
+		{"n == '3'", false},
+		{"'3' != 3", true},
+		{"null == missing", true},
+		{"null == 0", false},
+		{"[1, 2] == [1, 2]", true},
+
+		//函数
+		{"len(name)", 5.0},
+		{"len('中文')", 2.0},
+		{"len(tags)", 2.0},
+		{"len(missing)", 0.0},
+		{"empty(empty) && !empty(name)", true},
+		{"contains(name, 'lic')", true},
+		{"starts_with(name, 'Al')", true},
+		{"ends_with(name, 'ce')", true},
+		{"lower(name) + upper('x')", "aliceX"},
+		{"trim('  a ')", "a"},
+		{"matches(name, '^A.*e$')", true},
+		{"number('12') + 1", 13.0},
+		{"string(12) + 'a'", "12a"},
+		{"abs(-2)", 2.0},
+		{"min(3, 1, 2)", 1.0},
+		{"max(3, 1, 2)", 3.0},
+	}
+	for _, c := range cases {
+		e, err := ParseExpression(c.text)
+		if err != nil {
+			t.Errorf("ParseExpression(%q) error: %v", c.text, err)
+			continue
+		}
+		v, err := e.Eval(expression_params)
+		if err != nil {
+			t.Errorf("Eval(%q) error: %v", c.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, c.want) {
+			t.Errorf("Eval(%q) = %#v, want %#v", c.text, v, c.want)
+		}
+	}
+}
+
+func TestExpressionEvalError(t *testing.T) {
+	cases := []struct {
+		text string
+		err  string
+	}{
+		//类型不匹配
+		{"name + 1", "类型不匹配"},
+		{"'3' > 2", "类型不匹配"},
+		{"n - 'a'", "类型不匹配"},
+		{"tags < 1", "类型不匹配"},
+		{"n && true", "需要布尔值"},
+		{"true || 1", ""},
+		{"false || 1", "需要布尔值"},
+		{"!name", "需要布尔值"},
+		{"-name", "需要数字"},
+		{"1 in name", "字符串只能包含字符串"},
+		{"1 in 2", "不能判断包含"},
+		{"n / 0", "除数不能为0"},
+		{"n % 0", "除数不能为0"},
+		{"nums['a']", "数组下标类型错误"},
+		{"nums[0.5]", "数组下标类型错误"},
+		{"n.a", "不能按属性访问"},
+		{"name.a", "字符串不能按属性访问"},
+		{"len(n)", "没有长度"},
+		{"lower(n)", "需要字符串"},
+		{"number('abc')", "number"},
+	}
+	for _, c := range cases {
+		e, err := ParseExpression(c.text)
+		if err != nil {
+			t.Errorf("ParseExpression(%q) error: %v", c.text, err)
+			continue
+		}
+		_, err = e.Eval(expression_params)
+		if len(c.err) == 0 {
+			//短路计算，不计算右边
+			if err != nil {
+				t.Errorf("Eval(%q) error: %v, want nil", c.text, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("Eval(%q) error = %v, want %q", c.text, err, c.err)
+		}
+	}
+}
+
+func TestParseExpressionError(t *testing.T) {
+	cases := []struct {
+		text string
+		err  string
+	}{
+		{"", "不能为空"},
+		{"   ", "不能为空"},
+		{"1 +", "不完整"},
+		{"(1 + 2", "缺少 )"},
+		{"[1, 2", "缺少 ]"},
+		{"1 < 2 < 3", "无法识别"},
+		{"1 2", "无法识别"},
+		{"'abc", "字符串没有结束"},
+		{"1.2.3", "数字格式错误"},
+		{"a # b", "无法识别的字符"},
+		{"exec('rm')", "不支持的函数"},
+		{"len()", "参数数量错误"},
+		{"len(a, b)", "参数数量错误"},
+		{"a.1", "属性名称"},
+		{strings.Repeat("a", expression_max_length+1), "超过"},
+	}
+	for _, c := range cases {
+		_, err := ParseExpression(c.text)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("ParseExpression(%q) error = %v, want %q", c.text, err, c.err)
+		}
+	}
+}
+
+func TestParseExpressionDepth(t *testing.T) {
+	cases := []struct {
+		text string
+		err  bool
+	}{
+		{strings.Repeat("(", 20) + "1" + strings.Repeat(")", 20), false},
+		{strings.Repeat("(", 40) + "1" + strings.Repeat(")", 40), true},
+		{strings.Repeat("!", 60) + "true", false},
+		{strings.Repeat("!", 70) + "true", true},
+		{strings.Repeat("-", 70) + "1", true},
+		{strings.Repeat("[", 40) + strings.Repeat("]", 40), true},
+		{strings.Repeat("len(", 40) + "'a'" + strings.Repeat(")", 40), true},
+	}
+	for _, c := range cases {
+		_, err := ParseExpression(c.text)
+		if c.err && (err == nil || !strings.Contains(err.Error(), "嵌套")) {
+			t.Errorf("ParseExpression(%d chars) error = %v, want depth error", len(c.text), err)
+		}
+		if !c.err && err != nil {
+			t.Errorf("ParseExpression(%d chars) error = %v", len(c.text), err)
+		}
+	}
+}
+
+func TestEvalBoolExpression(t *testing.T) {
+	cases := []struct {
+		text string
+		want bool
+		err  bool
+	}{
+		{"n >= 3", true, false},
+		{"flag", true, false},
+		{"missing == null", true, false},
+		{"n", false, true},
+		{"missing", false, true},
+		{"name", false, true},
+	}
+	for _, c := range cases {
+		v, err := EvalBoolExpression(c.text, expression_params)
+		if c.err {
+			if err == nil {
+				t.Errorf("EvalBoolExpression(%q) = %v, want error", c.text, v)
+			}
+			continue
+		}
+		if err != nil || v != c.want {
+			t.Errorf("EvalBoolExpression(%q) = %v, %v, want %v", c.text, v, err, c.want)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package utils
 
 import (
 	"encoding/json"
+	"errors"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 
@@ -186,3 +188,36 @@ func ReadFile(filepath string) (string, error) {
 
 	return words, err
 }
+
+// 限制文件路径在指定目录内，相对路径相对于该目录
+// 路径（包括符号链接指向的位置）超出目录时返回错误
+func ResolvePathInDir(dir string, file string) (string, error) {
+	if len(dir) == 0 {
+		return "", errors.New("没有指定文件目录")
+	}
+	base, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if real, err := filepath.EvalSymlinks(base); err == nil {
+		base = real
+	}
+
+	p := file
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(base, p)
+	}
+	p, err = filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	if real, err := filepath.EvalSymlinks(p); err == nil {
+		p = real
+	}
+
+	rel, err := filepath.Rel(base, p)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.New("文件不在允许的目录中: " + file)
+	}
+	return p, nil
+}
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+)
+
+// JSON Schema 校验，支持常用关键字：
+// type、properties、required、additionalProperties、items、enum、const、
+// minimum、maximum、minLength、maxLength、pattern、minItems、maxItems
+// 返回所有校验错误，没有错误返回空数组
+func ValidateJsonSchema(schema interface{}, value interface{}) []string {
+	errs := make([]string, 0)
+	validateJsonSchema(schema, value, "$", &errs)
+	return errs
+}
+
+// 解析JSON字符串后校验
+func ValidateJsonSchemaString(schema string, value string) ([]string, error) {
+	var s interface{}
+	if err := json.Unmarshal([]byte(schema), &s); err != nil {
+		return nil, fmt.Errorf("schema格式错误: %v", err)
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return []string{fmt.Sprintf("$: JSON格式错误: %v", err)}, nil
+	}
+	return ValidateJsonSchema(s, v), nil
+}
+
+func validateJsonSchema(schema interface{}, value interface{}, path string, errs *[]string) {
+	sm, ok := schema.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	addError := func(format string, args ...interface{}) {
+		*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	//类型
+	if t, ok := sm["type"]; ok {
+		types := make([]string, 0)
+		switch tv := t.(type) {
+		case string:
+			types = append(types, tv)
+		case []interface{}:
+			for _, item := range tv {
+				types = append(types, fmt.Sprintf("%v", item))
+			}
+		}
+		matched := false
+		for _, tp := range types {
+			if isJsonType(tp, value) {
+				matched = true
+				break
+			}
+		}
+		if len(types) > 0 && !matched {
+			addError("类型应该是 %v，实际是 %s", t, getJsonType(value))
+			return
+		}
+	}
+
+	//枚举
+	if enum, ok := sm["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if reflect.DeepEqual(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			data, _ := json.Marshal(enum)
+			addError("值应该是 %s 中的一个", string(data))
+		}
+	}
+	if c, ok := sm["const"]; ok && !reflect.DeepEqual(c, value) {
+		data, _ := json.Marshal(c)
+		addError("值应该是 %s", string(data))
+	}
+
+	switch v := value.(type) {
+	case float64:
+		if min, ok := sm["minimum"].(float64); ok && v < min {
+			addError("值不能小于 %v", min)
+		}
+		if max, ok := sm["maximum"].(float64); ok && v > max {
+			addError("值不能大于 %v", max)
+		}
+
+	case string:
+		length := float64(utf8.RuneCountInString(v))
+		if min, ok := sm["minLength"].(float64); ok && length < min {
+			addError("长度不能小于 %v", min)
+		}
+		if max, ok := sm["maxLength"].(float64); ok && length > max {
+			addError("长度不能大于 %v", max)
+		}
+		if pattern, ok := sm["pattern"].(string); ok {
+			reg, err := regexp.Compile(pattern)
+			if err == nil && !reg.MatchString(v) {
+				addError("不符合格式 %s", pattern)
+			}
+		}
+
+	case []interface{}:
+		if min, ok := sm["minItems"].(float64); ok && float64(len(v)) < min {
+			addError("元素个数不能小于 %v", min)
+		}
+		if max, ok := sm["maxItems"].(float64); ok && float64(len(v)) > max {
+			addError("元素个数不能大于 %v", max)
+		}
+		if items, ok := sm["items"]; ok {
+			for i, item := range v {
+				validateJsonSchema(items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+
+	case map[string]interface{}:
+		if required, ok := sm["required"].([]interface{}); ok {
+			for _, r := range required {
+				name := fmt.Sprintf("%v", r)
+				if _, ok := v[name]; !ok {
+					addError("缺少字段 %s", name)
+				}
+			}
+		}
+
+		properties, _ := sm["properties"].(map[string]interface{})
+
+		keys := make([]string, 0)
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if ps, ok := properties[k]; ok {
+				validateJsonSchema(ps, v[k], path+"."+k, errs)
+				continue
+			}
+			switch ap := sm["additionalProperties"].(type) {
+			case bool:
+				if !ap {
+					addError("不允许的字段 %s", k)
+				}
+			case map[string]interface{}:
+				validateJsonSchema(ap, v[k], path+"."+k, errs)
+			}
+		}
+	}
+}
+
+func isJsonType(t string, value interface{}) bool {
+	switch t {
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	}
+	return getJsonType(value) == t
+}
+
+func getJsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return reflect.TypeOf(value).String()
+}
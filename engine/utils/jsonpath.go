@@ -0,0 +1,195 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSONPath 查询，支持常用语法：
+// $ 根节点、.name 和 ['name'] 子节点、[0] 数组下标（负数从末尾计算）、[*] 和 .* 所有子节点、..name 递归查找
+// 路径中包含通配符或递归查找时返回数组，否则返回单个值
+func JsonPath(data interface{}, path string) (interface{}, error) {
+	tokens, err := parseJsonPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	multiple := false
+	nodes := []interface{}{data}
+	for _, t := range tokens {
+		if t.wildcard || t.recursive {
+			multiple = true
+		}
+		next := make([]interface{}, 0)
+		for _, node := range nodes {
+			next = append(next, t.apply(node)...)
+		}
+		nodes = next
+	}
+
+	if multiple {
+		return nodes, nil
+	}
+	if len(nodes) == 0 {
+		return nil, errors.New("路径不存在: " + path)
+	}
+	return nodes[0], nil
+}
+
+// 解析JSON字符串后查询
+func JsonPathString(content string, path string) (interface{}, error) {
+	var data interface{}
+	err := json.Unmarshal([]byte(content), &data)
+	if err != nil {
+		return nil, fmt.Errorf("JSON格式错误: %v", err)
+	}
+	return JsonPath(data, path)
+}
+
+type jsonPathToken struct {
+	key       string
+	index     int
+	is_index  bool
+	wildcard  bool
+	recursive bool
+}
+
+func (t jsonPathToken) apply(node interface{}) []interface{} {
+	if t.recursive {
+		res := make([]interface{}, 0)
+		collectJsonPath(node, t.key, &res)
+		return res
+	}
+
+	switch v := node.(type) {
+	case map[string]interface{}:
+		if t.wildcard {
+			res := make([]interface{}, 0)
+			for _, k := range jsonPathKeys(v) {
+				res = append(res, v[k])
+			}
+			return res
+		}
+		if t.is_index {
+			return nil
+		}
+		if item, ok := v[t.key]; ok {
+			return []interface{}{item}
+		}
+	case []interface{}:
+		if t.wildcard {
+			return v
+		}
+		if t.is_index {
+			i := t.index
+			if i < 0 {
+				i += len(v)
+			}
+			if i >= 0 && i < len(v) {
+				return []interface{}{v[i]}
+			}
+		}
+	}
+	return nil
+}
+
+// 递归查找名称为 key 的子节点
+func collectJsonPath(node interface{}, key string, res *[]interface{}) {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for _, k := range jsonPathKeys(v) {
+			if k == key || key == "*" {
+				*res = append(*res, v[k])
+			}
+			collectJsonPath(v[k], key, res)
+		}
+	case []interface{}:
+		for _, item := range v {
+			if key == "*" {
+				*res = append(*res, item)
+			}
+			collectJsonPath(item, key, res)
+		}
+	}
+}
+
+func parseJsonPath(path string) ([]jsonPathToken, error) {
+	path = strings.TrimSpace(path)
+	if strings.HasPrefix(path, "$") {
+		path = path[1:]
+	} else if len(path) > 0 && path[0] != '.' && path[0] != '[' {
+		//省略 $. 的写法，例如 data.id
+		path = "." + path
+	}
+
+	tokens := make([]jsonPathToken, 0)
+	for i := 0; i < len(path); {
+		switch {
+		case strings.HasPrefix(path[i:], ".."):
+			i += 2
+			name, n := readJsonPathName(path[i:])
+			if len(name) == 0 {
+				return nil, errors.New("路径格式错误: " + path)
+			}
+			tokens = append(tokens, jsonPathToken{key: name, recursive: true})
+			i += n
+		case path[i] == '.':
+			i++
+			name, n := readJsonPathName(path[i:])
+			if len(name) == 0 {
+				return nil, errors.New("路径格式错误: " + path)
+			}
+			if name == "*" {
+				tokens = append(tokens, jsonPathToken{wildcard: true})
+			} else {
+				tokens = append(tokens, jsonPathToken{key: name})
+			}
+			i += n
+		case path[i] == '[':
+			end := strings.Index(path[i:], "]")
+			if end < 0 {
+				return nil, errors.New("路径格式错误: " + path)
+			}
+			content := strings.TrimSpace(path[i+1 : i+end])
+			i += end + 1
+
+			if content == "*" {
+				tokens = append(tokens, jsonPathToken{wildcard: true})
+			} else if len(content) >= 2 && (content[0] == '\'' || content[0] == '"') && content[len(content)-1] == content[0] {
+				tokens = append(tokens, jsonPathToken{key: content[1 : len(content)-1]})
+			} else {
+				index, err := strconv.Atoi(content)
+				if err != nil {
+					return nil, errors.New("路径格式错误: " + path)
+				}
+				tokens = append(tokens, jsonPathToken{index: index, is_index: true})
+			}
+		default:
+			return nil, errors.New("路径格式错误: " + path)
+		}
+	}
+	return tokens, nil
+}
+
+// 读取名称，到 . 或 [ 结束
+func readJsonPathName(path string) (string, int) {
+	n := 0
+	for n < len(path) && path[n] != '.' && path[n] != '[' {
+		n++
+	}
+	return strings.TrimSpace(path[:n]), n
+}
+
+// 按名称排序，保证结果顺序稳定
+func jsonPathKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,672 @@
+package utils
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// 日期、时间、数字和金额的规范化，把中文和英文的自然语言表达转换为标准值
+// 相对日期按照传入的当前时间计算，结果使用当前时间的时区
+
+const cn_number_chars = "零〇一二两三四五六七八九十百千万亿壹贰叁肆伍陆柒捌玖拾佰仟"
+
+var cn_number_digits = map[rune]float64{
+	'零': 0, '〇': 0, '一': 1, '二': 2, '两': 2, '三': 3, '四': 4, '五': 5, '六': 6, '七': 7, '八': 8, '九': 9,
+	'壹': 1, '贰': 2, '叁': 3, '肆': 4, '伍': 5, '陆': 6, '柒': 7, '捌': 8, '玖': 9,
+}
+var cn_number_units = map[rune]float64{'十': 10, '拾': 10, '百': 100, '佰': 100, '千': 1000, '仟': 1000}
+var cn_number_big_units = map[rune]float64{'万': 1e4, '亿': 1e8}
+
+var en_number_words = map[string]float64{
+	"zero": 0, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6, "seven": 7, "eight": 8, "nine": 9,
+	"ten": 10, "eleven": 11, "twelve": 12, "thirteen": 13, "fourteen": 14, "fifteen": 15, "sixteen": 16,
+	"seventeen": 17, "eighteen": 18, "nineteen": 19, "twenty": 20, "thirty": 30, "forty": 40, "fifty": 50,
+	"sixty": 60, "seventy": 70, "eighty": 80, "ninety": 90,
+}
+var en_number_scales = map[string]float64{"hundred": 100, "thousand": 1e3, "million": 1e6, "billion": 1e9}
+
+var cn_number_run_reg = regexp.MustCompile(`(?:\d+(?:\.\d+)?)?[` + cn_number_chars + `][0-9` + cn_number_chars + `]*`)
+var number_reg = regexp.MustCompile(`[-+]?(?:\d{1,3}(?:,\d{3})+|\d+)(?:\.\d+)?`)
+var number_point_reg = regexp.MustCompile(`(\d)点(\d)`)
+var number_negative_reg = regexp.MustCompile(`负\s*(\d)`)
+
+// 中文数字转换为数值，支持 三百五十、两千零五、一万二、1.5万、3万5千、二零二四
+func ParseChineseNumber(text string) (float64, error) {
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) == 0 {
+		return 0, errors.New("数字不能为空")
+	}
+
+	negative := false
+	if runes[0] == '负' || runes[0] == '-' {
+		negative = true
+		runes = runes[1:]
+	}
+
+	//没有单位的逐位读，例如 二零二四
+	has_unit := false
+	for _, c := range runes {
+		_, unit := cn_number_units[c]
+		_, big_unit := cn_number_big_units[c]
+		if unit || big_unit || c == '点' || c == '.' {
+			has_unit = true
+			break
+		}
+	}
+	if !has_unit {
+		digits := ""
+		for _, c := range runes {
+			if d, ok := cn_number_digits[c]; ok {
+				digits += strconv.Itoa(int(d))
+			} else if c >= '0' && c <= '9' {
+				digits += string(c)
+			} else {
+				return 0, errors.New("数字格式不正确")
+			}
+		}
+		v, err := strconv.ParseFloat(digits, 64)
+		if negative {
+			v = -v
+		}
+		return v, err
+	}
+
+	var total, section, number, last_unit float64
+	zero := false
+	has_number := false
+	single := false //最后一个数是一位数，可以省略单位，例如 一百五
+
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		if (c >= '0' && c <= '9') || c == '.' {
+			j := i
+			for j < len(runes) && ((runes[j] >= '0' && runes[j] <= '9') || runes[j] == '.') {
+				j++
+			}
+			v, err := strconv.ParseFloat(string(runes[i:j]), 64)
+			if err != nil {
+				return 0, errors.New("数字格式不正确")
+			}
+			number = v
+			has_number = true
+			single = v < 10 && v == math.Trunc(v)
+			i = j - 1
+			continue
+		}
+		if d, ok := cn_number_digits[c]; ok {
+			if d == 0 {
+				zero = true
+				continue
+			}
+			number = d
+			has_number = true
+			single = true
+			continue
+		}
+		if c == '点' {
+			//小数部分逐位读
+			frac := ""
+			for _, f := range runes[i+1:] {
+				if d, ok := cn_number_digits[f]; ok {
+					frac += strconv.Itoa(int(d))
+				} else if f >= '0' && f <= '9' {
+					frac += string(f)
+				} else {
+					return 0, errors.New("数字格式不正确")
+				}
+			}
+			if len(frac) > 0 {
+				v, _ := strconv.ParseFloat("0."+frac, 64)
+				number += v
+				has_number = true
+			}
+			single = false
+			break
+		}
+		if u, ok := cn_number_units[c]; ok {
+			if !has_number {
+				number = 1 //十二
+			}
+			section += number * u
+			number, last_unit = 0, u
+			zero, has_number, single = false, false, false
+			continue
+		}
+		if u, ok := cn_number_big_units[c]; ok {
+			//前面没有数字的不是数字，例如 万一
+			if !has_number && section == 0 && total == 0 {
+				return 0, errors.New("数字格式不正确")
+			}
+			section += number
+			if u > 1e4 {
+				total = (total + section) * u
+			} else {
+				total += section * u
+			}
+			section, number, last_unit = 0, 0, u
+			zero, has_number, single = false, false, false
+			continue
+		}
+		return 0, errors.New("数字格式不正确")
+	}
+
+	//省略的单位：一百五 = 150、三万五 = 35000
+	if has_number && single && !zero && last_unit >= 100 {
+		number *= last_unit / 10
+	}
+
+	v := total + section + number
+	if negative {
+		v = -v
+	}
+	return v, nil
+}
+
+// 把文本中的中文数字替换为阿拉伯数字，无法识别的保持不变
+func ReplaceChineseNumbers(text string) string {
+	return cn_number_run_reg.ReplaceAllStringFunc(text, func(s string) string {
+		v, err := ParseChineseNumber(s)
+		if err != nil {
+			return s
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	})
+}
+
+// 全角数字和符号转换为半角
+func normalizeWidth(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '０' && r <= '９' {
+			return r - '０' + '0'
+		}
+		switch r {
+		case '：':
+			return ':'
+		case '．':
+			return '.'
+		case '，':
+			return ','
+		case '　':
+			return ' '
+		}
+		return r
+	}, text)
+}
+
+// 统一数字格式，中文数字转为阿拉伯数字，英文转为小写
+func normalizeText(text string) string {
+	text = normalizeWidth(strings.TrimSpace(text))
+	text = ReplaceChineseNumbers(text)
+	return strings.ToLower(text)
+}
+
+// 数字和金额中的 点 为小数点，时间中的 点 为钟点，分开处理
+func normalizeNumberText(text string) string {
+	text = number_point_reg.ReplaceAllString(normalizeText(text), "$1.$2")
+	return number_negative_reg.ReplaceAllString(text, "-$1")
+}
+
+// 英文数字单词转换为数值，例如 three hundred and fifty
+func parseEnglishNumber(text string) (float64, bool) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) })
+
+	var total, current float64
+	found := false
+	for _, w := range words {
+		if v, ok := en_number_words[w]; ok {
+			current += v
+			found = true
+			continue
+		}
+		if v, ok := en_number_scales[w]; ok && found {
+			if v == 100 {
+				current *= v
+			} else {
+				total += current * v
+				current = 0
+			}
+			continue
+		}
+		if w == "and" && found {
+			continue
+		}
+		if found {
+			break
+		}
+	}
+	return total + current, found
+}
+
+// 提取文本中的第一个数字，支持阿拉伯数字、中文数字和英文数字单词
+func NormalizeNumber(text string) (float64, error) {
+	data := number_reg.FindString(normalizeNumberText(text))
+	if len(data) > 0 {
+		return strconv.ParseFloat(strings.ReplaceAll(data, ",", ""), 64)
+	}
+	if v, ok := parseEnglishNumber(text); ok {
+		return v, nil
+	}
+	return 0, errors.New("没有找到数字")
+}
+
+// 货币关键词，包含关系的放在前面，例如 港元 在 元 之前
+var money_currencies = []struct {
+	Currency string
+	Words    []string
+}{
+	{"HKD", []string{"港币", "港元", "港幣", "hk$", "hkd"}},
+	{"JPY", []string{"日元", "日圆", "円", "jpy", "yen"}},
+	{"USD", []string{"美元", "美金", "us$", "usd", "dollar", "$"}},
+	{"EUR", []string{"欧元", "eur", "euro", "€"}},
+	{"GBP", []string{"英镑", "gbp", "pound", "£"}},
+	{"CNY", []string{"人民币", "rmb", "cny", "元", "块", "圆", "角", "毛", "¥", "￥"}},
+}
+
+var money_yuan_reg = regexp.MustCompile(`(\d[\d,]*(?:\.\d+)?)\s*(?:元|块|圆)(?:\s*(\d)\s*(?:角|毛))?(?:\s*(\d{1,2})\s*分)?`)
+var money_yuan_short_reg = regexp.MustCompile(`(\d[\d,]*)\s*(?:块|元)\s*(\d)\s*$`)
+var money_jiao_reg = regexp.MustCompile(`(\d)\s*(?:角|毛)(?:\s*(\d)\s*分?)?`)
+var money_k_reg = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*k(?:[^a-z]|$)`)
+
+// 提取金额，返回金额和货币代码，没有货币单位时货币代码为空
+// 支持 三百五十元、五块五、3元5角2分、¥1,200、$12.5、1.5k、twelve dollars
+func NormalizeMoney(text string) (float64, string, error) {
+	data := normalizeNumberText(text)
+
+	currency := ""
+	for _, c := range money_currencies {
+		for _, w := range c.Words {
+			if strings.Contains(data, w) {
+				currency = c.Currency
+				break
+			}
+		}
+		if len(currency) > 0 {
+			break
+		}
+	}
+
+	trimmed := strings.TrimRight(data, " 。.!！钱")
+
+	var amount float64
+	if m := money_yuan_short_reg.FindStringSubmatch(trimmed); m != nil {
+		//口语省略角：五块五
+		yuan, _ := strconv.ParseFloat(strings.ReplaceAll(m[1], ",", ""), 64)
+		jiao, _ := strconv.ParseFloat(m[2], 64)
+		amount = yuan + jiao/10
+	} else if m := money_yuan_reg.FindStringSubmatch(data); m != nil {
+		amount, _ = strconv.ParseFloat(strings.ReplaceAll(m[1], ",", ""), 64)
+		if len(m[2]) > 0 {
+			jiao, _ := strconv.ParseFloat(m[2], 64)
+			amount += jiao / 10
+		}
+		if len(m[3]) > 0 {
+			fen, _ := strconv.ParseFloat(m[3], 64)
+			amount += fen / 100
+		}
+	} else if m := money_jiao_reg.FindStringSubmatch(data); m != nil {
+		jiao, _ := strconv.ParseFloat(m[1], 64)
+		amount = jiao / 10
+		if len(m[2]) > 0 {
+			fen, _ := strconv.ParseFloat(m[2], 64)
+			amount += fen / 100
+		}
+	} else if m := money_k_reg.FindStringSubmatch(data); m != nil {
+		v, _ := strconv.ParseFloat(m[1], 64)
+		amount = v * 1000
+	} else {
+		v, err := NormalizeNumber(text)
+		if err != nil {
+			return 0, currency, errors.New("没有找到金额")
+		}
+		amount = v
+	}
+
+	return math.Round(amount*100) / 100, currency, nil
+}
+
+var date_ymd_reg = regexp.MustCompile(`(\d{4})\s*[-/.年]\s*(\d{1,2})\s*[-/.月]\s*(\d{1,2})`)
+var date_md_reg = regexp.MustCompile(`(\d{1,2})\s*月\s*(\d{1,2})\s*[日号號]?`)
+var date_month_day_reg = regexp.MustCompile(`(上个|下个|这个|上|下|本|这)月\s*(\d{1,2})\s*[日号號]`)
+var date_day_reg = regexp.MustCompile(`(\d{1,2})\s*[日号號]`)
+var date_offset_reg = regexp.MustCompile(`(\d+)\s*个?\s*(天|日|周|星期|礼拜|月|年)\s*(以后|之后|后|以前|之前|前)`)
+var date_weekday_reg = regexp.MustCompile(`(上上个|下下个|上上|下下|上个|下个|这个|上|下|本|这)?\s*(?:周|星期|礼拜)\s*([1-7日天])`)
+
+var en_month_names = `(january|february|march|april|may|june|july|august|september|october|november|december|jan|feb|mar|apr|jun|jul|aug|sept|sep|oct|nov|dec)`
+var en_weekday_names = `(monday|tuesday|wednesday|thursday|friday|saturday|sunday)`
+var en_number_names = `(\d+|a|an|one|two|three|four|five|six|seven|eight|nine|ten|eleven|twelve)`
+
+var date_en_md_reg = regexp.MustCompile(`\b` + en_month_names + `\.?\s+(\d{1,2})(?:st|nd|rd|th)?(?:,?\s*(\d{4}))?\b`)
+var date_en_dm_reg = regexp.MustCompile(`\b(\d{1,2})(?:st|nd|rd|th)?\s+(?:of\s+)?` + en_month_names + `\.?(?:,?\s*(\d{4}))?\b`)
+var date_en_in_reg = regexp.MustCompile(`\bin\s+` + en_number_names + `\s+(day|week|month|year)s?\b`)
+var date_en_ago_reg = regexp.MustCompile(`\b` + en_number_names + `\s+(day|week|month|year)s?\s+(ago|later|from now)\b`)
+var date_en_weekday_reg = regexp.MustCompile(`(?:\b(next|this|last|coming)\s+)?\b` + en_weekday_names + `\b`)
+
+// 相对日期关键词，长的放在前面
+var date_relative_words = []struct {
+	Word string
+	Days int
+}{
+	{"大后天", 3}, {"大前天", -3}, {"后天", 2}, {"前天", -2},
+	{"明天", 1}, {"明日", 1}, {"明早", 1}, {"明晚", 1},
+	{"今天", 0}, {"今日", 0}, {"今早", 0}, {"今晚", 0},
+	{"昨天", -1}, {"昨日", -1}, {"昨晚", -1},
+	{"day after tomorrow", 2}, {"day before yesterday", -2},
+	{"tomorrow", 1}, {"tonight", 0}, {"today", 0}, {"yesterday", -1},
+}
+
+var en_months = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+var en_weekdays = map[string]int{
+	"monday": 1, "tuesday": 2, "wednesday": 3, "thursday": 4, "friday": 5, "saturday": 6, "sunday": 7,
+}
+
+// 识别日期，返回当天零点，相对日期按照now计算
+// 支持 2024-03-05、3月5号、下个月5号、明天、3天后、下周五、next Tuesday、March 5th 等
+func NormalizeDate(text string, now time.Time) (time.Time, error) {
+	data := normalizeText(text)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	if m := date_ymd_reg.FindStringSubmatch(data); m != nil {
+		return makeDate(atoi(m[1]), atoi(m[2]), atoi(m[3]), now.Location())
+	}
+	if m := date_en_md_reg.FindStringSubmatch(data); m != nil {
+		year := now.Year()
+		if len(m[3]) > 0 {
+			year = atoi(m[3])
+		}
+		return makeDate(year, en_months[m[1][:3]], atoi(m[2]), now.Location())
+	}
+	if m := date_en_dm_reg.FindStringSubmatch(data); m != nil {
+		year := now.Year()
+		if len(m[3]) > 0 {
+			year = atoi(m[3])
+		}
+		return makeDate(year, en_months[m[2][:3]], atoi(m[1]), now.Location())
+	}
+	if m := date_month_day_reg.FindStringSubmatch(data); m != nil {
+		month := today.AddDate(0, 0, 1-today.Day())
+		switch m[1] {
+		case "上", "上个":
+			month = month.AddDate(0, -1, 0)
+		case "下", "下个":
+			month = month.AddDate(0, 1, 0)
+		}
+		return makeDate(month.Year(), int(month.Month()), atoi(m[2]), now.Location())
+	}
+	if m := date_md_reg.FindStringSubmatch(data); m != nil {
+		return makeDate(now.Year(), atoi(m[1]), atoi(m[2]), now.Location())
+	}
+
+	for _, w := range date_relative_words {
+		if strings.Contains(data, w.Word) {
+			return today.AddDate(0, 0, w.Days), nil
+		}
+	}
+
+	if m := date_offset_reg.FindStringSubmatch(data); m != nil {
+		n := atoi(m[1])
+		if strings.Contains(m[3], "前") {
+			n = -n
+		}
+		return offsetDate(today, n, m[2]), nil
+	}
+	if m := date_en_in_reg.FindStringSubmatch(data); m != nil {
+		return offsetDate(today, parseEnglishCount(m[1]), m[2]), nil
+	}
+	if m := date_en_ago_reg.FindStringSubmatch(data); m != nil {
+		n := parseEnglishCount(m[1])
+		if m[3] == "ago" {
+			n = -n
+		}
+		return offsetDate(today, n, m[2]), nil
+	}
+
+	if m := date_weekday_reg.FindStringSubmatch(data); m != nil {
+		weekday := 7
+		if m[2] != "日" && m[2] != "天" {
+			weekday = atoi(m[2])
+		}
+		switch m[1] {
+		case "上上", "上上个":
+			return weekdayDate(today, weekday, -2), nil
+		case "上", "上个":
+			return weekdayDate(today, weekday, -1), nil
+		case "下", "下个":
+			return weekdayDate(today, weekday, 1), nil
+		case "下下", "下下个":
+			return weekdayDate(today, weekday, 2), nil
+		case "本", "这", "这个":
+			return weekdayDate(today, weekday, 0), nil
+		}
+		//没有说明哪一周时取今天或之后最近的一天
+		return nextWeekdayDate(today, weekday), nil
+	}
+	if m := date_en_weekday_reg.FindStringSubmatch(data); m != nil {
+		weekday := en_weekdays[m[2]]
+		switch m[1] {
+		case "this":
+			return weekdayDate(today, weekday, 0), nil
+		case "next":
+			return weekdayDate(today, weekday, 1), nil
+		case "last":
+			//今天之前最近的一天
+			return nextWeekdayDate(today, weekday).AddDate(0, 0, -7), nil
+		}
+		return nextWeekdayDate(today, weekday), nil
+	}
+
+	if m := date_day_reg.FindStringSubmatch(data); m != nil {
+		return makeDate(now.Year(), int(now.Month()), atoi(m[1]), now.Location())
+	}
+
+	return time.Time{}, errors.New("没有找到日期")
+}
+
+// 星期几的日期，一周从星期一开始，weeks为相对本周的周数
+func weekdayDate(today time.Time, weekday int, weeks int) time.Time {
+	current := int(today.Weekday())
+	if current == 0 {
+		current = 7
+	}
+	monday := today.AddDate(0, 0, 1-current)
+	return monday.AddDate(0, 0, weeks*7+weekday-1)
+}
+
+// 今天或之后最近的星期几
+func nextWeekdayDate(today time.Time, weekday int) time.Time {
+	d := weekdayDate(today, weekday, 0)
+	if d.Before(today) {
+		d = d.AddDate(0, 0, 7)
+	}
+	return d
+}
+
+func offsetDate(today time.Time, n int, unit string) time.Time {
+	switch unit {
+	case "周", "星期", "礼拜", "week":
+		return today.AddDate(0, 0, n*7)
+	case "月", "month":
+		return today.AddDate(0, n, 0)
+	case "年", "year":
+		return today.AddDate(n, 0, 0)
+	}
+	return today.AddDate(0, 0, n)
+}
+
+func makeDate(year int, month int, day int, loc *time.Location) (time.Time, error) {
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, loc)
+	if t.Year() != year || int(t.Month()) != month || t.Day() != day {
+		return time.Time{}, errors.New("日期不存在")
+	}
+	return t, nil
+}
+
+func atoi(s string) int {
+	v, _ := strconv.Atoi(s)
+	return v
+}
+
+func parseEnglishCount(s string) int {
+	if s == "a" || s == "an" {
+		return 1
+	}
+	if v, ok := en_number_words[s]; ok {
+		return int(v)
+	}
+	return atoi(s)
+}
+
+var time_colon_reg = regexp.MustCompile(`(\d{1,2})\s*:\s*(\d{2})(?:\s*:\s*(\d{2}))?(?:\s*(am|pm|a\.m\.|p\.m\.))?`)
+var time_en_reg = regexp.MustCompile(`\b(\d{1,2})\s*(am|pm|a\.m\.|p\.m\.|o'clock)`)
+var time_en_at_reg = regexp.MustCompile(`\bat\s+(\d{1,2})\b`)
+var time_en_noon_reg = regexp.MustCompile(`\bnoon\b`)
+var time_en_midnight_reg = regexp.MustCompile(`\bmidnight\b`)
+var time_cn_reg = regexp.MustCompile(`(\d{1,2})\s*(?:点钟|点|時|时)(?:\s*(半|1刻|3刻|(\d{1,2})\s*分?))?`)
+
+// 时段关键词，长的放在前面
+var time_periods = []struct {
+	Word   string
+	Period string
+}{
+	{"凌晨", "am"}, {"早上", "am"}, {"早晨", "am"}, {"清晨", "am"}, {"上午", "am"}, {"今早", "am"}, {"明早", "am"},
+	{"中午", "noon"},
+	{"下午", "pm"}, {"傍晚", "pm"},
+	{"晚上", "night"}, {"夜里", "night"}, {"夜间", "night"}, {"今晚", "night"}, {"明晚", "night"}, {"昨晚", "night"},
+	{"morning", "am"}, {"afternoon", "pm"}, {"evening", "pm"}, {"tonight", "night"}, {"night", "night"},
+}
+
+// 识别时间，返回时、分、秒
+// 支持 15:30、下午三点半、晚上八点一刻、3pm、at 3 in the afternoon、noon 等
+func NormalizeTime(text string) (int, int, int, error) {
+	data := normalizeText(text)
+
+	period := ""
+	for _, p := range time_periods {
+		if strings.Contains(data, p.Word) {
+			period = p.Period
+			break
+		}
+	}
+
+	hour, minute, second := -1, 0, 0
+
+	if m := time_colon_reg.FindStringSubmatch(data); m != nil {
+		hour, minute = atoi(m[1]), atoi(m[2])
+		if len(m[3]) > 0 {
+			second = atoi(m[3])
+		}
+		if len(m[4]) > 0 {
+			period = strings.ReplaceAll(m[4], ".", "")
+		}
+	} else if m := time_en_reg.FindStringSubmatch(data); m != nil {
+		hour = atoi(m[1])
+		if m[2] != "o'clock" {
+			period = strings.ReplaceAll(m[2], ".", "")
+		}
+	} else if m := time_cn_reg.FindStringSubmatch(data); m != nil {
+		hour = atoi(m[1])
+		switch m[2] {
+		case "":
+		case "半":
+			minute = 30
+		case "1刻":
+			minute = 15
+		case "3刻":
+			minute = 45
+		default:
+			minute = atoi(m[3])
+		}
+	} else if m := time_en_at_reg.FindStringSubmatch(data); m != nil {
+		hour = atoi(m[1])
+	} else if time_en_noon_reg.MatchString(data) || strings.Contains(data, "正午") {
+		hour, period = 12, ""
+	} else if time_en_midnight_reg.MatchString(data) || strings.Contains(data, "午夜") {
+		hour, period = 0, ""
+	}
+
+	if hour < 0 {
+		return 0, 0, 0, errors.New("没有找到时间")
+	}
+
+	switch period {
+	case "pm":
+		if hour < 12 {
+			hour += 12
+		}
+	case "night":
+		//晚上12点是0点
+		if hour == 12 {
+			hour = 0
+		} else if hour < 12 {
+			hour += 12
+		}
+	case "noon":
+		if hour < 6 {
+			hour += 12
+		}
+	case "am":
+		if hour == 12 {
+			hour = 0
+		}
+	}
+
+	if hour > 23 || minute > 59 || second > 59 {
+		return 0, 0, 0, errors.New("时间不存在")
+	}
+	return hour, minute, second, nil
+}
+
+var datetime_after_reg = regexp.MustCompile(`(\d+(?:\.\d+)?|半)\s*个?\s*(小时|钟头|分钟)\s*(以后|之后|后)`)
+var datetime_en_after_reg = regexp.MustCompile(`\bin\s+(\d+(?:\.\d+)?|a|an|half an|one|two|three|four|five|six|seven|eight|nine|ten|twelve)\s+(hour|minute|min)s?\b`)
+
+// 识别日期和时间，没有日期时为当天，没有时间时为零点
+// 支持 明天下午三点、下周五 10:00、3小时后、in 30 minutes 等
+func NormalizeDateTime(text string, now time.Time) (time.Time, error) {
+	data := normalizeText(text)
+
+	if m := datetime_after_reg.FindStringSubmatch(data); m != nil {
+		n := 0.5
+		if m[1] != "半" {
+			n, _ = strconv.ParseFloat(m[1], 64)
+		}
+		unit := time.Minute
+		if m[2] != "分钟" {
+			unit = time.Hour
+		}
+		return now.Add(time.Duration(n * float64(unit))).Truncate(time.Second), nil
+	}
+	if m := datetime_en_after_reg.FindStringSubmatch(data); m != nil {
+		n := 0.5
+		if m[1] != "half an" {
+			if v, err := strconv.ParseFloat(m[1], 64); err == nil {
+				n = v
+			} else {
+				n = float64(parseEnglishCount(m[1]))
+			}
+		}
+		unit := time.Minute
+		if m[2] == "hour" {
+			unit = time.Hour
+		}
+		return now.Add(time.Duration(n * float64(unit))).Truncate(time.Second), nil
+	}
+
+	date, date_err := NormalizeDate(text, now)
+	hour, minute, second, time_err := NormalizeTime(text)
+	if date_err != nil && time_err != nil {
+		return time.Time{}, errors.New("没有找到时间")
+	}
+	if date_err != nil {
+		date = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	}
+	if time_err != nil {
+		hour, minute, second = 0, 0, 0
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), hour, minute, second, 0, now.Location()), nil
+}
@@ -0,0 +1,257 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// 2026-01-14 星期三
+var normalize_now = time.Date(2026, 1, 14, 10, 0, 0, 0, time.UTC)
+
+func TestParseChineseNumber(t *testing.T) {
+	cases := []struct {
+		text string
+		want float64
+		err  bool
+	}{
+		{"三百五十", 350, false},
+		{"两千零五", 2005, false},
+		{"十二", 12, false},
+		{"一百五", 150, false},
+		{"一万二", 12000, false},
+		{"一万一", 11000, false},
+		{"三万五千", 35000, false},
+		{"3万5千", 35000, false},
+		{"1.5万", 15000, false},
+		{"十万", 100000, false},
+		{"两亿", 2e8, false},
+		{"一亿零五百万", 105000000, false},
+		{"二零二四", 2024, false},
+		{"三点一四", 3.14, false},
+		{"负五", -5, false},
+		{"万一", 0, true},
+		{"亿", 0, true},
+		{"", 0, true},
+		{"三个", 0, true},
+	}
+	for _, c := range cases {
+		v, err := ParseChineseNumber(c.text)
+		if c.err {
+			if err == nil {
+				t.Errorf("ParseChineseNumber(%q) = %v, want error", c.text, v)
+			}
+			continue
+		}
+		if err != nil || v != c.want {
+			t.Errorf("ParseChineseNumber(%q) = %v, %v, want %v", c.text, v, err, c.want)
+		}
+	}
+}
+
+func TestReplaceChineseNumbers(t *testing.T) {
+	cases := []struct {
+		text string
+		want string
+	}{
+		{"买三个苹果", "买3个苹果"},
+		{"一万二千元", "12000元"},
+		{"万一下雨怎么办", "万一下雨怎么办"},
+		{"没有数字", "没有数字"},
+	}
+	for _, c := range cases {
+		if got := ReplaceChineseNumbers(c.text); got != c.want {
+			t.Errorf("ReplaceChineseNumbers(%q) = %q, want %q", c.text, got, c.want)
+		}
+	}
+}
+
+func TestNormalizeNumber(t *testing.T) {
+	cases := []struct {
+		text string
+		want float64
+		err  bool
+	}{
+		{"42", 42, false},
+		{"１２３", 123, false},
+		{"1,200", 1200, false},
+		{"-3.5度", -3.5, false},
+		{"负三度", -3, false},
+		{"三点五", 3.5, false},
+		{"twenty one", 21, false},
+		{"three hundred and fifty", 350, false},
+		{"two thousand", 2000, false},
+		{"没有", 0, true},
+	}
+	for _, c := range cases {
+		v, err := NormalizeNumber(c.text)
+		if c.err {
+			if err == nil {
+				t.Errorf("NormalizeNumber(%q) = %v, want error", c.text, v)
+			}
+			continue
+		}
+		if err != nil || v != c.want {
+			t.Errorf("NormalizeNumber(%q) = %v, %v, want %v", c.text, v, err, c.want)
+		}
+	}
+}
+
+func TestNormalizeMoney(t *testing.T) {
+	cases := []struct {
+		text     string
+		amount   float64
+		currency string
+		err      bool
+	}{
+		{"三百五十元", 350, "CNY", false},
+		{"五块五", 5.5, "CNY", false},
+		{"3元5角2分", 3.52, "CNY", false},
+		{"5毛", 0.5, "CNY", false},
+		{"1,200元", 1200, "CNY", false},
+		{"1,200块5", 1200.5, "CNY", false},
+		{"¥1,200", 1200, "CNY", false},
+		{"一万二千块", 12000, "CNY", false},
+		{"1.5万元", 15000, "CNY", false},
+		{"$12.5", 12.5, "USD", false},
+		{"1.5k", 1500, "", false},
+		{"100港币", 100, "HKD", false},
+		{"twelve dollars", 12, "USD", false},
+		{"不知道", 0, "", true},
+	}
+	for _, c := range cases {
+		amount, currency, err := NormalizeMoney(c.text)
+		if c.err {
+			if err == nil {
+				t.Errorf("NormalizeMoney(%q) = %v, want error", c.text, amount)
+			}
+			continue
+		}
+		if err != nil || amount != c.amount || currency != c.currency {
+			t.Errorf("NormalizeMoney(%q) = %v %q, %v, want %v %q", c.text, amount, currency, err, c.amount, c.currency)
+		}
+	}
+}
+
+func TestNormalizeDate(t *testing.T) {
+	cases := []struct {
+		text string
+		want string
+		err  bool
+	}{
+		{"2024-03-05", "2024-03-05", false},
+		{"2024年3月5日", "2024-03-05", false},
+		{"3月5号", "2026-03-05", false},
+		{"三月五号", "2026-03-05", false},
+		{"下个月5号", "2026-02-05", false},
+		{"上个月5号", "2025-12-05", false},
+		{"20号", "2026-01-20", false},
+		{"明天", "2026-01-15", false},
+		{"后天", "2026-01-16", false},
+		{"大前天", "2026-01-11", false},
+		{"3天后", "2026-01-17", false},
+		{"两周后", "2026-01-28", false},
+		{"一个月以前", "2025-12-14", false},
+		{"下周五", "2026-01-23", false},
+		{"上周一", "2026-01-05", false},
+		{"这周日", "2026-01-18", false},
+		{"周一", "2026-01-19", false},
+		{"周三", "2026-01-14", false},
+		{"tomorrow", "2026-01-15", false},
+		{"next Tuesday", "2026-01-20", false},
+		{"last friday", "2026-01-09", false},
+		{"March 5th", "2026-03-05", false},
+		{"5th of March, 2027", "2027-03-05", false},
+		{"in 3 days", "2026-01-17", false},
+		{"two weeks ago", "2025-12-31", false},
+		{"2月30号", "", true},
+		{"grammar 3", "", true},
+		{"没有日期", "", true},
+	}
+	for _, c := range cases {
+		d, err := NormalizeDate(c.text, normalize_now)
+		if c.err {
+			if err == nil {
+				t.Errorf("NormalizeDate(%q) = %v, want error", c.text, d)
+			}
+			continue
+		}
+		if err != nil || d.Format("2006-01-02") != c.want {
+			t.Errorf("NormalizeDate(%q) = %v, %v, want %s", c.text, d, err, c.want)
+		}
+	}
+}
+
+func TestNormalizeTime(t *testing.T) {
+	cases := []struct {
+		text   string
+		hour   int
+		minute int
+		second int
+		err    bool
+	}{
+		{"15:30", 15, 30, 0, false},
+		{"8:05:09", 8, 5, 9, false},
+		{"３：１５", 3, 15, 0, false},
+		{"下午三点半", 15, 30, 0, false},
+		{"晚上八点一刻", 20, 15, 0, false},
+		{"上午十点二十分", 10, 20, 0, false},
+		{"凌晨12点", 0, 0, 0, false},
+		{"晚上12点", 0, 0, 0, false},
+		{"中午12点", 12, 0, 0, false},
+		{"中午1点", 13, 0, 0, false},
+		{"下午12点", 12, 0, 0, false},
+		{"3pm", 15, 0, 0, false},
+		{"12am", 0, 0, 0, false},
+		{"7 o'clock", 7, 0, 0, false},
+		{"10:30 p.m.", 22, 30, 0, false},
+		{"at 3 in the afternoon", 15, 0, 0, false},
+		{"at 9", 9, 0, 0, false},
+		{"noon", 12, 0, 0, false},
+		{"midnight", 0, 0, 0, false},
+		{"25:00", 0, 0, 0, true},
+		{"没有时间", 0, 0, 0, true},
+	}
+	for _, c := range cases {
+		h, m, s, err := NormalizeTime(c.text)
+		if c.err {
+			if err == nil {
+				t.Errorf("NormalizeTime(%q) = %d:%d:%d, want error", c.text, h, m, s)
+			}
+			continue
+		}
+		if err != nil || h != c.hour || m != c.minute || s != c.second {
+			t.Errorf("NormalizeTime(%q) = %d:%d:%d, %v, want %d:%d:%d", c.text, h, m, s, err, c.hour, c.minute, c.second)
+		}
+	}
+}
+
+func TestNormalizeDateTime(t *testing.T) {
+	cases := []struct {
+		text string
+		want string
+		err  bool
+	}{
+		{"明天下午三点", "2026-01-15 15:00:00", false},
+		{"下周五 10:00", "2026-01-23 10:00:00", false},
+		{"3小时后", "2026-01-14 13:00:00", false},
+		{"半小时后", "2026-01-14 10:30:00", false},
+		{"in 30 minutes", "2026-01-14 10:30:00", false},
+		{"in an hour", "2026-01-14 11:00:00", false},
+		{"tomorrow at 9", "2026-01-15 09:00:00", false},
+		{"后天", "2026-01-16 00:00:00", false},
+		{"晚上8点", "2026-01-14 20:00:00", false},
+		{"没有", "", true},
+	}
+	for _, c := range cases {
+		d, err := NormalizeDateTime(c.text, normalize_now)
+		if c.err {
+			if err == nil {
+				t.Errorf("NormalizeDateTime(%q) = %v, want error", c.text, d)
+			}
+			continue
+		}
+		if err != nil || d.Format("2006-01-02 15:04:05") != c.want {
+			t.Errorf("NormalizeDateTime(%q) = %v, %v, want %s", c.text, d, err, c.want)
+		}
+	}
+}